@@ -0,0 +1,283 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+)
+
+// maxAliasDepth is how many aliases may expand into each other before giving up
+const maxAliasDepth = 10
+
+// checkArgs are the arguments aliases are expanded with when they're defined, enough for every
+// placeholder
+const checkArgs = "1 2 3 4 5 6 7 8 9"
+
+// aliasParam matches the $1 to $9 and $@ placeholders of an alias
+var aliasParam = regexp.MustCompile(`\$([1-9@])`)
+
+// Alias is a user-defined shortcut which expands into another command invocation
+type Alias struct {
+	Name      string
+	Expansion string
+}
+
+// Aliases holds the aliases defined at runtime, as opposed to the ones built into commands with
+// WithAliases. The expansion of an alias may use $1 to $9 for its arguments and $@ for all of
+// them; without placeholders the arguments are appended. Aliases are persisted as JSON so they
+// survive restarts.
+type Aliases struct {
+	path    string
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+// NewAliases loads the aliases from the given file. A missing file means there are no aliases.
+func NewAliases(path string) (*Aliases, error) {
+	a := &Aliases{
+		path:    path,
+		aliases: make(map[string]string),
+	}
+	if err := loadJSON(path, &a.aliases); err != nil {
+		return nil, fmt.Errorf("failed to load aliases: %w", err)
+	}
+	return a, nil
+}
+
+// Get returns the expansion of the alias with the given name. A nil Aliases has no aliases.
+func (a *Aliases) Get(name string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	expansion, ok := a.aliases[name]
+	return expansion, ok
+}
+
+// List returns every alias sorted by name
+func (a *Aliases) List() []Alias {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]Alias, 0, len(a.aliases))
+	for name, expansion := range a.aliases {
+		list = append(list, Alias{Name: name, Expansion: expansion})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Set defines or replaces an alias. Names are single words of letters, digits and underscores.
+// Aliases that would expand into themselves or through too many aliases are rejected.
+func (a *Aliases) Set(name, expansion string) error {
+	if !isWord(name) {
+		return UserErrorf("alias names may only contain letters, digits and underscores")
+	}
+	expansion = strings.TrimSpace(expansion)
+	if expansion == "" {
+		return UserErrorf("the expansion of an alias can't be empty")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Expand the alias as if it were already defined, so cycles are caught before they're saved
+	lookup := func(n string) (string, bool) {
+		if n == name {
+			return expansion, true
+		}
+		e, ok := a.aliases[n]
+		return e, ok
+	}
+	if _, _, err := expandAliases(lookup, name, checkArgs); err != nil {
+		return err
+	}
+
+	a.aliases[name] = expansion
+	return a.save()
+}
+
+// Remove deletes an alias, reporting whether it existed
+func (a *Aliases) Remove(name string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.aliases[name]; !ok {
+		return false, nil
+	}
+	delete(a.aliases, name)
+	return true, a.save()
+}
+
+// save persists the aliases. The caller must hold the write lock.
+func (a *Aliases) save() error {
+	if err := saveJSON(a.path, a.aliases); err != nil {
+		return fmt.Errorf("failed to save aliases: %w", err)
+	}
+	return nil
+}
+
+// Expand expands the alias with the given name and argument text, following aliases that
+// expand into other aliases. It returns the name of the command to run and its argument text.
+func (a *Aliases) Expand(name, args string) (string, string, error) {
+	return expandAliases(a.Get, name, args)
+}
+
+// expandAliases expands the alias with the given name, looking up expansions with lookup
+func expandAliases(lookup func(name string) (string, bool), name, args string) (string, string, error) {
+	chain := []string{name}
+	for {
+		expansion, ok := lookup(name)
+		if !ok {
+			return name, args, nil
+		}
+
+		expanded, err := expandAlias(name, expansion, args)
+		if err != nil {
+			return "", "", err
+		}
+		name, args = splitWord(expanded)
+
+		if _, ok := lookup(name); ok {
+			for _, seen := range chain {
+				if seen == name {
+					return "", "", UserErrorf("alias %s expands into itself: %s", chain[0], strings.Join(append(chain, name), " → "))
+				}
+			}
+			if len(chain) >= maxAliasDepth {
+				return "", "", UserErrorf("alias %s expands through more than %d aliases", chain[0], maxAliasDepth)
+			}
+		}
+		chain = append(chain, name)
+	}
+}
+
+// expandAlias substitutes the placeholders in an alias expansion with the given arguments, or
+// appends the arguments if there are no placeholders
+func expandAlias(name, expansion, args string) (string, error) {
+	if !aliasParam.MatchString(expansion) {
+		return strings.TrimSpace(expansion + " " + args), nil
+	}
+
+	fields := splitFields(args)
+	var missing int
+	expanded := aliasParam.ReplaceAllStringFunc(expansion, func(param string) string {
+		if param == "$@" {
+			return args
+		}
+		n, _ := strconv.Atoi(param[1:])
+		if n > len(fields) {
+			missing = max(missing, n)
+			return ""
+		}
+		return fields[n-1]
+	})
+	if missing > 0 {
+		return "", UserErrorf("alias %s expects %d or more arguments, got %d", name, missing, len(fields))
+	}
+	return strings.TrimSpace(expanded), nil
+}
+
+// splitFields splits text into whitespace separated fields, keeping double quoted strings
+// together along with their quotes so they're parsed as a single argument after expansion
+func splitFields(text string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// isWord reports whether text is a non-empty word of letters, digits and underscores
+func isWord(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, c := range text {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// invokedAlias returns the alias a message invokes with one of the chat's prefixes, along with
+// the prefix and the argument text. It returns an empty name if the message invokes no alias.
+func (r *Registry) invokedAlias(text string, chatID int64) (string, string, string) {
+	prefix, ok := matchPrefix(text, r.prefixes.For(chatID))
+	if !ok {
+		return "", "", ""
+	}
+	name, args := splitWord(strings.TrimPrefix(text, prefix))
+	if _, ok := r.aliases.Get(name); !ok {
+		return "", "", ""
+	}
+	// Built-in commands always win over aliases of the same name
+	if cmd, _ := r.FindCommand(name); cmd != nil {
+		return "", "", ""
+	}
+	return name, prefix, args
+}
+
+// registerAliases adds a handler which expands user-defined aliases and runs the command they
+// expand into, as if it was invoked directly
+func (r *Registry) registerAliases(d dispatcher.Dispatcher) {
+	filter := func(m *types.Message) bool {
+		name, _, _ := r.invokedAlias(m.Text, messageChatID(m))
+		return name != ""
+	}
+
+	d.AddHandler(handlers.NewMessage(filter, func(ctx *ext.Context, u *ext.Update) error {
+		m := u.EffectiveMessage
+		name, prefix, args := r.invokedAlias(m.Text, u.EffectiveChat().GetID())
+
+		target, rest, err := r.aliases.Expand(name, args)
+		if err == nil {
+			cmd, module := r.FindCommand(target)
+			if cmd == nil {
+				err = UserErrorf("alias %s expands into unknown command %s", name, target)
+			} else if (m.Out && !cmd.Outgoing) || (!m.Out && !cmd.Incoming) {
+				return nil
+			} else {
+				return cmd.invoke(ctx, u, r, module, prefix, rest)
+			}
+		}
+
+		// Only the owner gets to see broken aliases
+		if !m.Out || !r.Responses().Track(u.EffectiveChat().GetID(), m.ID, m.Text) {
+			return nil
+		}
+		respond := &Arguments{Prefix: prefix, Edited: isEdit(u), responses: r.Responses(), telegram: r.Telegram()}
+		return respond.RespondText(ctx, u, "❌ "+err.Error())
+	}))
+}
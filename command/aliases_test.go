@@ -0,0 +1,158 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestAliases returns aliases stored in a temporary directory with the given definitions
+func newTestAliases(t *testing.T, defs map[string]string) *Aliases {
+	t.Helper()
+	a, err := NewAliases(filepath.Join(t.TempDir(), "aliases.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Set rejects cycles, so they're defined directly
+	for name, expansion := range defs {
+		a.aliases[name] = expansion
+	}
+	return a
+}
+
+func TestAliases_Expand(t *testing.T) {
+	tests := []struct {
+		name     string
+		defs     map[string]string
+		alias    string
+		args     string
+		wantName string
+		wantArgs string
+		wantErr  bool
+	}{
+		{
+			name:     "not an alias",
+			alias:    "echo",
+			args:     "hello",
+			wantName: "echo",
+			wantArgs: "hello",
+		},
+		{
+			name:     "arguments are appended",
+			defs:     map[string]string{"tr": "translate -to en"},
+			alias:    "tr",
+			args:     "hola mundo",
+			wantName: "translate",
+			wantArgs: "-to en hola mundo",
+		},
+		{
+			name:     "numbered placeholders",
+			defs:     map[string]string{"tr": "translate -to $2 $1"},
+			alias:    "tr",
+			args:     `"hola mundo" de`,
+			wantName: "translate",
+			wantArgs: `-to de "hola mundo"`,
+		},
+		{
+			name:     "ninth placeholder",
+			defs:     map[string]string{"last": "echo $9"},
+			alias:    "last",
+			args:     "1 2 3 4 5 6 7 8 9",
+			wantName: "echo",
+			wantArgs: "9",
+		},
+		{
+			name:     "all arguments",
+			defs:     map[string]string{"shout": "echo -upper $@ !"},
+			alias:    "shout",
+			args:     "hello there",
+			wantName: "echo",
+			wantArgs: "-upper hello there !",
+		},
+		{
+			name:    "missing arguments",
+			defs:    map[string]string{"tr": "translate -to $2 $1"},
+			alias:   "tr",
+			args:    "hola",
+			wantErr: true,
+		},
+		{
+			name:     "aliases of aliases",
+			defs:     map[string]string{"de": "tr de", "tr": "translate -to $1"},
+			alias:    "de",
+			args:     "hallo",
+			wantName: "translate",
+			wantArgs: "-to de",
+		},
+		{
+			name:    "alias expanding into itself",
+			defs:    map[string]string{"loop": "loop again"},
+			alias:   "loop",
+			wantErr: true,
+		},
+		{
+			name:    "cycle through other aliases",
+			defs:    map[string]string{"a": "b", "b": "c", "c": "a"},
+			alias:   "a",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAliases(t, tt.defs)
+			name, args, err := a.Expand(tt.alias, tt.args)
+			if tt.wantErr {
+				if !IsUserError(err) {
+					t.Errorf("Expand() error = %v, want a user error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand() error = %v", err)
+			}
+			if name != tt.wantName || args != tt.wantArgs {
+				t.Errorf("Expand() = %q, %q, want %q, %q", name, args, tt.wantName, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestAliases_ExpandDepth(t *testing.T) {
+	// a0 expands into a1 and so on, with the last one expanding into echo
+	chain := func(n int) map[string]string {
+		defs := make(map[string]string)
+		for i := 0; i < n; i++ {
+			defs[fmt.Sprintf("a%d", i)] = fmt.Sprintf("a%d", i+1)
+		}
+		defs[fmt.Sprintf("a%d", n)] = "echo"
+		return defs
+	}
+
+	a := newTestAliases(t, chain(maxAliasDepth-1))
+	if name, _, err := a.Expand("a0", ""); err != nil || name != "echo" {
+		t.Errorf("Expand() through %d aliases = %q, %v, want echo", maxAliasDepth, name, err)
+	}
+
+	a = newTestAliases(t, chain(maxAliasDepth))
+	if _, _, err := a.Expand("a0", ""); !IsUserError(err) {
+		t.Errorf("Expand() through %d aliases error = %v, want a user error", maxAliasDepth+1, err)
+	}
+}
+
+func TestAliases_SetRejectsCycles(t *testing.T) {
+	a := newTestAliases(t, map[string]string{"b": "c $1", "c": "echo"})
+
+	if err := a.Set("a", "b"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := a.Set("c", "a"); !IsUserError(err) {
+		t.Errorf("Set() of a cycle error = %v, want a user error", err)
+	}
+	if err := a.Set("self", "self"); !IsUserError(err) {
+		t.Errorf("Set() of an alias expanding into itself error = %v, want a user error", err)
+	}
+	if expansion, _ := a.Get("c"); expansion != "echo" {
+		t.Errorf("Get() after a rejected Set() = %q, want echo", expansion)
+	}
+}
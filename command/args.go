@@ -2,8 +2,10 @@ package command
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/celestix/gotgproto/ext"
 	"github.com/celestix/gotgproto/functions"
@@ -19,11 +21,54 @@ const (
 	TypeInt
 	TypeFloat
 	TypeBool
-	TypeEntity   // For resolving usernames/phone-numbers/ids
-	TypeReply    // For accessing replied-to message content
-	TypeDuration // For parsing human-readable duration strings
+	TypeEntity      // For resolving usernames/phone-numbers/ids
+	TypeReply       // For accessing replied-to message content
+	TypeDuration    // For parsing human-readable duration strings
+	TypeChoice      // One of the definition's Choices
+	TypeList        // Comma-separated list of values of the definition's Elem type
+	TypeRegex       // Regular expression, compiled to a *regexp.Regexp
+	TypeChat        // Username, ID or link of a chat, resolved later with ResolveChat
+	TypeMessageLink // Link to a message, parsed into a MessageLink
+	TypeURL         // Absolute http(s) URL, parsed into a *url.URL
+	TypeTime        // Time of day like 14:30 or 2:30pm, parsed into a TimeOfDay
 )
 
+// String returns a human-readable name for the argument type
+func (t ArgumentType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeBool:
+		return "bool"
+	case TypeEntity:
+		return "entity"
+	case TypeReply:
+		return "reply"
+	case TypeDuration:
+		return "duration"
+	case TypeChoice:
+		return "choice"
+	case TypeList:
+		return "list"
+	case TypeRegex:
+		return "regex"
+	case TypeChat:
+		return "chat"
+	case TypeMessageLink:
+		return "message link"
+	case TypeURL:
+		return "url"
+	case TypeTime:
+		return "time"
+	default:
+		return "unknown"
+	}
+}
+
 // ArgumentKind represents how an argument is specified in the command
 type ArgumentKind int
 
@@ -32,6 +77,18 @@ const (
 	KindNamed
 )
 
+// String returns a human-readable name for the argument kind
+func (k ArgumentKind) String() string {
+	switch k {
+	case KindPositional:
+		return "positional"
+	case KindNamed:
+		return "named"
+	default:
+		return "unknown"
+	}
+}
+
 // ArgumentDefinition defines an expected argument for a command
 type ArgumentDefinition struct {
 	Name        string       // Name of the argument
@@ -40,6 +97,8 @@ type ArgumentDefinition struct {
 	Required    bool         // Whether the argument is required
 	Default     interface{}  // Default value if not provided
 	Description string       // Description for help text
+	Choices     []string     // Allowed values for TypeChoice arguments
+	Elem        ArgumentType // Type of each element of TypeList arguments, TypeString if unset
 }
 
 // ParsedArgument represents a parsed command argument
@@ -66,6 +125,17 @@ type Arguments struct {
 	Rest       *ParsedArgument // Holds all remaining text after flags and positional args
 	Raw        string
 	Reply      *types.Message // Holds the replied-to message if present
+	Command    *Command       // The command being invoked, nil for triggers
+	Trigger    *Trigger       // The trigger being fired, nil for commands
+	Matches    []string       // Capture groups of a trigger's match, with the whole match first
+	Prefix     string         // The prefix the command was invoked with
+	Edited     bool           // Whether the command is being re-run because its message was edited
+
+	responses *Responses
+	input     *string     // Output of the previous command in a pipeline
+	pipe      *pipeOutput // Captures the output when piped into another command
+	job       *Job        // Job the command is running as, if any
+	telegram  Telegram    // API the command responds through, the client's if nil
 }
 
 // ResolveEntity attempts to resolve a named entity argument to a Telegram user
@@ -307,38 +377,81 @@ func (a *Arguments) GetRestString() string {
 	return a.GetRest()
 }
 
-// parseValue attempts to parse a string value into the specified type
-func parseValue(value string, argType ArgumentType) (interface{}, error) {
-	switch argType {
+// parseValue attempts to parse a string value into the type of the given definition
+func parseValue(value string, def ArgumentDefinition) (interface{}, error) {
+	switch def.Type {
 	case TypeString:
 		return value, nil
 	case TypeInt:
-		return strconv.Atoi(value)
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected a whole number, got %q", value)
+		}
+		return i, nil
 	case TypeFloat:
-		return strconv.ParseFloat(value, 64)
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", value)
+		}
+		return f, nil
 	case TypeBool:
-		return strconv.ParseBool(value)
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", value)
+		}
+		return b, nil
 	case TypeEntity:
 		// For entities, we store the raw value and resolve it later when we have context
 		return value, nil
 	case TypeDuration:
 		d, err := hdur.ParseDuration(value)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("expected a duration like 1h30m, got %q", value)
 		}
 		return d, nil
+	case TypeChoice:
+		return parseChoice(value, def.Choices)
+	case TypeList:
+		return parseList(value, def)
+	case TypeRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s", strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+		}
+		return re, nil
+	case TypeChat:
+		return parseChat(value)
+	case TypeMessageLink:
+		return ParseMessageLink(value)
+	case TypeURL:
+		return parseURL(value)
+	case TypeTime:
+		return ParseTimeOfDay(value)
 	default:
 		return nil, fmt.Errorf("unsupported argument type")
 	}
 }
 
-// ParseArguments parses command arguments according to the provided definitions
+// ParseArguments parses command arguments according to the provided definitions. Unknown flags
+// that look like a typo of a defined flag are reported, while others are treated as text.
 func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message) (*Arguments, error) {
+	return parseArguments(text, defs, msg, false)
+}
+
+// ParseArgumentsStrict is like ParseArguments, but reports every unknown flag instead of
+// treating it as text
+func ParseArgumentsStrict(text string, defs []ArgumentDefinition, msg *types.Message) (*Arguments, error) {
+	return parseArguments(text, defs, msg, true)
+}
+
+func parseArguments(text string, defs []ArgumentDefinition, msg *types.Message, strict bool) (*Arguments, error) {
 	args := &Arguments{
 		Named:      make(map[string]ParsedArgument),
 		Positional: make([]ParsedArgument, 0),
 		Raw:        text,
-		Reply:      msg.ReplyToMessage,
+	}
+	if msg != nil {
+		args.Reply = msg.ReplyToMessage
 	}
 
 	// Initialize runes for character-by-character parsing
@@ -351,10 +464,11 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 		pos++
 	}
 
-	// Count positional definitions
+	// Count positional definitions. Reply arguments are taken from the replied-to message
+	// rather than the command text, so they never consume a positional slot.
 	var positionalCount int
 	for _, def := range defs {
-		if def.Kind == KindPositional {
+		if def.Kind == KindPositional && def.Type != TypeReply {
 			positionalCount++
 		}
 	}
@@ -425,15 +539,10 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 					}
 					value = builder.String()
 				} else {
-					var builder strings.Builder
-					for pos < length && runes[pos] != ' ' {
-						builder.WriteRune(runes[pos])
-						pos++
-					}
-					value = builder.String()
+					value, pos = readValue(runes, pos, def.Type == TypeList)
 				}
 
-				parsedValue, err := parseValue(value, def.Type)
+				parsedValue, err := parseValue(value, *def)
 				if err != nil {
 					return nil, &ArgumentError{def.Name, err.Error()}
 				}
@@ -443,6 +552,8 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 					RawValue: value,
 				}
 				continue
+			} else if err := unknownFlag(name.String(), defs, strict); err != nil {
+				return nil, err
 			} else {
 				// Not a valid flag, try as positional
 				pos = start
@@ -470,13 +581,18 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 				break
 			}
 
-			// Find matching positional definition
+			// Find the definition for the next positional argument
 			var def *ArgumentDefinition
+			var index int
 			for _, d := range defs {
-				if d.Kind == KindPositional && positionalIndex == len(args.Positional) {
+				if d.Kind != KindPositional || d.Type == TypeReply {
+					continue
+				}
+				if index == positionalIndex {
 					def = &d
 					break
 				}
+				index++
 			}
 
 			if def != nil {
@@ -497,15 +613,10 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 					}
 					value = builder.String()
 				} else {
-					var builder strings.Builder
-					for pos < length && runes[pos] != ' ' {
-						builder.WriteRune(runes[pos])
-						pos++
-					}
-					value = builder.String()
+					value, pos = readValue(runes, pos, def.Type == TypeList)
 				}
 
-				parsedValue, err := parseValue(value, def.Type)
+				parsedValue, err := parseValue(value, *def)
 				if err != nil {
 					return nil, &ArgumentError{def.Name, err.Error()}
 				}
@@ -559,3 +670,52 @@ func ParseArguments(text string, defs []ArgumentDefinition, msg *types.Message)
 
 	return args, nil
 }
+
+// readValue reads an unquoted value starting at pos up to the next space, returning it along
+// with the position after it. List values go on past spaces following a comma, so "1, 2, 3"
+// is read as a single list.
+func readValue(runes []rune, pos int, list bool) (string, int) {
+	var builder strings.Builder
+	for pos < len(runes) {
+		if runes[pos] == ' ' {
+			next := pos
+			for next < len(runes) && runes[next] == ' ' {
+				next++
+			}
+			if !list || next >= len(runes) || !strings.HasSuffix(builder.String(), ",") {
+				break
+			}
+			pos = next
+		}
+		builder.WriteRune(runes[pos])
+		pos++
+	}
+	return builder.String(), pos
+}
+
+// unknownFlag returns an error for a flag that isn't defined if it looks like a typo of a
+// defined flag, or for any flag in strict mode. Flags that don't start with a letter, such as
+// negative numbers, are never reported.
+func unknownFlag(name string, defs []ArgumentDefinition, strict bool) error {
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		return nil
+	}
+
+	var flags []string
+	for _, def := range defs {
+		if def.Kind == KindNamed {
+			flags = append(flags, def.Name)
+		}
+	}
+
+	if suggestions := Suggest(name, flags, 1); len(suggestions) > 0 {
+		return &ArgumentError{name, fmt.Sprintf("unknown flag, did you mean -%s?", suggestions[0])}
+	}
+	if !strict {
+		return nil
+	}
+	if len(flags) == 0 {
+		return &ArgumentError{name, "unknown flag, this command doesn't take any flags"}
+	}
+	return &ArgumentError{name, "unknown flag, expected one of -" + strings.Join(flags, ", -")}
+}
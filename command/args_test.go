@@ -1,6 +1,7 @@
 package command
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -138,6 +139,179 @@ func TestParseArguments(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "choice",
+			text: "-cow Dragon",
+			defs: []ArgumentDefinition{
+				{Name: "cow", Type: TypeChoice, Kind: KindNamed, Choices: []string{"cow", "dragon"}},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				if args.GetChoice("cow") != "dragon" {
+					t.Errorf("expected cow to be 'dragon', got '%s'", args.GetChoice("cow"))
+				}
+			},
+		},
+		{
+			name: "invalid choice",
+			text: "-cow horse",
+			defs: []ArgumentDefinition{
+				{Name: "cow", Type: TypeChoice, Kind: KindNamed, Choices: []string{"cow", "dragon"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "typed list",
+			text: "1,2, 3",
+			defs: []ArgumentDefinition{
+				{Name: "ids", Type: TypeList, Elem: TypeInt, Kind: KindPositional},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				ids := args.GetPositionalList(0)
+				if len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+					t.Errorf("expected ids to be [1 2 3], got %v", ids)
+				}
+			},
+		},
+		{
+			name: "named list stops at a space without a comma",
+			text: "-ids 4, 5 rest of text",
+			defs: []ArgumentDefinition{
+				{Name: "ids", Type: TypeList, Elem: TypeInt, Kind: KindNamed},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				ids := args.GetIntList("ids")
+				if len(ids) != 2 || ids[0] != 4 || ids[1] != 5 {
+					t.Errorf("expected ids to be [4 5], got %v", ids)
+				}
+				if args.GetRestString() != "rest of text" {
+					t.Errorf("expected rest to be 'rest of text', got '%s'", args.GetRestString())
+				}
+			},
+		},
+		{
+			name: "invalid list element",
+			text: "1,two,3",
+			defs: []ArgumentDefinition{
+				{Name: "ids", Type: TypeList, Elem: TypeInt, Kind: KindPositional},
+			},
+			wantErr: true,
+		},
+		{
+			name: "regex",
+			text: `-match ^fo+$`,
+			defs: []ArgumentDefinition{
+				{Name: "match", Type: TypeRegex, Kind: KindNamed},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				re := args.GetRegex("match")
+				if re == nil || !re.MatchString("fooo") {
+					t.Errorf("expected regex to match 'fooo', got %v", re)
+				}
+			},
+		},
+		{
+			name: "invalid regex",
+			text: `-match (unclosed`,
+			defs: []ArgumentDefinition{
+				{Name: "match", Type: TypeRegex, Kind: KindNamed},
+			},
+			wantErr: true,
+		},
+		{
+			name: "chat and message links",
+			text: "-100123456 https://t.me/c/123456/7/42 t.me/durov/10",
+			defs: []ArgumentDefinition{
+				{Name: "chat", Type: TypeChat, Kind: KindPositional},
+				{Name: "private", Type: TypeMessageLink, Kind: KindPositional},
+				{Name: "public", Type: TypeMessageLink, Kind: KindPositional},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				if chat, _ := args.GetPositionalChat(0); chat.ID != 123456 {
+					t.Errorf("expected chat ID 123456, got %+v", chat)
+				}
+				private, _ := args.GetPositionalMessageLink(1)
+				if private.Chat.ID != 123456 || private.ThreadID != 7 || private.MessageID != 42 {
+					t.Errorf("unexpected private link %+v", private)
+				}
+				public, _ := args.GetPositionalMessageLink(2)
+				if public.Chat.Username != "durov" || public.MessageID != 10 {
+					t.Errorf("unexpected public link %+v", public)
+				}
+			},
+		},
+		{
+			name: "invalid message link",
+			text: "https://example.com/c/1/2",
+			defs: []ArgumentDefinition{
+				{Name: "link", Type: TypeMessageLink, Kind: KindPositional},
+			},
+			wantErr: true,
+		},
+		{
+			name: "url",
+			text: "-url https://example.com/path?q=1",
+			defs: []ArgumentDefinition{
+				{Name: "url", Type: TypeURL, Kind: KindNamed},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				if u := args.GetURL("url"); u == nil || u.Host != "example.com" {
+					t.Errorf("expected host 'example.com', got %v", u)
+				}
+			},
+		},
+		{
+			name: "url without scheme",
+			text: "-url example.com",
+			defs: []ArgumentDefinition{
+				{Name: "url", Type: TypeURL, Kind: KindNamed},
+			},
+			wantErr: true,
+		},
+		{
+			name: "time of day",
+			text: "14:30 2:05pm 12am",
+			defs: []ArgumentDefinition{
+				{Name: "first", Type: TypeTime, Kind: KindPositional},
+				{Name: "second", Type: TypeTime, Kind: KindPositional},
+				{Name: "third", Type: TypeTime, Kind: KindPositional},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				want := []TimeOfDay{{Hour: 14, Minute: 30}, {Hour: 14, Minute: 5}, {Hour: 0}}
+				for i, w := range want {
+					if got, _ := args.GetPositionalTime(i); got != w {
+						t.Errorf("expected time %d to be %v, got %v", i, w, got)
+					}
+				}
+			},
+		},
+		{
+			name: "invalid time of day",
+			text: "25:00",
+			defs: []ArgumentDefinition{
+				{Name: "time", Type: TypeTime, Kind: KindPositional},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mistyped flag",
+			text: "-durration 1h",
+			defs: []ArgumentDefinition{
+				{Name: "duration", Type: TypeDuration, Kind: KindNamed},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unrelated dash text",
+			text: "-5 -fooBar",
+			defs: []ArgumentDefinition{
+				{Name: "duration", Type: TypeDuration, Kind: KindNamed},
+			},
+			check: func(t *testing.T, args *Arguments) {
+				if args.GetRest() != "-5 -fooBar" {
+					t.Errorf("expected rest to be '-5 -fooBar', got '%s'", args.GetRest())
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -153,3 +327,24 @@ func TestParseArguments(t *testing.T) {
 		})
 	}
 }
+
+func TestParseArgumentsStrict(t *testing.T) {
+	defs := []ArgumentDefinition{
+		{Name: "silent", Type: TypeBool, Kind: KindNamed},
+	}
+
+	_, err := ParseArgumentsStrict("-loud", defs, nil)
+	argErr, ok := err.(*ArgumentError)
+	if !ok || argErr.Argument != "loud" {
+		t.Fatalf("expected an argument error for 'loud', got %v", err)
+	}
+
+	_, err = ParseArgumentsStrict("-silnt", defs, nil)
+	if err == nil || !strings.Contains(err.Error(), "did you mean -silent?") {
+		t.Errorf("expected a suggestion for -silent, got %v", err)
+	}
+
+	if _, err := ParseArgumentsStrict("-silent -42", defs, nil); err != nil {
+		t.Errorf("expected negative numbers to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,380 @@
+package command
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/functions"
+	"github.com/gotd/td/tg"
+)
+
+// usernamePattern matches valid Telegram usernames
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{3,31}$`)
+
+// ChatRef identifies a chat by username or ID. It is resolved to a peer with ResolveChat.
+type ChatRef struct {
+	Username string // Username without the @, empty if the chat was given by ID
+	ID       int64  // Bare chat or channel ID, 0 if the chat was given by username
+}
+
+// String returns the chat reference as it would be written on the command line
+func (c ChatRef) String() string {
+	if c.Username != "" {
+		return "@" + c.Username
+	}
+	return strconv.FormatInt(c.ID, 10)
+}
+
+// MessageLink is a parsed link to a message, such as t.me/c/1234567890/42 for private chats or
+// t.me/username/42 for public ones
+type MessageLink struct {
+	Chat      ChatRef
+	ThreadID  int // Topic or comment thread the message was linked in, 0 if none
+	MessageID int
+}
+
+// TimeOfDay is a wall-clock time without a date
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+	Second int
+}
+
+// String returns the time in 24-hour HH:MM or HH:MM:SS form
+func (t TimeOfDay) String() string {
+	if t.Second != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+	}
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+// Next returns the first moment at or after from that matches the time of day, in from's
+// location
+func (t TimeOfDay) Next(from time.Time) time.Time {
+	next := time.Date(from.Year(), from.Month(), from.Day(), t.Hour, t.Minute, t.Second, 0, from.Location())
+	if next.Before(from) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// parseChoice matches the value case-insensitively against the allowed choices, returning the
+// choice as it was defined
+func parseChoice(value string, choices []string) (string, error) {
+	for _, choice := range choices {
+		if strings.EqualFold(value, choice) {
+			return choice, nil
+		}
+	}
+	return "", fmt.Errorf("expected one of %s, got %q", strings.Join(choices, ", "), value)
+}
+
+// parseList splits a comma-separated value and parses every element as the definition's Elem
+// type
+func parseList(value string, def ArgumentDefinition) ([]interface{}, error) {
+	if def.Elem == TypeList || def.Elem == TypeReply {
+		return nil, fmt.Errorf("lists can't contain %s values", def.Elem)
+	}
+
+	elemDef := def
+	elemDef.Type = def.Elem
+
+	parts := strings.Split(value, ",")
+	items := make([]interface{}, 0, len(parts))
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("item %d is empty", i+1)
+		}
+		item, err := parseValue(part, elemDef)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i+1, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseChat parses a chat given as a username, a t.me link or an ID. IDs in the Bot API format,
+// like -1001234567890 for channels, are converted to bare IDs.
+func parseChat(value string) (ChatRef, error) {
+	raw := value
+	for _, prefix := range []string{"https://", "http://"} {
+		raw = strings.TrimPrefix(raw, prefix)
+	}
+	if rest, ok := strings.CutPrefix(raw, "t.me/"); ok {
+		raw = strings.TrimSuffix(rest, "/")
+		if id, ok := strings.CutPrefix(raw, "c/"); ok {
+			raw = id
+		}
+	}
+
+	if id, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if bare, ok := strings.CutPrefix(raw, "-100"); ok {
+			id, _ = strconv.ParseInt(bare, 10, 64)
+		} else if id < 0 {
+			id = -id
+		}
+		if id == 0 {
+			return ChatRef{}, fmt.Errorf("invalid chat ID %q", value)
+		}
+		return ChatRef{ID: id}, nil
+	}
+
+	username := strings.TrimPrefix(raw, "@")
+	if !usernamePattern.MatchString(username) {
+		return ChatRef{}, fmt.Errorf("expected a chat username, ID or t.me link, got %q", value)
+	}
+	return ChatRef{Username: username}, nil
+}
+
+// ParseMessageLink parses a link to a message. Both private (t.me/c/<chat>/<message>) and public
+// (t.me/<username>/<message>) links are supported, optionally with a topic ID before the
+// message ID.
+func ParseMessageLink(value string) (MessageLink, error) {
+	invalid := fmt.Errorf("expected a message link like https://t.me/c/1234567890/42, got %q", value)
+
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		// Links are often pasted without a scheme
+		u, err = url.Parse("https://" + value)
+		if err != nil {
+			return MessageLink{}, invalid
+		}
+	}
+	if host := strings.TrimPrefix(u.Host, "www."); host != "t.me" && host != "telegram.me" {
+		return MessageLink{}, invalid
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var link MessageLink
+	if parts[0] == "c" {
+		parts = parts[1:]
+		if len(parts) < 2 {
+			return MessageLink{}, invalid
+		}
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || id <= 0 {
+			return MessageLink{}, fmt.Errorf("invalid chat ID %q in message link", parts[0])
+		}
+		link.Chat.ID = id
+	} else {
+		if len(parts) < 2 || !usernamePattern.MatchString(parts[0]) {
+			return MessageLink{}, invalid
+		}
+		link.Chat.Username = parts[0]
+	}
+
+	ids := parts[1:]
+	if len(ids) > 2 {
+		return MessageLink{}, invalid
+	}
+	for i, raw := range ids {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			return MessageLink{}, fmt.Errorf("invalid message ID %q in message link", raw)
+		}
+		if i == len(ids)-1 {
+			link.MessageID = id
+		} else {
+			link.ThreadID = id
+		}
+	}
+
+	// Links to a message in a comment thread carry the thread as a query parameter
+	if thread := u.Query().Get("thread"); thread != "" && link.ThreadID == 0 {
+		link.ThreadID, _ = strconv.Atoi(thread)
+	}
+	return link, nil
+}
+
+// parseURL parses an absolute http or https URL
+func parseURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q", value)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http or https URL, got %q", value)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL %q has no host", value)
+	}
+	return u, nil
+}
+
+// timePattern matches times of day like 9:30, 21:05:10, 9pm and 9:30am
+var timePattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(?::(\d{2}))?\s*([aApP][mM])?$`)
+
+// ParseTimeOfDay parses a time of day in 24-hour (14:30, 14:30:15) or 12-hour (2pm, 2:30pm)
+// form. A bare hour is only accepted with an am/pm suffix.
+func ParseTimeOfDay(value string) (TimeOfDay, error) {
+	m := timePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil || (m[2] == "" && m[4] == "") {
+		return TimeOfDay{}, fmt.Errorf("expected a time like 14:30 or 2:30pm, got %q", value)
+	}
+
+	var t TimeOfDay
+	t.Hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		t.Minute, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		t.Second, _ = strconv.Atoi(m[3])
+	}
+
+	if suffix := strings.ToLower(m[4]); suffix != "" {
+		if t.Hour < 1 || t.Hour > 12 {
+			return TimeOfDay{}, fmt.Errorf("hour must be between 1 and 12 with %s, got %d", suffix, t.Hour)
+		}
+		if t.Hour == 12 {
+			t.Hour = 0
+		}
+		if suffix == "pm" {
+			t.Hour += 12
+		}
+	}
+
+	switch {
+	case t.Hour > 23:
+		return TimeOfDay{}, fmt.Errorf("hour must be between 0 and 23, got %d", t.Hour)
+	case t.Minute > 59:
+		return TimeOfDay{}, fmt.Errorf("minute must be between 0 and 59, got %d", t.Minute)
+	case t.Second > 59:
+		return TimeOfDay{}, fmt.Errorf("second must be between 0 and 59, got %d", t.Second)
+	}
+	return t, nil
+}
+
+// ResolveChat resolves a chat reference to an input peer, using the peer storage for IDs and
+// resolving usernames through Telegram
+func ResolveChat(ctx *ext.Context, chat ChatRef) (tg.InputPeerClass, error) {
+	if chat.Username != "" {
+		resolved, err := ctx.ResolveUsername(chat.Username)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve chat @%s: %w", chat.Username, err)
+		}
+		return resolved.GetInputPeer(), nil
+	}
+
+	if peer := functions.GetInputPeerClassFromId(ctx.PeerStorage, chat.ID); peer != nil {
+		return peer, nil
+	}
+	return nil, fmt.Errorf("unknown chat %d, try using its username or -fill-peer-storage", chat.ID)
+}
+
+// GetChoice returns the value of a named choice argument
+func (a *Arguments) GetChoice(name string) string {
+	return a.GetString(name)
+}
+
+// GetList returns the elements of a named list argument
+func (a *Arguments) GetList(name string) []interface{} {
+	if v, ok := a.Get(name).([]interface{}); ok {
+		return v
+	}
+	return nil
+}
+
+// GetStringList returns the elements of a named list argument of strings or choices
+func (a *Arguments) GetStringList(name string) []string {
+	var items []string
+	for _, item := range a.GetList(name) {
+		if s, ok := item.(string); ok {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// GetIntList returns the elements of a named list argument of ints
+func (a *Arguments) GetIntList(name string) []int {
+	var items []int
+	for _, item := range a.GetList(name) {
+		if i, ok := item.(int); ok {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// GetRegex returns the compiled value of a named regex argument
+func (a *Arguments) GetRegex(name string) *regexp.Regexp {
+	if re, ok := a.Get(name).(*regexp.Regexp); ok {
+		return re
+	}
+	return nil
+}
+
+// GetChat returns the value of a named chat argument
+func (a *Arguments) GetChat(name string) (ChatRef, bool) {
+	chat, ok := a.Get(name).(ChatRef)
+	return chat, ok
+}
+
+// GetMessageLink returns the value of a named message link argument
+func (a *Arguments) GetMessageLink(name string) (MessageLink, bool) {
+	link, ok := a.Get(name).(MessageLink)
+	return link, ok
+}
+
+// GetURL returns the value of a named URL argument
+func (a *Arguments) GetURL(name string) *url.URL {
+	if u, ok := a.Get(name).(*url.URL); ok {
+		return u
+	}
+	return nil
+}
+
+// GetTime returns the value of a named time of day argument
+func (a *Arguments) GetTime(name string) (TimeOfDay, bool) {
+	t, ok := a.Get(name).(TimeOfDay)
+	return t, ok
+}
+
+// GetPositionalList returns the elements of a positional list argument
+func (a *Arguments) GetPositionalList(index int) []interface{} {
+	if v, ok := a.GetPositional(index).([]interface{}); ok {
+		return v
+	}
+	return nil
+}
+
+// GetPositionalRegex returns the compiled value of a positional regex argument
+func (a *Arguments) GetPositionalRegex(index int) *regexp.Regexp {
+	if re, ok := a.GetPositional(index).(*regexp.Regexp); ok {
+		return re
+	}
+	return nil
+}
+
+// GetPositionalChat returns the value of a positional chat argument
+func (a *Arguments) GetPositionalChat(index int) (ChatRef, bool) {
+	chat, ok := a.GetPositional(index).(ChatRef)
+	return chat, ok
+}
+
+// GetPositionalMessageLink returns the value of a positional message link argument
+func (a *Arguments) GetPositionalMessageLink(index int) (MessageLink, bool) {
+	link, ok := a.GetPositional(index).(MessageLink)
+	return link, ok
+}
+
+// GetPositionalURL returns the value of a positional URL argument
+func (a *Arguments) GetPositionalURL(index int) *url.URL {
+	if u, ok := a.GetPositional(index).(*url.URL); ok {
+		return u
+	}
+	return nil
+}
+
+// GetPositionalTime returns the value of a positional time of day argument
+func (a *Arguments) GetPositionalTime(index int) (TimeOfDay, bool) {
+	t, ok := a.GetPositional(index).(TimeOfDay)
+	return t, ok
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/watzon/macron/logger"
+)
+
+// UserError is an error caused by how a command was used, such as a missing reply or an
+// unknown user. Its message is shown to the user in the chat the command was invoked from.
+type UserError struct {
+	Message string
+}
+
+func (e *UserError) Error() string {
+	return e.Message
+}
+
+// UserErrorf creates a new UserError with a formatted message
+func UserErrorf(format string, args ...interface{}) error {
+	return &UserError{Message: fmt.Sprintf(format, args...)}
+}
+
+// InternalError is an unexpected failure while running a command. It is reported to the log
+// channel along with the stack trace captured when it was created.
+type InternalError struct {
+	Err   error
+	Stack []byte
+}
+
+func (e *InternalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// Internal wraps an error as an InternalError, capturing the current stack trace. Errors that
+// are already an InternalError are returned unchanged.
+func Internal(err error) error {
+	if err == nil {
+		return nil
+	}
+	var internal *InternalError
+	if errors.As(err, &internal) {
+		return err
+	}
+	return &InternalError{Err: err, Stack: debug.Stack()}
+}
+
+// IsUserError reports whether the error should be shown to the user rather than treated as an
+// internal failure, which is the case for user and argument errors
+func IsUserError(err error) bool {
+	var userErr *UserError
+	var argErr *ArgumentError
+	return errors.As(err, &userErr) || errors.As(err, &argErr)
+}
+
+// renderError responds to the invoking message with a description of the error. Internal errors
+// are also sent to the log channel together with their stack trace, if one was captured.
+func renderError(ctx *ext.Context, u *ext.Update, args *Arguments, err error) error {
+	var opts []styling.StyledTextOption
+
+	// Throttled invocations carry their own message, and are silently dropped without one
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		if throttled.Message == "" {
+			return nil
+		}
+		return args.RespondText(ctx, u, throttled.Message)
+	}
+
+	var argErr *ArgumentError
+	switch {
+	case errors.As(err, &argErr):
+		opts = append(opts,
+			styling.Plain("❌ Invalid argument "),
+			styling.Code(argErr.Argument),
+			styling.Plain(": "+argErr.Message),
+		)
+	case IsUserError(err):
+		opts = append(opts, styling.Plain("❌ "+err.Error()))
+	default:
+		var stack []byte
+		var internal *InternalError
+		if errors.As(err, &internal) {
+			stack = internal.Stack
+		}
+		logger.ErrorWithStack(fmt.Sprintf("Command %s failed: %v", args.name(), err), stack)
+
+		opts = append(opts,
+			styling.Plain("⚠️ Something went wrong while running "),
+			styling.Code(args.Prefix+args.name()),
+			styling.Plain(": "+err.Error()),
+		)
+	}
+
+	if IsUserError(err) && args.Command != nil && args.Command.Usage != "" {
+		opts = append(opts,
+			styling.Plain("\nUsage: "),
+			styling.Code(args.Prefix+args.Command.Usage),
+		)
+	}
+
+	return args.Respond(ctx, u, opts...)
+}
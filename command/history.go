@@ -0,0 +1,261 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/watzon/macron/logger"
+	"gorm.io/gorm"
+)
+
+// historyRetention is how long invocations are kept before they're pruned
+const historyRetention = 30 * 24 * time.Hour
+
+// Outcome is how an invocation ended
+type Outcome string
+
+const (
+	OutcomeOK        Outcome = "ok"         // The command succeeded
+	OutcomeFailed    Outcome = "failed"     // The command failed with an internal error
+	OutcomeUserError Outcome = "user_error" // The command was used wrong
+	OutcomeThrottled Outcome = "throttled"  // The command was invoked too often
+	OutcomeCancelled Outcome = "cancelled"  // The command's job was cancelled
+)
+
+// Invocation is a recorded run of a command
+type Invocation struct {
+	ID        uint   `gorm:"primaryKey"`
+	Command   string `gorm:"index"` // Full name of the command, including parent commands
+	Prefix    string // Prefix the command was invoked with
+	Raw       string // Argument text the command was invoked with
+	ChatID    int64  `gorm:"index"`
+	SenderID  int64  // User who invoked the command, 0 if not sent by a user
+	Duration  time.Duration
+	Outcome   Outcome   `gorm:"index"`
+	Error     string    // Error the command failed with, if any
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName returns the name of the table invocations are stored in
+func (Invocation) TableName() string {
+	return "macron_invocations"
+}
+
+// Text returns the message text that invokes the command the same way again
+func (i Invocation) Text() string {
+	return strings.TrimSpace(i.Prefix + i.Command + " " + i.Raw)
+}
+
+// Failed reports whether the invocation ended in an error
+func (i Invocation) Failed() bool {
+	return i.Outcome == OutcomeFailed || i.Outcome == OutcomeUserError
+}
+
+// CommandStats summarizes the recorded invocations of a single command
+type CommandStats struct {
+	Command  string
+	Count    int
+	Failures int // Invocations that failed, internally or because of how they were used
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+}
+
+// FailureRate returns the share of invocations that failed, between 0 and 1
+func (s CommandStats) FailureRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Count)
+}
+
+// History records every command invocation in the session database
+type History struct {
+	db *gorm.DB
+}
+
+// NewHistory creates the invocations table if needed and prunes invocations older than the
+// retention period
+func NewHistory(db *gorm.DB) (*History, error) {
+	if err := db.AutoMigrate(&Invocation{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate invocations table: %w", err)
+	}
+
+	h := &History{db: db}
+	if err := h.Prune(time.Now().Add(-historyRetention)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Record saves an invocation
+func (h *History) Record(inv *Invocation) error {
+	if err := h.db.Create(inv).Error; err != nil {
+		return fmt.Errorf("failed to save invocation: %w", err)
+	}
+	return nil
+}
+
+// Get returns the invocation with the given ID, or nil if there is none
+func (h *History) Get(id uint) (*Invocation, error) {
+	var invocations []Invocation
+	if err := h.db.Where("id = ?", id).Limit(1).Find(&invocations).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invocation: %w", err)
+	}
+	if len(invocations) == 0 {
+		return nil, nil
+	}
+	return &invocations[0], nil
+}
+
+// Recent returns the last n invocations, newest first
+func (h *History) Recent(n int) ([]Invocation, error) {
+	var invocations []Invocation
+	if err := h.db.Order("id DESC").Limit(n).Find(&invocations).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invocations: %w", err)
+	}
+	return invocations, nil
+}
+
+// Stats summarizes the invocations since the given time per command, most used first.
+// Throttled invocations never ran, so they're left out.
+func (h *History) Stats(since time.Time) ([]CommandStats, error) {
+	var invocations []Invocation
+	err := h.db.Select("command", "duration", "outcome").
+		Where("created_at >= ? AND outcome <> ?", since, OutcomeThrottled).
+		Find(&invocations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invocations: %w", err)
+	}
+
+	durations := make(map[string][]time.Duration)
+	stats := make(map[string]*CommandStats)
+	for _, inv := range invocations {
+		s, ok := stats[inv.Command]
+		if !ok {
+			s = &CommandStats{Command: inv.Command}
+			stats[inv.Command] = s
+		}
+		s.Count++
+		if inv.Failed() {
+			s.Failures++
+		}
+		durations[inv.Command] = append(durations[inv.Command], inv.Duration)
+	}
+
+	result := make([]CommandStats, 0, len(stats))
+	for name, s := range stats {
+		d := durations[name]
+		sort.Slice(d, func(a, b int) bool { return d[a] < d[b] })
+		s.P50, s.P90, s.P99 = percentile(d, 50), percentile(d, 90), percentile(d, 99)
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Count != result[b].Count {
+			return result[a].Count > result[b].Count
+		}
+		return result[a].Command < result[b].Command
+	})
+	return result, nil
+}
+
+// Prune deletes the invocations recorded before the given time
+func (h *History) Prune(before time.Time) error {
+	if err := h.db.Where("created_at < ?", before).Delete(&Invocation{}).Error; err != nil {
+		return fmt.Errorf("failed to prune invocations: %w", err)
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile of sorted durations using the nearest-rank method
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// outcome classifies how an invocation ended
+func outcome(args *Arguments, err error) Outcome {
+	var throttled *ThrottledError
+	switch {
+	case args.job != nil && args.job.Cancelled():
+		return OutcomeCancelled
+	case err == nil:
+		return OutcomeOK
+	case errors.As(err, &throttled):
+		return OutcomeThrottled
+	case errors.Is(err, context.Canceled):
+		return OutcomeCancelled
+	case IsUserError(err):
+		return OutcomeUserError
+	default:
+		return OutcomeFailed
+	}
+}
+
+// Audit records every command invocation in the history. It must run inside ErrorReply to see
+// the errors commands fail with. Triggers aren't recorded.
+func Audit(history *History) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			if args.Command == nil {
+				return next(ctx, u, args)
+			}
+
+			start := time.Now()
+			err := next(ctx, u, args)
+
+			// The arguments now belong to the subcommand that ran, if any
+			inv := &Invocation{
+				Command:  args.name(),
+				Prefix:   args.Prefix,
+				Raw:      args.Raw,
+				ChatID:   u.EffectiveChat().GetID(),
+				SenderID: senderID(u.EffectiveMessage),
+				Duration: time.Since(start),
+				Outcome:  outcome(args, err),
+			}
+			if err != nil {
+				inv.Error = err.Error()
+			}
+			if recordErr := history.Record(inv); recordErr != nil {
+				logger.Error("Failed to record invocation of %s: %v", inv.Command, recordErr)
+			}
+			return err
+		}
+	}
+}
+
+// Rerun runs a recorded invocation again in response to the message of the update, as if the
+// message invoked it. The invocation is recorded again like any other.
+func (r *Registry) Rerun(ctx *ext.Context, u *ext.Update, inv *Invocation) error {
+	// Subcommands run through the chain of their top-level command
+	name, rest := splitWord(inv.Command)
+	cmd, module := r.FindCommand(name)
+	if cmd == nil || cmd.chain == nil {
+		return UserErrorf("command %s no longer exists", inv.Command)
+	}
+	if !r.Enabled(cmd, module) {
+		return UserErrorf("command %s is disabled", inv.Command)
+	}
+
+	return cmd.chain(ctx, u, &Arguments{
+		Raw:       strings.TrimSpace(rest + " " + inv.Raw),
+		Reply:     u.EffectiveMessage.ReplyToMessage,
+		Command:   cmd,
+		Prefix:    inv.Prefix,
+		Edited:    isEdit(u),
+		responses: r.Responses(),
+		telegram:  r.Telegram(),
+	})
+}
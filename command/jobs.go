@@ -0,0 +1,220 @@
+package command
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+)
+
+// progressInterval is the shortest time between two progress updates of a job, to stay clear of
+// Telegram's flood limits when editing the status message
+const progressInterval = 2 * time.Second
+
+// Job is a running invocation of a long-running command. Jobs can report their progress by
+// editing a status message and be cancelled with the cancel command.
+type Job struct {
+	ID        int
+	Name      string    // Full name of the command
+	ChatID    int64     // Chat the command was invoked in
+	TriggerID int       // ID of the message that invoked the command
+	Started   time.Time // When the command was invoked
+
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	progress     string
+	statusID     int // ID of the status message, or 0 before any progress was reported
+	lastProgress time.Time
+	finalized    bool // Whether the command responded since it last reported progress
+	cancelled    bool
+}
+
+// Progress returns the last progress the job reported
+func (j *Job) Progress() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// StatusID returns the ID of the job's status message, or 0 if it didn't report progress yet
+func (j *Job) StatusID() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.statusID
+}
+
+// Cancel cancels the job's context. The command is expected to return shortly after.
+func (j *Job) Cancel() {
+	j.mu.Lock()
+	j.cancelled = true
+	j.mu.Unlock()
+	j.cancel()
+}
+
+// Cancelled reports whether the job was cancelled
+func (j *Job) Cancelled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cancelled
+}
+
+// Jobs tracks the jobs that are running
+type Jobs struct {
+	mu   sync.Mutex
+	next int
+	jobs map[int]*Job
+}
+
+// NewJobs creates an empty job tracker
+func NewJobs() *Jobs {
+	return &Jobs{jobs: make(map[int]*Job)}
+}
+
+// Get returns the running job with the given ID, or nil if there is none
+func (j *Jobs) Get(id int) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.jobs[id]
+}
+
+// ForMessage returns the running job invoked by or reporting progress in the given message, or
+// nil if there is none
+func (j *Jobs) ForMessage(chatID int64, messageID int) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, job := range j.jobs {
+		if job.ChatID == chatID && (job.TriggerID == messageID || job.StatusID() == messageID) {
+			return job
+		}
+	}
+	return nil
+}
+
+// List returns the running jobs, oldest first
+func (j *Jobs) List() []*Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs
+}
+
+// CancelAll cancels every running job, such as when shutting down
+func (j *Jobs) CancelAll() {
+	for _, job := range j.List() {
+		job.Cancel()
+	}
+}
+
+// start tracks a new job running with a context derived from parent
+func (j *Jobs) start(parent context.Context, name string, chatID int64, triggerID int) (*Job, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.next++
+	job := &Job{
+		ID:        j.next,
+		Name:      name,
+		ChatID:    chatID,
+		TriggerID: triggerID,
+		Started:   time.Now(),
+		cancel:    cancel,
+	}
+	j.jobs[job.ID] = job
+	return job, ctx
+}
+
+// finish stops tracking a job and releases its context
+func (j *Jobs) finish(job *Job) {
+	j.mu.Lock()
+	delete(j.jobs, job.ID)
+	j.mu.Unlock()
+	job.cancel()
+}
+
+// Middleware runs the handler as a job. The handler's context is cancelled when the job is
+// cancelled, in which case the status message reports it. A status message the handler didn't
+// respond in is deleted once it's done.
+func (j *Jobs) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			chatID := u.EffectiveChat().GetID()
+			job, jobCtx := j.start(ctx.Context, args.name(), chatID, u.EffectiveMessage.ID)
+			defer j.finish(job)
+
+			// Handlers get a copy of the context that's cancelled along with the job
+			jctx := *ctx
+			jctx.Context = jobCtx
+			args.job = job
+
+			err := next(&jctx, u, args)
+			if job.Cancelled() {
+				return args.RespondText(ctx, u, "⏹ Cancelled")
+			}
+
+			job.mu.Lock()
+			statusID, finalized := job.statusID, job.finalized
+			job.mu.Unlock()
+			if statusID != 0 && !finalized {
+				if args.responses != nil {
+					args.responses.SetResponse(chatID, job.TriggerID, 0)
+				}
+				if delErr := args.Telegram().Delete(ctx, u, statusID); delErr != nil && err == nil {
+					return delErr
+				}
+			}
+			return err
+		}
+	}
+}
+
+// Job returns the job the command is running as, or nil if the command isn't a job
+func (a *Arguments) Job() *Job {
+	return a.job
+}
+
+// Progress reports the progress of a long-running command by responding with the given text.
+// The status message is edited with later progress and, once the command responds, with its
+// result. Updates sent in quick succession are dropped, as are all updates while piped.
+func (a *Arguments) Progress(ctx *ext.Context, u *ext.Update, text string) error {
+	if a.pipe != nil {
+		return nil
+	}
+
+	if a.job != nil {
+		a.job.mu.Lock()
+		skip := a.job.statusID != 0 && time.Since(a.job.lastProgress) < progressInterval
+		a.job.progress = text
+		if !skip {
+			a.job.lastProgress = time.Now()
+		}
+		a.job.mu.Unlock()
+		if skip {
+			return nil
+		}
+	}
+
+	if err := a.Respond(ctx, u, styling.Plain("⏳ "+text)); err != nil {
+		return err
+	}
+
+	if a.job != nil && a.responses != nil {
+		statusID := a.responses.Response(u.EffectiveChat().GetID(), u.EffectiveMessage.ID)
+		a.job.mu.Lock()
+		a.job.statusID = statusID
+		a.job.finalized = false
+		a.job.mu.Unlock()
+	}
+	return nil
+}
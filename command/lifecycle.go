@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/celestix/gotgproto"
+	"github.com/watzon/macron/config"
+	"github.com/watzon/macron/storage"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Deps holds the dependencies handed to modules when they're initialized, so modules don't
+// have to reach for globals
+type Deps struct {
+	Config   *config.Config
+	Client   *gotgproto.Client
+	Logger   *zap.Logger
+	DB       *gorm.DB
+	Store    *storage.Store
+	Services *Services
+}
+
+// Namespace returns the storage namespace of the given module, keyed by its name
+func (d *Deps) Namespace(module Module) *storage.Namespace {
+	return d.Store.Namespace(module.Name())
+}
+
+// Services holds the shared services available to modules. Services that aren't configured,
+// such as the LLM service without an API key, are nil.
+type Services struct {
+	LLM LLM
+}
+
+// LLM generates text with a language model. It is implemented by services.LLMService, and kept
+// as an interface so the command package doesn't depend on the model's client.
+type LLM interface {
+	GenerateText(ctx context.Context, prompt string) (string, error)
+	TranslateText(ctx context.Context, text string, targetLanguage string) (string, error)
+}
+
+// Initializer is implemented by modules that need their dependencies before being loaded. Init
+// is called before RegisterAll, so modules can still add commands depending on what's
+// configured.
+type Initializer interface {
+	Init(deps *Deps) error
+}
+
+// Migrator is implemented by modules that migrate their stored data. Pending migrations are
+// applied to the module's namespace right before it's initialized.
+type Migrator interface {
+	Migrations() []storage.Migration
+}
+
+// Starter is implemented by modules that run background work once the client is up
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is implemented by modules that need to release resources or stop background work
+// on shutdown. Stop should return once the work has stopped or the context is done.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// Init applies the pending migrations of every module implementing Migrator and initializes
+// every module implementing Initializer, in the order they were added
+func (r *Registry) Init(deps *Deps) error {
+	for _, module := range r.modules {
+		if migrator, ok := module.(Migrator); ok && deps.Store != nil {
+			applied, err := deps.Store.Migrate(module.Name(), migrator.Migrations()...)
+			if err != nil {
+				return fmt.Errorf("failed to migrate module %s: %w", module.Name(), err)
+			}
+			for _, id := range applied {
+				deps.Logger.Info("Applied migration", zap.String("module", module.Name()), zap.String("migration", id))
+			}
+		}
+		if initializer, ok := module.(Initializer); ok {
+			if err := initializer.Init(deps); err != nil {
+				return fmt.Errorf("failed to initialize module %s: %w", module.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Start starts every module implementing Starter, in the order they were added. If a module
+// fails to start, the modules started before it are stopped again.
+func (r *Registry) Start(ctx context.Context) error {
+	for i, module := range r.modules {
+		starter, ok := module.(Starter)
+		if !ok {
+			continue
+		}
+		if err := starter.Start(ctx); err != nil {
+			err = fmt.Errorf("failed to start module %s: %w", module.Name(), err)
+			return errors.Join(err, stopModules(ctx, r.modules[:i]))
+		}
+	}
+	return nil
+}
+
+// Stop cancels the running jobs and stops every module implementing Stopper in the reverse
+// order they were added, so modules are stopped before the ones they were started after.
+// Every module is stopped even if some fail.
+func (r *Registry) Stop(ctx context.Context) error {
+	r.jobs.CancelAll()
+	return stopModules(ctx, r.modules)
+}
+
+// stopModules stops the given modules in reverse order, joining the errors they return
+func stopModules(ctx context.Context, modules []Module) error {
+	var errs []error
+	for i := len(modules) - 1; i >= 0; i-- {
+		if stopper, ok := modules[i].(Stopper); ok {
+			if err := stopper.Stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to stop module %s: %w", modules[i].Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"go.uber.org/zap"
+)
+
+// Middleware wraps a HandlerFunc with cross-cutting behavior. Middlewares can be attached at
+// the Registry, Module and Command level, and run in that order: registry middlewares are
+// the outermost, command middlewares sit closest to the handler.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain wraps a handler with the given middlewares. The first middleware is the outermost,
+// meaning it runs first and sees the result of all the others.
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recover converts panics raised by the handler into errors so a single misbehaving
+// command can't take down the update loop
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &InternalError{
+						Err:   fmt.Errorf("panic in command %s: %v", args.name(), r),
+						Stack: debug.Stack(),
+					}
+				}
+			}()
+			return next(ctx, u, args)
+		}
+	}
+}
+
+// ErrorReply renders errors returned by the handler back to the chat the command was invoked
+// from. User and argument errors are shown along with the command's usage line, while internal
+// errors are additionally reported to the log channel with a stack trace.
+func ErrorReply() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			err := next(ctx, u, args)
+			if err == nil {
+				return nil
+			}
+
+			if replyErr := renderError(ctx, u, args, err); replyErr != nil {
+				return fmt.Errorf("%v (failed to send error reply: %v)", err, replyErr)
+			}
+			return nil
+		}
+	}
+}
+
+// DeleteTrigger deletes the message that invoked the command once the handler succeeds. The
+// message is kept while the command is piped, as later commands in the pipeline still use it.
+func DeleteTrigger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			if err := next(ctx, u, args); err != nil || args.Piped() {
+				return err
+			}
+			return args.Telegram().Delete(ctx, u, u.EffectiveMessage.ID)
+		}
+	}
+}
+
+// Logging logs every command invocation along with how long it took and whether it failed
+func Logging(lg *zap.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			start := time.Now()
+			err := next(ctx, u, args)
+
+			fields := []zap.Field{
+				zap.String("command", args.name()),
+				zap.Int64("chat", u.EffectiveChat().GetID()),
+				zap.String("args", args.Raw),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				lg.Warn("Command failed", append(fields, zap.Error(err))...)
+			} else {
+				lg.Info("Command handled", fields...)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+	"gorm.io/gorm"
+)
+
+// Role is the level of trust required to invoke a command from an incoming message. Outgoing
+// messages are always sent by the owner and are never restricted.
+type Role int
+
+const (
+	RoleAnyone  Role = iota // Anyone may invoke the command
+	RoleAllowed             // Users allowlisted in the chat or granted the command's module
+	RoleSudo                // Sudo users only
+	RoleOwner               // Only the account owner
+)
+
+// String returns a human-readable name for the role
+func (r Role) String() string {
+	switch r {
+	case RoleAnyone:
+		return "anyone"
+	case RoleAllowed:
+		return "allowed"
+	case RoleSudo:
+		return "sudo"
+	case RoleOwner:
+		return "owner"
+	default:
+		return "unknown"
+	}
+}
+
+// GrantKind is the kind of access a grant gives to a user
+type GrantKind string
+
+const (
+	GrantSudo   GrantKind = "sudo"   // Access to every command that requires RoleSudo or lower
+	GrantChat   GrantKind = "chat"   // Access to RoleAllowed commands within a single chat
+	GrantModule GrantKind = "module" // Access to RoleAllowed commands of a single module
+)
+
+// Grant is a persisted permission given to a user
+type Grant struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    int64     `gorm:"index"`
+	Kind      GrantKind `gorm:"index"`
+	Scope     string    // Chat ID for chat grants, module name for module grants
+	CreatedAt time.Time
+}
+
+// TableName returns the name of the table grants are stored in
+func (Grant) TableName() string {
+	return "macron_grants"
+}
+
+// Permissions decides who may invoke incoming commands. Grants are stored in the session
+// database and cached in memory.
+type Permissions struct {
+	db     *gorm.DB
+	mu     sync.RWMutex
+	grants []Grant
+}
+
+// NewPermissions creates the grants table if needed and loads all existing grants
+func NewPermissions(db *gorm.DB) (*Permissions, error) {
+	if err := db.AutoMigrate(&Grant{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate grants table: %w", err)
+	}
+
+	p := &Permissions{db: db}
+	if err := db.Find(&p.grants).Error; err != nil {
+		return nil, fmt.Errorf("failed to load grants: %w", err)
+	}
+	return p, nil
+}
+
+// Grant gives a user access of the given kind. Granting an existing grant is a no-op.
+func (p *Permissions) Grant(userID int64, kind GrantKind, scope string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.find(userID, kind, scope) >= 0 {
+		return nil
+	}
+
+	grant := Grant{UserID: userID, Kind: kind, Scope: scope}
+	if err := p.db.Create(&grant).Error; err != nil {
+		return fmt.Errorf("failed to save grant: %w", err)
+	}
+	p.grants = append(p.grants, grant)
+	return nil
+}
+
+// Revoke removes a grant, reporting whether it existed
+func (p *Permissions) Revoke(userID int64, kind GrantKind, scope string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(userID, kind, scope)
+	if i < 0 {
+		return false, nil
+	}
+
+	if err := p.db.Delete(&Grant{}, p.grants[i].ID).Error; err != nil {
+		return false, fmt.Errorf("failed to delete grant: %w", err)
+	}
+	p.grants = append(p.grants[:i], p.grants[i+1:]...)
+	return true, nil
+}
+
+// List returns all grants
+func (p *Permissions) List() []Grant {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	grants := make([]Grant, len(p.grants))
+	copy(grants, p.grants)
+	return grants
+}
+
+// Allowed reports whether a user may invoke a command requiring the given role in a chat.
+// A nil Permissions only allows commands open to anyone.
+func (p *Permissions) Allowed(role Role, userID int64, chatID int64, module string) bool {
+	if role == RoleAnyone {
+		return true
+	}
+	if p == nil || userID == 0 {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch role {
+	case RoleAllowed:
+		return p.find(userID, GrantSudo, "") >= 0 ||
+			p.find(userID, GrantChat, strconv.FormatInt(chatID, 10)) >= 0 ||
+			p.find(userID, GrantModule, module) >= 0
+	case RoleSudo:
+		return p.find(userID, GrantSudo, "") >= 0
+	default:
+		return false
+	}
+}
+
+// find returns the index of a matching grant, or -1. Callers must hold the lock.
+func (p *Permissions) find(userID int64, kind GrantKind, scope string) int {
+	for i, grant := range p.grants {
+		if grant.UserID == userID && grant.Kind == kind && grant.Scope == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// senderID returns the ID of the user who sent the message, or 0 if it wasn't sent by a user
+func senderID(m *types.Message) int64 {
+	if peer, ok := m.FromID.(*tg.PeerUser); ok {
+		return peer.UserID
+	}
+	// Messages in private chats don't carry a FromID, the sender is the chat itself
+	if peer, ok := m.PeerID.(*tg.PeerUser); ok {
+		return peer.UserID
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/watzon/macron/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestPermissions returns permissions backed by an in-memory database
+func newTestPermissions(t *testing.T) (*Permissions, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t)
+	p, err := NewPermissions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, db
+}
+
+func TestPermissions_Allowed(t *testing.T) {
+	const (
+		sudoUser   = 1
+		chatUser   = 2
+		moduleUser = 3
+		stranger   = 4
+		chat       = -100
+		otherChat  = -200
+	)
+
+	p, _ := newTestPermissions(t)
+	for _, g := range []struct {
+		user  int64
+		kind  GrantKind
+		scope string
+	}{
+		{sudoUser, GrantSudo, ""},
+		{chatUser, GrantChat, "-100"},
+		{moduleUser, GrantModule, "notes"},
+	} {
+		if err := p.Grant(g.user, g.kind, g.scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		role   Role
+		user   int64
+		chat   int64
+		module string
+		want   bool
+	}{
+		{"anyone without grants", RoleAnyone, stranger, chat, "notes", true},
+		{"anyone without a sender", RoleAnyone, 0, chat, "notes", true},
+		{"allowed without grants", RoleAllowed, stranger, chat, "notes", false},
+		{"allowed without a sender", RoleAllowed, 0, chat, "notes", false},
+		{"allowed by sudo", RoleAllowed, sudoUser, otherChat, "misc", true},
+		{"allowed in the granted chat", RoleAllowed, chatUser, chat, "misc", true},
+		{"allowed in another chat", RoleAllowed, chatUser, otherChat, "misc", false},
+		{"allowed in the granted module", RoleAllowed, moduleUser, otherChat, "notes", true},
+		{"allowed in another module", RoleAllowed, moduleUser, chat, "misc", false},
+		{"sudo by sudo", RoleSudo, sudoUser, chat, "notes", true},
+		{"sudo by a chat grant", RoleSudo, chatUser, chat, "notes", false},
+		{"sudo by a module grant", RoleSudo, moduleUser, chat, "notes", false},
+		{"owner by sudo", RoleOwner, sudoUser, chat, "notes", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allowed(tt.role, tt.user, tt.chat, tt.module); got != tt.want {
+				t.Errorf("Allowed(%s, %d, %d, %s) = %v, want %v", tt.role, tt.user, tt.chat, tt.module, got, tt.want)
+			}
+		})
+	}
+
+	var none *Permissions
+	if !none.Allowed(RoleAnyone, stranger, chat, "notes") || none.Allowed(RoleAllowed, sudoUser, chat, "notes") {
+		t.Errorf("nil Permissions should only allow commands open to anyone")
+	}
+}
+
+func TestPermissions_Persistence(t *testing.T) {
+	p, db := newTestPermissions(t)
+
+	if err := p.Grant(1, GrantModule, "notes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Grant(1, GrantModule, "notes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Grant(2, GrantSudo, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(p.List()); got != 2 {
+		t.Errorf("List() after granting twice has %d grants, want 2", got)
+	}
+
+	if ok, err := p.Revoke(2, GrantSudo, ""); err != nil || !ok {
+		t.Errorf("Revoke() = %v, %v, want the grant removed", ok, err)
+	}
+	if ok, err := p.Revoke(2, GrantSudo, ""); err != nil || ok {
+		t.Errorf("Revoke() of a missing grant = %v, %v, want false", ok, err)
+	}
+
+	reloaded, err := NewPermissions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Allowed(RoleAllowed, 1, 0, "notes") || reloaded.Allowed(RoleSudo, 2, 0, "notes") {
+		t.Errorf("reloaded grants = %+v, want only the module grant", reloaded.List())
+	}
+}
@@ -0,0 +1,207 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	markup "github.com/watzon/macron/styling"
+)
+
+// Sink delivers the output of a command once it's no longer piped into another command
+type Sink func(ctx *ext.Context, u *ext.Update, args *Arguments, text string) error
+
+// RespondSink responds with the output, or uploads it as a text file if it doesn't fit in a
+// single message. It is used for commands without a sink of their own.
+func RespondSink(ctx *ext.Context, u *ext.Update, args *Arguments, text string) error {
+	return args.RespondLong(ctx, u, OverflowFile, "output.txt", styling.Plain(text))
+}
+
+// EditSink replaces the text of the message that invoked the command with the output. Other
+// people's messages can't be edited, so incoming invocations are responded to instead.
+func EditSink(ctx *ext.Context, u *ext.Update, args *Arguments, text string) error {
+	if !u.EffectiveMessage.Out || entity.ComputeLength(text) > markup.MaxMessageLength {
+		return RespondSink(ctx, u, args, text)
+	}
+	return args.Telegram().Edit(ctx, u, u.EffectiveMessage.ID, styling.Plain(text))
+}
+
+// pipeOutput captures what a command outputs when it's piped into another command
+type pipeOutput struct {
+	text      string
+	produced  bool // Whether the command called Output
+	responded bool // Whether the command responded directly, such as with an error
+}
+
+// pipeStage is a command in a pipeline along with its own argument text
+type pipeStage struct {
+	cmd     *Command
+	module  Module
+	argText string
+}
+
+// Piped reports whether the command's output feeds into another command rather than being
+// delivered to the chat
+func (a *Arguments) Piped() bool {
+	return a.pipe != nil
+}
+
+// Input returns the output of the previous command when the command is part of a pipeline. It
+// is also appended to the command's rest argument, so most commands never need to call it.
+func (a *Arguments) Input() (string, bool) {
+	if a.input == nil {
+		return "", false
+	}
+	return *a.input, true
+}
+
+// Output hands the command's textual result to the next command in the pipeline, or delivers it
+// with the command's sink if there is none
+func (a *Arguments) Output(ctx *ext.Context, u *ext.Update, text string) error {
+	if a.pipe != nil {
+		a.pipe.text = text
+		a.pipe.produced = true
+		return nil
+	}
+
+	sink := RespondSink
+	if a.Command != nil && a.Command.Sink != nil {
+		sink = a.Command.Sink
+	}
+	return sink(ctx, u, a, text)
+}
+
+// splitPipeline splits argument text like "-to de hello | mock | cowsay" at every | that starts
+// a new command, returning the text of the first command and the following stages. A | that
+// is quoted or not followed by a known command or alias is left alone, so shell pipes passed
+// to a command keep working.
+func (r *Registry) splitPipeline(text string) (string, []pipeStage, error) {
+	var stages []pipeStage
+	var first string
+	var start int
+	inQuotes := false
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '"':
+			inQuotes = !inQuotes
+		case runes[i] == '|' && !inQuotes:
+			if i > 0 && !unicode.IsSpace(runes[i-1]) {
+				continue
+			}
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				continue
+			}
+
+			next, _ := splitWord(string(runes[i+1:]))
+			if cmd, _ := r.FindCommand(next); cmd == nil {
+				if _, ok := r.aliases.Get(next); !ok {
+					continue
+				}
+			}
+
+			segment := strings.TrimSpace(string(runes[start:i]))
+			if start == 0 {
+				first = segment
+			} else {
+				stage, err := r.pipeStage(segment)
+				if err != nil {
+					return "", nil, err
+				}
+				stages = append(stages, stage)
+			}
+			start = i + 1
+		}
+	}
+
+	if start == 0 {
+		return text, nil, nil
+	}
+	stage, err := r.pipeStage(strings.TrimSpace(string(runes[start:])))
+	if err != nil {
+		return "", nil, err
+	}
+	return first, append(stages, stage), nil
+}
+
+// pipeStage resolves the command a pipeline segment like "cowsay -cow dragon" invokes,
+// expanding user-defined aliases
+func (r *Registry) pipeStage(segment string) (pipeStage, error) {
+	name, argText := splitWord(segment)
+	target, rest, err := r.aliases.Expand(name, argText)
+	if err != nil {
+		return pipeStage{}, err
+	}
+	cmd, module := r.FindCommand(target)
+	if cmd == nil {
+		return pipeStage{}, UserErrorf("unknown command in pipeline: %s", target)
+	}
+	return pipeStage{cmd: cmd, module: module, argText: rest}, nil
+}
+
+// runPipeline runs each stage with the output of the previous one as its input. Only the last
+// stage delivers its output, while earlier ones stop the pipeline if they respond directly.
+func (r *Registry) runPipeline(ctx *ext.Context, u *ext.Update, prefix string, stages []pipeStage) error {
+	m := u.EffectiveMessage
+	var input *string
+
+	for i, stage := range stages {
+		args := &Arguments{
+			Raw:       stage.argText,
+			Reply:     m.ReplyToMessage,
+			Command:   stage.cmd,
+			Prefix:    prefix,
+			Edited:    isEdit(u),
+			responses: r.Responses(),
+			telegram:  r.Telegram(),
+			input:     input,
+		}
+
+		// The first stage was already checked when the message was matched
+		if i > 0 {
+			if !m.Out && !stage.cmd.Incoming {
+				return nil
+			}
+			if m.Out && !stage.cmd.Outgoing {
+				return args.RespondText(ctx, u, fmt.Sprintf("❌ %s%s can't be used in outgoing messages", prefix, stage.cmd.Name))
+			}
+			if !m.Out && !r.Permissions().Allowed(stage.cmd.Role, senderID(m), u.EffectiveChat().GetID(), stage.module.Name()) {
+				return nil
+			}
+			if !r.Enabled(stage.cmd, stage.module) {
+				if !m.Out {
+					return nil
+				}
+				return args.RespondText(ctx, u, fmt.Sprintf("⛔ The %s%s command is disabled", prefix, stage.cmd.Name))
+			}
+		}
+
+		// Commands only get a chain when they're registered
+		if stage.cmd.chain == nil {
+			return UserErrorf("command %s%s can't be run in a pipeline", prefix, stage.cmd.Name)
+		}
+
+		last := i == len(stages)-1
+		if !last {
+			args.pipe = &pipeOutput{}
+		}
+		if err := stage.cmd.chain(ctx, u, args); err != nil || last {
+			return err
+		}
+
+		if args.pipe.responded {
+			return nil
+		}
+		if !args.pipe.produced {
+			next := stages[i+1].cmd
+			return args.RespondText(ctx, u, fmt.Sprintf("❌ %s%s has no output to pipe into %s%s", prefix, stage.cmd.Name, prefix, next.Name))
+		}
+		output := args.pipe.text
+		input = &output
+	}
+	return nil
+}
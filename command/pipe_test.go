@@ -0,0 +1,123 @@
+package command
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+// pipeRegistry returns a registry with a mock and a cowsay command, and a shout alias for mock
+func pipeRegistry(t *testing.T) *Registry {
+	t.Helper()
+	m := NewBaseModule("test", "Test commands")
+	m.AddCommand(NewCommand("mock").WithAliases("m"))
+	m.AddCommand(NewCommand("cowsay"))
+
+	aliases, err := NewAliases(filepath.Join(t.TempDir(), "aliases.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aliases.Set("shout", "mock -loud"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(".")
+	r.AddModule(m)
+	r.SetAliases(aliases)
+	return r
+}
+
+func TestSplitPipeline(t *testing.T) {
+	type stage struct {
+		cmd     string
+		argText string
+	}
+	tests := []struct {
+		name   string
+		text   string
+		first  string
+		stages []stage
+	}{
+		{
+			name:  "no pipe",
+			text:  "hello world",
+			first: "hello world",
+		},
+		{
+			name:   "stages with arguments",
+			text:   "-to de hello | mock | cowsay -cow dragon",
+			first:  "-to de hello",
+			stages: []stage{{"mock", ""}, {"cowsay", "-cow dragon"}},
+		},
+		{
+			name:   "command aliases",
+			text:   "hello | m",
+			first:  "hello",
+			stages: []stage{{"mock", ""}},
+		},
+		{
+			name:   "user-defined aliases are expanded",
+			text:   "hello | shout now",
+			first:  "hello",
+			stages: []stage{{"mock", "-loud now"}},
+		},
+		{
+			name:  "quoted pipes are left alone",
+			text:  `"a | mock" b`,
+			first: `"a | mock" b`,
+		},
+		{
+			name:  "pipes without surrounding spaces are left alone",
+			text:  "a|mock b |mock c| mock",
+			first: "a|mock b |mock c| mock",
+		},
+		{
+			name:  "pipes into unknown commands are passed through",
+			text:  "ls -la | grep foo",
+			first: "ls -la | grep foo",
+		},
+		{
+			name:   "only pipes into known commands split",
+			text:   "ls | grep foo | mock",
+			first:  "ls | grep foo",
+			stages: []stage{{"mock", ""}},
+		},
+	}
+
+	r := pipeRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, stages, err := r.splitPipeline(tt.text)
+			if err != nil {
+				t.Fatalf("splitPipeline() error = %v", err)
+			}
+			if first != tt.first {
+				t.Errorf("splitPipeline() first = %q, want %q", first, tt.first)
+			}
+			if len(stages) != len(tt.stages) {
+				t.Fatalf("splitPipeline() gave %d stages, want %d", len(stages), len(tt.stages))
+			}
+			for i, s := range stages {
+				if s.cmd.Name != tt.stages[i].cmd || s.argText != tt.stages[i].argText {
+					t.Errorf("stage %d = %s %q, want %s %q", i, s.cmd.Name, s.argText, tt.stages[i].cmd, tt.stages[i].argText)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPipeline_UnregisteredCommand(t *testing.T) {
+	r := pipeRegistry(t)
+	cmd, module := r.FindCommand("mock")
+
+	ctx := &ext.Context{Context: context.Background()}
+	u := &ext.Update{EffectiveMessage: types.ConstructMessage(&tg.Message{Out: true})}
+	err := r.runPipeline(ctx, u, ".", []pipeStage{{cmd: cmd, module: module}})
+	if !IsUserError(err) {
+		t.Errorf("runPipeline() error = %v, want a user error", err)
+	}
+}
@@ -0,0 +1,189 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/types"
+)
+
+// Prefixes resolves which command prefixes are accepted in a chat. Every chat accepts the
+// default prefixes unless it has its own override, which is persisted as JSON so it survives
+// restarts.
+type Prefixes struct {
+	path      string
+	mu        sync.RWMutex
+	defaults  []string
+	overrides map[int64][]string
+}
+
+// NewPrefixes creates a resolver accepting the given default prefixes, loading per-chat
+// overrides from the given file. An empty path keeps overrides in memory only.
+func NewPrefixes(defaults []string, path string) (*Prefixes, error) {
+	p := &Prefixes{
+		path:      path,
+		defaults:  normalizePrefixes(defaults),
+		overrides: make(map[int64][]string),
+	}
+	if len(p.defaults) == 0 {
+		return nil, fmt.Errorf("at least one default prefix is required")
+	}
+
+	if path != "" {
+		var state map[string][]string
+		if err := loadJSON(path, &state); err != nil {
+			return nil, fmt.Errorf("failed to load prefixes: %w", err)
+		}
+		for key, prefixes := range state {
+			chatID, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chat ID in prefixes: %q", key)
+			}
+			if prefixes = normalizePrefixes(prefixes); len(prefixes) > 0 {
+				p.overrides[chatID] = prefixes
+			}
+		}
+	}
+	return p, nil
+}
+
+// Defaults returns the prefixes accepted in chats without an override. The first one is the
+// primary prefix shown in help.
+func (p *Prefixes) Defaults() []string {
+	return p.defaults
+}
+
+// For returns the prefixes accepted in the given chat
+func (p *Prefixes) For(chatID int64) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if prefixes, ok := p.overrides[chatID]; ok {
+		return prefixes
+	}
+	return p.defaults
+}
+
+// Override returns the prefixes set for the given chat, if it has an override
+func (p *Prefixes) Override(chatID int64) ([]string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	prefixes, ok := p.overrides[chatID]
+	return prefixes, ok
+}
+
+// Overrides returns the IDs of every chat with an override, sorted
+func (p *Prefixes) Overrides() []int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	chatIDs := make([]int64, 0, len(p.overrides))
+	for chatID := range p.overrides {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	return chatIDs
+}
+
+// Set replaces the prefixes accepted in the given chat
+func (p *Prefixes) Set(chatID int64, prefixes []string) error {
+	prefixes = normalizePrefixes(prefixes)
+	if len(prefixes) == 0 {
+		return fmt.Errorf("at least one prefix is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.overrides[chatID] = prefixes
+	return p.save()
+}
+
+// Reset removes the override for the given chat so it accepts the default prefixes again,
+// reporting whether there was an override to remove
+func (p *Prefixes) Reset(chatID int64) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.overrides[chatID]; !ok {
+		return false, nil
+	}
+	delete(p.overrides, chatID)
+	return true, p.save()
+}
+
+// save persists the overrides. The caller must hold the write lock.
+func (p *Prefixes) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	state := make(map[string][]string, len(p.overrides))
+	for chatID, prefixes := range p.overrides {
+		state[strconv.FormatInt(chatID, 10)] = prefixes
+	}
+	if err := saveJSON(p.path, state); err != nil {
+		return fmt.Errorf("failed to save prefixes: %w", err)
+	}
+	return nil
+}
+
+// matchPrefix returns the longest of the given prefixes that the text starts with, so that a
+// ".." prefix wins over "." when both are accepted
+func matchPrefix(text string, prefixes []string) (string, bool) {
+	best, found := "", false
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(text, prefix) && len(prefix) >= len(best) {
+			best, found = prefix, true
+		}
+	}
+	return best, found
+}
+
+// matchCommand returns the longest of the given prefixes with which the text invokes the named
+// command, i.e. starts with the prefix and name followed by a space or the end of the text
+func matchCommand(text string, prefixes []string, name string) (string, bool) {
+	best, found := "", false
+	for _, prefix := range prefixes {
+		invocation := prefix + name
+		if !strings.HasPrefix(text, invocation) {
+			continue
+		}
+		if len(text) > len(invocation) && text[len(invocation)] != ' ' {
+			continue
+		}
+		if len(prefix) >= len(best) {
+			best, found = prefix, true
+		}
+	}
+	return best, found
+}
+
+// normalizePrefixes drops empty and duplicate prefixes, keeping their order
+func normalizePrefixes(prefixes []string) []string {
+	seen := make(map[string]bool, len(prefixes))
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" || seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		normalized = append(normalized, prefix)
+	}
+	return normalized
+}
+
+// messageChatID returns the ID of the chat a message was sent in, matching the ID of the
+// update's effective chat
+func messageChatID(m *types.Message) int64 {
+	if m.Message == nil {
+		return 0
+	}
+	return functions.GetChatIdFromPeer(m.PeerID)
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixes_For(t *testing.T) {
+	const (
+		chat      = -100
+		otherChat = -200
+	)
+
+	path := filepath.Join(t.TempDir(), "prefixes.json")
+	p, err := NewPrefixes([]string{".", "!", "."}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set(chat, []string{"/", " ", ".."}); err != nil {
+		t.Fatal(err)
+	}
+
+	custom := NewCommand("sed").WithPrefix("s/")
+	plain := NewCommand("echo")
+	sub := NewCommand("add")
+	NewCommand("note").WithSubcommands(sub)
+
+	tests := []struct {
+		name   string
+		chatID int64
+		cmd    *Command
+		text   string
+		want   []string
+		prefix string // Prefix the text invokes the command with, empty if it doesn't
+	}{
+		{"defaults", otherChat, plain, ".echo hi", []string{".", "!"}, "."},
+		{"second default", otherChat, plain, "!echo hi", []string{".", "!"}, "!"},
+		{"override wins", chat, plain, "/echo hi", []string{"/", ".."}, "/"},
+		{"defaults don't apply with an override", chat, plain, ".echo hi", []string{"/", ".."}, ""},
+		{"longest prefix", chat, plain, "..echo", []string{"/", ".."}, ".."},
+		{"command prefix ignores the override", chat, custom, "s/sed a/b/", []string{"s/"}, "s/"},
+		{"subcommands use the chat's prefixes", chat, sub, "/add", []string{"/", ".."}, "/"},
+	}
+
+	r := NewRegistry(".")
+	r.SetPrefixes(p)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.CommandPrefixes(tt.cmd, tt.chatID)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CommandPrefixes() = %q, want %q", got, tt.want)
+			}
+			prefix, ok := matchCommand(tt.text, got, tt.cmd.Name)
+			if prefix != tt.prefix || ok != (tt.prefix != "") {
+				t.Errorf("matchCommand(%q) = %q, %v, want %q", tt.text, prefix, ok, tt.prefix)
+			}
+		})
+	}
+
+	// Overrides survive a restart, and resetting one brings the defaults back
+	reloaded, err := NewPrefixes([]string{".", "!"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.For(chat); !reflect.DeepEqual(got, []string{"/", ".."}) {
+		t.Errorf("reloaded For() = %q, want the override", got)
+	}
+	if ok, err := reloaded.Reset(chat); err != nil || !ok {
+		t.Errorf("Reset() = %v, %v, want the override removed", ok, err)
+	}
+	if got := reloaded.For(chat); !reflect.DeepEqual(got, []string{".", "!"}) {
+		t.Errorf("For() after Reset() = %q, want the defaults", got)
+	}
+	if err := reloaded.Set(chat, []string{" "}); err == nil {
+		t.Errorf("Set() without prefixes succeeded, want an error")
+	}
+}
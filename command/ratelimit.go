@@ -0,0 +1,202 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+// DefaultThrottleMessage is the message shown when a command is invoked too often. The %s verb
+// is replaced with how long the sender has to wait.
+const DefaultThrottleMessage = "⏳ Slow down! Try again in %s"
+
+// Limit describes a token bucket: up to Burst invocations may happen at once, after which one
+// invocation becomes available again every Every. The zero value means no limit.
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+// Every returns a limit allowing a single invocation per duration
+func Every(d time.Duration) Limit {
+	return Limit{Burst: 1, Every: d}
+}
+
+// Burst returns a limit allowing n invocations at once, refilling one every duration
+func Burst(n int, every time.Duration) Limit {
+	return Limit{Burst: n, Every: every}
+}
+
+// IsZero reports whether the limit doesn't restrict anything
+func (l Limit) IsZero() bool {
+	return l.Burst <= 0 || l.Every <= 0
+}
+
+// Cooldown holds the rate limits applied to a command. Every non-zero limit has to allow an
+// invocation for it to go through.
+type Cooldown struct {
+	PerUser Limit
+	PerChat Limit
+	Global  Limit
+}
+
+// IsLimited reports whether any of the cooldown's limits restricts invocations
+func (c Cooldown) IsLimited() bool {
+	return !c.PerUser.IsZero() || !c.PerChat.IsZero() || !c.Global.IsZero()
+}
+
+// ThrottledError is returned when a command is invoked more often than its cooldown allows
+type ThrottledError struct {
+	Wait    time.Duration // How long until the command can be invoked again
+	Message string        // Message shown to the user, empty to stay silent
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("command throttled for %s", e.Wait)
+}
+
+// sweepInterval is how often a rate limiter forgets the buckets that refilled completely
+const sweepInterval = time.Minute
+
+// bucket is a single token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// full reports whether the bucket has refilled to its burst by the given time, so it's no
+// different from a new one
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+float64(now.Sub(b.last))/float64(b.limit.Every) >= float64(b.limit.Burst)
+}
+
+// RateLimiter tracks token buckets for a command's cooldown. Buckets are only kept while
+// they're refilling, so senders and chats that stopped invoking the command are forgotten.
+type RateLimiter struct {
+	cooldown Cooldown
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	swept    time.Time
+}
+
+// NewRateLimiter creates a rate limiter enforcing the given cooldown
+func NewRateLimiter(cooldown Cooldown) *RateLimiter {
+	return &RateLimiter{
+		cooldown: cooldown,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from every bucket that applies to the sender and chat. If any bucket is
+// empty, nothing is taken and the time until the invocation would be allowed is returned.
+func (l *RateLimiter) Allow(userID, chatID int64) (bool, time.Duration) {
+	return l.allow(userID, chatID, time.Now())
+}
+
+// allow is Allow at the given time
+func (l *RateLimiter) allow(userID, chatID int64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	type check struct {
+		key   string
+		limit Limit
+	}
+	checks := []check{
+		{"user:" + strconv.FormatInt(userID, 10), l.cooldown.PerUser},
+		{"chat:" + strconv.FormatInt(chatID, 10), l.cooldown.PerChat},
+		{"global", l.cooldown.Global},
+	}
+
+	// Refill every bucket first so nothing is taken unless all of them allow the invocation
+	var wait time.Duration
+	var taken []*bucket
+	for _, c := range checks {
+		if c.limit.IsZero() {
+			continue
+		}
+		b := l.refill(c.key, c.limit, now)
+		if b.tokens < 1 {
+			missing := time.Duration((1 - b.tokens) * float64(c.limit.Every))
+			if missing > wait {
+				wait = missing
+			}
+			continue
+		}
+		taken = append(taken, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, b := range taken {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// refill returns the bucket for the key, topped up with the tokens earned since it was last
+// used. Callers must hold the lock.
+func (l *RateLimiter) refill(key string, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(limit.Every)
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+	return b
+}
+
+// sweep deletes the buckets that refilled completely, at most once per sweepInterval. Callers
+// must hold the lock.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Throttle enforces a rate limiter, returning a ThrottledError carrying the given message when
+// the invocation isn't allowed. The message may contain a %s verb for the remaining wait.
+func Throttle(limiter *RateLimiter, message string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+			userID := senderID(u.EffectiveMessage)
+			if u.EffectiveMessage.Out {
+				userID = ctx.Self.ID
+			}
+
+			ok, wait := limiter.Allow(userID, u.EffectiveChat().GetID())
+			if ok {
+				return next(ctx, u, args)
+			}
+
+			wait = wait.Round(time.Second)
+			if wait < time.Second {
+				wait = time.Second
+			}
+			text := message
+			if strings.Contains(text, "%s") {
+				text = fmt.Sprintf(text, wait)
+			}
+			return &ThrottledError{Wait: wait, Message: text}
+		}
+	}
+}
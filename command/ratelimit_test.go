@@ -0,0 +1,136 @@
+package command
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	// Each step invokes the command as a user in a chat, offset from the start
+	type step struct {
+		at     time.Duration
+		user   int64
+		chat   int64
+		want   bool
+		wantIn time.Duration // Expected wait when throttled
+	}
+	tests := []struct {
+		name     string
+		cooldown Cooldown
+		steps    []step
+	}{
+		{
+			name:     "no limits",
+			cooldown: Cooldown{},
+			steps:    []step{{0, 1, 1, true, 0}, {0, 1, 1, true, 0}, {0, 1, 1, true, 0}},
+		},
+		{
+			name:     "one per interval",
+			cooldown: Cooldown{PerUser: Every(10 * time.Second)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{4 * time.Second, 1, 1, false, 6 * time.Second},
+				{10 * time.Second, 1, 1, true, 0},
+			},
+		},
+		{
+			name:     "burst then refill",
+			cooldown: Cooldown{PerUser: Burst(3, 10*time.Second)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{0, 1, 1, true, 0},
+				{0, 1, 1, true, 0},
+				{0, 1, 1, false, 10 * time.Second},
+				{5 * time.Second, 1, 1, false, 5 * time.Second},
+				{10 * time.Second, 1, 1, true, 0},
+				{10 * time.Second, 1, 1, false, 10 * time.Second},
+			},
+		},
+		{
+			name:     "refill is capped at the burst",
+			cooldown: Cooldown{PerUser: Burst(2, time.Second)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{time.Hour, 1, 1, true, 0},
+				{time.Hour, 1, 1, true, 0},
+				{time.Hour, 1, 1, false, time.Second},
+			},
+		},
+		{
+			name:     "users have buckets of their own",
+			cooldown: Cooldown{PerUser: Every(time.Minute)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{0, 2, 1, true, 0},
+				{0, 1, 2, false, time.Minute},
+			},
+		},
+		{
+			name:     "chat limit applies to every user",
+			cooldown: Cooldown{PerChat: Burst(2, time.Minute)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{0, 2, 1, true, 0},
+				{0, 3, 1, false, time.Minute},
+				{0, 3, 2, true, 0},
+			},
+		},
+		{
+			name:     "throttled invocations don't take tokens",
+			cooldown: Cooldown{PerUser: Burst(2, time.Minute), Global: Every(10 * time.Second)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{5 * time.Second, 1, 1, false, 5 * time.Second},
+				{10 * time.Second, 1, 1, true, 0},
+				{10 * time.Second, 2, 1, false, 10 * time.Second},
+			},
+		},
+		{
+			name:     "longest wait wins",
+			cooldown: Cooldown{PerUser: Every(time.Minute), Global: Every(10 * time.Second)},
+			steps: []step{
+				{0, 1, 1, true, 0},
+				{0, 1, 1, false, time.Minute},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.cooldown)
+			for i, s := range tt.steps {
+				ok, wait := l.allow(s.user, s.chat, start.Add(s.at))
+				if ok != s.want || wait != s.wantIn {
+					t.Errorf("step %d: allow() = %v, %s, want %v, %s", i, ok, wait, s.want, s.wantIn)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_ForgetsRefilledBuckets(t *testing.T) {
+	l := NewRateLimiter(Cooldown{PerUser: Burst(2, 10*time.Second), PerChat: Every(time.Hour)})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for user := int64(1); user <= 100; user++ {
+		l.allow(user, user, start)
+	}
+	if len(l.buckets) != 200 {
+		t.Fatalf("limiter has %d buckets, want 200", len(l.buckets))
+	}
+
+	// The user buckets refill within a minute, the chat buckets take an hour
+	l.allow(1000, 1000, start.Add(sweepInterval))
+	if len(l.buckets) != 102 {
+		t.Errorf("limiter has %d buckets after a sweep, want the 100 refilling chats and 2 new ones", len(l.buckets))
+	}
+
+	// Forgotten buckets start out full again
+	if ok, _ := l.allow(1, 2000, start.Add(sweepInterval)); !ok {
+		t.Errorf("allow() of a forgotten sender was throttled")
+	}
+	if ok, _ := l.allow(2000, 1, start.Add(sweepInterval)); ok {
+		t.Errorf("allow() in a refilling chat wasn't throttled")
+	}
+}
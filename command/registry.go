@@ -1,20 +1,39 @@
 package command
 
 import (
+	"strings"
+
 	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
 )
 
 // Registry manages module and command registration
 type Registry struct {
-	modules       []Module
-	defaultPrefix string
+	modules     []Module
+	prefixes    *Prefixes
+	middlewares []Middleware
+	permissions *Permissions
+	throttleMsg string
+	responses   *Responses
+	toggles     *Toggles
+	aliases     *Aliases
+	jobs        *Jobs
+	history     *History
+	telegram    Telegram
 }
 
 // NewRegistry creates a new registry with the given default prefix
 func NewRegistry(defaultPrefix string) *Registry {
 	return &Registry{
-		modules:       make([]Module, 0),
-		defaultPrefix: defaultPrefix,
+		modules: make([]Module, 0),
+		prefixes: &Prefixes{
+			defaults:  []string{defaultPrefix},
+			overrides: make(map[int64][]string),
+		},
+		throttleMsg: DefaultThrottleMessage,
+		responses:   NewResponses(defaultResponseLimit),
+		jobs:        NewJobs(),
+		telegram:    clientTelegram{},
 	}
 }
 
@@ -23,14 +42,244 @@ func (r *Registry) AddModule(module Module) {
 	r.modules = append(r.modules, module)
 }
 
-// RegisterAll registers all modules with the given dispatcher
+// RegisterAll registers all modules with the given dispatcher, followed by handlers that
+// expand user-defined aliases and suggest the closest commands when an unknown one is invoked
 func (r *Registry) RegisterAll(d dispatcher.Dispatcher) {
 	for _, module := range r.modules {
-		module.Load(d, r.defaultPrefix)
+		module.Load(d, r)
+	}
+	r.registerAliases(d)
+	r.registerSuggestions(d)
+}
+
+// Use adds middlewares that wrap every command in the registry. Middlewares must be added
+// before RegisterAll is called.
+func (r *Registry) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Middlewares returns the middlewares applied to every command in the registry
+func (r *Registry) Middlewares() []Middleware {
+	return r.middlewares
+}
+
+// SetPermissions sets the permissions used to authorize incoming commands
+func (r *Registry) SetPermissions(permissions *Permissions) {
+	r.permissions = permissions
+}
+
+// Permissions returns the permissions used to authorize incoming commands. Without
+// permissions only commands open to anyone can be invoked from incoming messages.
+func (r *Registry) Permissions() *Permissions {
+	return r.permissions
+}
+
+// SetThrottleMessage sets the message shown when a command is invoked more often than its
+// cooldown allows. A %s verb is replaced with the remaining wait; an empty message makes
+// throttled commands fail silently. It must be set before RegisterAll is called.
+func (r *Registry) SetThrottleMessage(message string) {
+	r.throttleMsg = message
+}
+
+// ThrottleMessage returns the message shown when a command is throttled
+func (r *Registry) ThrottleMessage() string {
+	return r.throttleMsg
+}
+
+// SetToggles sets the state deciding which modules and commands are disabled
+func (r *Registry) SetToggles(toggles *Toggles) {
+	r.toggles = toggles
+}
+
+// Toggles returns the state deciding which modules and commands are disabled. Without
+// toggles every module and command is enabled and can't be disabled.
+func (r *Registry) Toggles() *Toggles {
+	return r.toggles
+}
+
+// Enabled reports whether the command can be invoked, which requires the module it belongs
+// to, the command and, for subcommands, every command above it to be enabled
+func (r *Registry) Enabled(cmd *Command, module Module) bool {
+	if !r.toggles.ModuleEnabled(module.Name()) {
+		return false
+	}
+	for c := cmd; c != nil; c = c.Parent {
+		if !r.toggles.CommandEnabled(module.Name(), c.FullName()) {
+			return false
+		}
 	}
+	return true
+}
+
+// SetAliases sets the user-defined aliases expanded before commands are run
+func (r *Registry) SetAliases(aliases *Aliases) {
+	r.aliases = aliases
+}
+
+// Aliases returns the user-defined aliases. Without aliases none can be defined.
+func (r *Registry) Aliases() *Aliases {
+	return r.aliases
+}
+
+// Responses returns the tracker mapping trigger messages to the responses they produced
+func (r *Registry) Responses() *Responses {
+	return r.responses
+}
+
+// SetHistory sets the history invocations are recorded in. Recording itself is done by the
+// Audit middleware.
+func (r *Registry) SetHistory(history *History) {
+	r.history = history
+}
+
+// History returns the history invocations are recorded in, or nil if there is none
+func (r *Registry) History() *History {
+	return r.history
+}
+
+// SetTelegram sets the API commands respond through, such as a fake recording the responses
+// in tests
+func (r *Registry) SetTelegram(telegram Telegram) {
+	r.telegram = telegram
+}
+
+// Telegram returns the API commands respond through
+func (r *Registry) Telegram() Telegram {
+	return r.telegram
+}
+
+// Jobs returns the tracker of long-running commands
+func (r *Registry) Jobs() *Jobs {
+	return r.jobs
 }
 
 // GetModules returns all registered modules
 func (r *Registry) GetModules() []Module {
 	return r.modules
 }
+
+// Prefix returns the registry's primary default command prefix
+func (r *Registry) Prefix() string {
+	return r.prefixes.Defaults()[0]
+}
+
+// SetPrefixes sets the resolver deciding which prefixes are accepted in each chat. It replaces
+// the default prefix given to NewRegistry.
+func (r *Registry) SetPrefixes(prefixes *Prefixes) {
+	r.prefixes = prefixes
+}
+
+// Prefixes returns the resolver deciding which prefixes are accepted in each chat
+func (r *Registry) Prefixes() *Prefixes {
+	return r.prefixes
+}
+
+// CommandPrefixes returns the prefixes that invoke the command in the given chat. Commands
+// with their own prefix ignore the chat's prefixes.
+func (r *Registry) CommandPrefixes(cmd *Command, chatID int64) []string {
+	if root := cmd.Root(); root.Prefix != "" {
+		return []string{root.Prefix}
+	}
+	return r.prefixes.For(chatID)
+}
+
+// FindCommand looks up a command by name or alias across all registered modules,
+// returning the command and the module it belongs to
+func (r *Registry) FindCommand(name string) (*Command, Module) {
+	for _, module := range r.modules {
+		for _, cmd := range module.GetCommands() {
+			if cmd.Matches(name) {
+				return cmd, module
+			}
+		}
+	}
+	return nil, nil
+}
+
+// LookupCommand finds a command by a reference like "name" or "module/name", where the name
+// can also be an alias, optionally followed by subcommands as in "notes/note add". A name
+// without its module is only accepted when a single module has a command matching it.
+func (r *Registry) LookupCommand(ref string) (*Command, Module, error) {
+	word, rest := splitWord(ref)
+	moduleName, name, qualified := strings.Cut(word, "/")
+	if !qualified {
+		moduleName, name = "", word
+	}
+
+	var cmd *Command
+	var module Module
+	var matches []string
+	for _, m := range r.modules {
+		if qualified && m.Name() != moduleName {
+			continue
+		}
+		for _, c := range m.GetCommands() {
+			if c.Matches(name) {
+				cmd, module = c, m
+				matches = append(matches, CommandKey(m.Name(), c.Name))
+			}
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return nil, nil, UserErrorf("unknown command: %s", word)
+	case len(matches) > 1:
+		return nil, nil, UserErrorf("%s is ambiguous, use one of: %s", name, strings.Join(matches, ", "))
+	}
+
+	for rest != "" {
+		var subName string
+		subName, rest = splitWord(rest)
+		sub := cmd.FindSubcommand(subName)
+		if sub == nil {
+			return nil, nil, UserErrorf("%s has no subcommand %s", cmd.FullName(), subName)
+		}
+		cmd = sub
+	}
+	return cmd, module, nil
+}
+
+// Invoke runs the command the text invokes as if the update's message was sent with that text,
+// such as for commands run by the scheduler. User-defined aliases are expanded.
+func (r *Registry) Invoke(ctx *ext.Context, u *ext.Update, text string) error {
+	cmd, module, prefix, argText, err := r.resolveInvocation(text, u.EffectiveChat().GetID())
+	if err != nil {
+		respond := &Arguments{Edited: isEdit(u), responses: r.Responses(), telegram: r.Telegram()}
+		return respond.RespondText(ctx, u, "❌ "+err.Error())
+	}
+	return cmd.invoke(ctx, u, r, module, prefix, argText)
+}
+
+// CheckInvocation returns an error unless the text invokes a command in the given chat
+func (r *Registry) CheckInvocation(text string, chatID int64) error {
+	_, _, _, _, err := r.resolveInvocation(text, chatID)
+	return err
+}
+
+// resolveInvocation finds the command the text invokes in a chat, returning it along with its
+// module, the prefix it's invoked with and the argument text
+func (r *Registry) resolveInvocation(text string, chatID int64) (*Command, Module, string, string, error) {
+	for _, module := range r.modules {
+		for _, cmd := range module.GetCommands() {
+			for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+				if prefix, ok := matchCommand(text, r.CommandPrefixes(cmd, chatID), name); ok {
+					return cmd, module, prefix, strings.TrimSpace(strings.TrimPrefix(text, prefix+name)), nil
+				}
+			}
+		}
+	}
+
+	name, prefix, args := r.invokedAlias(text, chatID)
+	if name == "" {
+		return nil, nil, "", "", UserErrorf("%q doesn't invoke a command", text)
+	}
+	target, rest, err := r.aliases.Expand(name, args)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	cmd, module := r.FindCommand(target)
+	if cmd == nil {
+		return nil, nil, "", "", UserErrorf("alias %s expands into unknown command %s", name, target)
+	}
+	return cmd, module, prefix, rest, nil
+}
@@ -0,0 +1,206 @@
+package command
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"sync"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	markup "github.com/watzon/macron/styling"
+)
+
+// defaultResponseLimit is how many trigger messages are remembered before the oldest ones are
+// forgotten. Editing a forgotten trigger re-runs the command with a fresh response.
+const defaultResponseLimit = 1000
+
+// responseKey identifies a trigger message
+type responseKey struct {
+	chatID    int64
+	messageID int
+}
+
+// response is what is remembered about a trigger message
+type response struct {
+	text       string // Text of the trigger message when the command last ran
+	responseID int    // ID of the message the command responded with, or 0
+}
+
+// Responses maps messages that triggered a command to the message the command responded with,
+// so that editing the trigger re-runs the command and edits the response in place
+type Responses struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[responseKey]*response
+	order   []responseKey
+}
+
+// NewResponses creates a response tracker remembering up to limit trigger messages
+func NewResponses(limit int) *Responses {
+	return &Responses{
+		limit:   limit,
+		entries: make(map[responseKey]*response),
+	}
+}
+
+// Track records that a command is about to run for the trigger message with the given text. It
+// reports false if the message was already handled with the same text, which happens when
+// Telegram sends an edit update for something other than the text, such as a link preview.
+func (r *Responses) Track(chatID int64, messageID int, text string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := responseKey{chatID, messageID}
+	if entry, ok := r.entries[key]; ok {
+		if entry.text == text {
+			return false
+		}
+		entry.text = text
+		return true
+	}
+
+	r.entries[key] = &response{text: text}
+	r.order = append(r.order, key)
+	if len(r.order) > r.limit {
+		delete(r.entries, r.order[0])
+		r.order = r.order[1:]
+	}
+	return true
+}
+
+// Response returns the ID of the message a trigger was responded with, or 0 if there is none
+func (r *Responses) Response(chatID int64, messageID int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[responseKey{chatID, messageID}]; ok {
+		return entry.responseID
+	}
+	return 0
+}
+
+// SetResponse records the message a trigger was responded with
+func (r *Responses) SetResponse(chatID int64, messageID int, responseID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[responseKey{chatID, messageID}]; ok {
+		entry.responseID = responseID
+	}
+}
+
+// isEdit reports whether the update is an edit of an existing message
+func isEdit(u *ext.Update) bool {
+	switch u.UpdateClass.(type) {
+	case *tg.UpdateEditMessage, *tg.UpdateEditChannelMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// Respond replies to the message that invoked the command. When the command is re-run because
+// its trigger message was edited, the previous response is edited in place instead.
+func (a *Arguments) Respond(ctx *ext.Context, u *ext.Update, text ...styling.StyledTextOption) error {
+	if a.pipe != nil {
+		a.pipe.responded = true
+	}
+	if a.job != nil {
+		a.job.mu.Lock()
+		a.job.finalized = true
+		a.job.mu.Unlock()
+	}
+
+	chatID := u.EffectiveChat().GetID()
+	triggerID := u.EffectiveMessage.ID
+
+	if a.responses != nil {
+		if responseID := a.responses.Response(chatID, triggerID); responseID != 0 {
+			err := a.Telegram().Edit(ctx, u, responseID, text...)
+			if err == nil || tgerr.Is(err, "MESSAGE_NOT_MODIFIED") {
+				return nil
+			}
+			// The previous response was probably deleted, fall back to a new reply
+		}
+	}
+
+	msgID, err := a.Telegram().Reply(ctx, u, 0, text...)
+	if err != nil {
+		return err
+	}
+	if a.responses != nil {
+		a.responses.SetResponse(chatID, triggerID, msgID)
+	}
+	return nil
+}
+
+// RespondText is like Respond for unformatted text
+func (a *Arguments) RespondText(ctx *ext.Context, u *ext.Update, text string) error {
+	return a.Respond(ctx, u, styling.Plain(text))
+}
+
+// RespondHTML is like Respond for text in Telegram's HTML format. Untrusted parts of the text
+// should be escaped with html.EscapeString.
+func (a *Arguments) RespondHTML(ctx *ext.Context, u *ext.Update, html string) error {
+	text, err := markup.ParseHTML(html)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return a.Respond(ctx, u, text...)
+}
+
+// Overflow is what happens to a response that doesn't fit in a single message
+type Overflow int
+
+const (
+	OverflowSplit    Overflow = iota // Send it as several messages
+	OverflowTruncate                 // Cut it short
+	OverflowFile                     // Upload its text as a file
+)
+
+// RespondLong is like Respond for responses that may be too long for a single message, which
+// are handled the way overflow says. Split responses are sent as consecutive replies, of which
+// only the first is edited when the trigger message is. Files are uploaded with the given name,
+// and lose the formatting of the text.
+func (a *Arguments) RespondLong(ctx *ext.Context, u *ext.Update, overflow Overflow, name string, text ...styling.StyledTextOption) error {
+	switch overflow {
+	case OverflowTruncate:
+		truncated, err := markup.Truncate(markup.MaxMessageLength, text...)
+		if err != nil {
+			return err
+		}
+		return a.Respond(ctx, u, truncated...)
+	case OverflowFile:
+		var b entity.Builder
+		if err := styling.Perform(&b, text...); err != nil {
+			return err
+		}
+		plain, _ := b.Raw()
+		if entity.ComputeLength(plain) <= markup.MaxMessageLength {
+			return a.Respond(ctx, u, text...)
+		}
+		mimeType := mime.TypeByExtension(path.Ext(name))
+		if mimeType == "" {
+			mimeType = "text/plain"
+		}
+		return a.Telegram().Upload(ctx, u, name, mimeType, []byte(plain))
+	}
+
+	parts, err := markup.Split(markup.MaxMessageLength, text...)
+	if err != nil {
+		return err
+	}
+	if err := a.Respond(ctx, u, parts[0]...); err != nil {
+		return err
+	}
+	for _, part := range parts[1:] {
+		if _, err := a.Telegram().Reply(ctx, u, 0, part...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
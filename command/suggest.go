@@ -0,0 +1,182 @@
+package command
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+)
+
+// maxSuggestions is the most suggestions offered for a mistyped command
+const maxSuggestions = 3
+
+// editDistance returns the optimal string alignment distance between two strings: the number
+// of insertions, deletions, substitutions and transpositions of adjacent runes needed to turn
+// one into the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// maxDistance returns how many edits a name may be away from a candidate to still be
+// considered a typo of it. Short names allow fewer edits so that unrelated words don't match.
+func maxDistance(name string) int {
+	switch n := len([]rune(name)); {
+	case n <= 3:
+		return 1
+	case n <= 6:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Suggest returns up to limit candidates closest to name, nearest first. Candidates too far
+// away to plausibly be a typo of name are left out.
+func Suggest(name string, candidates []string, limit int) []string {
+	type match struct {
+		candidate string
+		distance  int
+	}
+
+	name = strings.ToLower(name)
+	threshold := maxDistance(name)
+	seen := make(map[string]bool)
+	var matches []match
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		if d := editDistance(name, strings.ToLower(candidate)); d <= threshold {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	suggestions := make([]string, 0, limit)
+	for i := 0; i < len(matches) && i < limit; i++ {
+		suggestions = append(suggestions, matches[i].candidate)
+	}
+	return suggestions
+}
+
+// unknownCommand returns the name of the command the message tries to invoke with one of the
+// given prefixes if no registered command has that name, along with the prefix used. It
+// returns an empty name for messages that don't look like a command invocation at all.
+func (r *Registry) unknownCommand(text string, prefixes []string) (string, string) {
+	prefix, ok := matchPrefix(text, prefixes)
+	if !ok {
+		return "", ""
+	}
+
+	// Very short words are too likely to be a typo of some alias by accident
+	name, _ := splitWord(strings.TrimPrefix(text, prefix))
+	if len([]rune(name)) < 3 {
+		return "", ""
+	}
+	// Only words made of letters look like commands, so ".5" or "..." are left alone
+	if !isWord(name) || !unicode.IsLetter([]rune(name)[0]) {
+		return "", ""
+	}
+
+	if cmd, _ := r.FindCommand(name); cmd != nil {
+		return "", ""
+	}
+	if _, ok := r.aliases.Get(name); ok {
+		return "", ""
+	}
+	return name, prefix
+}
+
+// commandNames returns the names and aliases of every visible, enabled command invoked with
+// the given prefix in the given chat, along with the user-defined aliases
+func (r *Registry) commandNames(prefix string, chatID int64) []string {
+	var names []string
+	for _, module := range r.modules {
+		for _, cmd := range module.GetCommands() {
+			if cmd.Hidden || !r.Enabled(cmd, module) || !slices.Contains(r.CommandPrefixes(cmd, chatID), prefix) {
+				continue
+			}
+			names = append(names, cmd.Name)
+			names = append(names, cmd.Aliases...)
+		}
+	}
+	if slices.Contains(r.prefixes.For(chatID), prefix) {
+		for _, alias := range r.aliases.List() {
+			names = append(names, alias.Name)
+		}
+	}
+	return names
+}
+
+// registerSuggestions adds a handler which replies to outgoing messages invoking a command
+// that doesn't exist with the closest registered commands. Messages with no plausible
+// suggestion are left alone, as they're most likely not meant as commands.
+func (r *Registry) registerSuggestions(d dispatcher.Dispatcher) {
+	filter := func(m *types.Message) bool {
+		if !m.Out {
+			return false
+		}
+		name, _ := r.unknownCommand(m.Text, r.prefixes.For(messageChatID(m)))
+		return name != ""
+	}
+
+	d.AddHandler(handlers.NewMessage(filter, func(ctx *ext.Context, u *ext.Update) error {
+		m := u.EffectiveMessage
+		chatID := u.EffectiveChat().GetID()
+		name, prefix := r.unknownCommand(m.Text, r.prefixes.For(chatID))
+
+		suggestions := Suggest(name, r.commandNames(prefix, chatID), maxSuggestions)
+		if len(suggestions) == 0 {
+			return nil
+		}
+		if !r.Responses().Track(chatID, m.ID, m.Text) {
+			return nil
+		}
+
+		for i, suggestion := range suggestions {
+			suggestions[i] = prefix + suggestion
+		}
+		args := &Arguments{Prefix: prefix, Edited: isEdit(u), responses: r.Responses(), telegram: r.Telegram()}
+		return args.RespondText(ctx, u, "❓ Unknown command "+prefix+name+". Did you mean "+joinOr(suggestions)+"?")
+	}))
+}
+
+// joinOr joins words into a list like "a, b or c"
+func joinOr(words []string) string {
+	if len(words) <= 1 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+// Telegram is the part of the Telegram API commands respond through. Messages are sent to the
+// chat of the update that invoked the command. The client is used by default, while tests
+// substitute a fake that records what was sent.
+type Telegram interface {
+	// Reply sends a message replying to the given message, or to the message that invoked the
+	// command if replyTo is 0, and returns the ID of the sent message
+	Reply(ctx *ext.Context, u *ext.Update, replyTo int, text ...styling.StyledTextOption) (int, error)
+	// Edit replaces the text of a message
+	Edit(ctx *ext.Context, u *ext.Update, messageID int, text ...styling.StyledTextOption) error
+	// Delete deletes messages
+	Delete(ctx *ext.Context, u *ext.Update, messageIDs ...int) error
+	// Upload sends data as a file with the given name and MIME type, in reply to the message
+	// that invoked the command. Images are sent as photos.
+	Upload(ctx *ext.Context, u *ext.Update, name, mimeType string, data []byte) error
+}
+
+// clientTelegram talks to Telegram through the client of the update's context
+type clientTelegram struct{}
+
+func (clientTelegram) Reply(ctx *ext.Context, u *ext.Update, replyTo int, text ...styling.StyledTextOption) (int, error) {
+	msg, err := ctx.Reply(u, ext.ReplyTextStyledTextArray(text), &ext.ReplyOpts{ReplyToMessageId: replyTo})
+	if err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
+
+func (clientTelegram) Edit(ctx *ext.Context, u *ext.Update, messageID int, text ...styling.StyledTextOption) error {
+	_, err := ctx.Sender.To(u.EffectiveChat().GetInputPeer()).Edit(messageID).StyledText(ctx, text...)
+	return err
+}
+
+func (clientTelegram) Delete(ctx *ext.Context, u *ext.Update, messageIDs ...int) error {
+	return ctx.DeleteMessages(u.EffectiveChat().GetID(), messageIDs)
+}
+
+func (clientTelegram) Upload(ctx *ext.Context, u *ext.Update, name, mimeType string, data []byte) error {
+	f, err := uploader.NewUploader(ctx.Raw).FromBytes(ctx, name, data)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	var media tg.InputMediaClass = &tg.InputMediaUploadedDocument{
+		MimeType: mimeType,
+		File:     f,
+		Attributes: []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeFilename{FileName: name},
+		},
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		media = &tg.InputMediaUploadedPhoto{File: f}
+	}
+	_, err = ctx.SendMedia(u.EffectiveChat().GetID(), &tg.MessagesSendMediaRequest{
+		Media:   media,
+		ReplyTo: &tg.InputReplyToMessage{ReplyToMsgID: u.EffectiveMessage.ID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send %s: %w", name, err)
+	}
+	return nil
+}
+
+// Telegram returns the API the command should talk to Telegram through
+func (a *Arguments) Telegram() Telegram {
+	if a.telegram == nil {
+		return clientTelegram{}
+	}
+	return a.telegram
+}
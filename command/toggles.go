@@ -0,0 +1,172 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// toggleState is the persisted form of Toggles
+type toggleState struct {
+	DisabledModules  []string `json:"disabled_modules"`
+	DisabledCommands []string `json:"disabled_commands"`
+}
+
+// Toggles tracks which modules and commands are disabled at runtime. Since dispatcher handlers
+// can't be removed, disabled commands stay registered and are skipped when they're invoked.
+// Commands are keyed by their module and full name, as in "notes/note add", so commands of the
+// same name in different modules and subcommands are toggled on their own. The state is
+// persisted as JSON so it survives restarts.
+type Toggles struct {
+	path     string
+	mu       sync.RWMutex
+	modules  map[string]bool
+	commands map[string]bool
+}
+
+// NewToggles loads the toggle state from the given file. A missing file means everything is
+// enabled.
+func NewToggles(path string) (*Toggles, error) {
+	t := &Toggles{
+		path:     path,
+		modules:  make(map[string]bool),
+		commands: make(map[string]bool),
+	}
+
+	var state toggleState
+	if err := loadJSON(path, &state); err != nil {
+		return nil, fmt.Errorf("failed to load toggles: %w", err)
+	}
+	for _, name := range state.DisabledModules {
+		t.modules[name] = true
+	}
+	for _, name := range state.DisabledCommands {
+		t.commands[name] = true
+	}
+	return t, nil
+}
+
+// ModuleEnabled reports whether the module is enabled. A nil Toggles enables everything.
+func (t *Toggles) ModuleEnabled(name string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return !t.modules[name]
+}
+
+// CommandEnabled reports whether the command with the given full name in the module is
+// enabled. Subcommands of a disabled command are enabled unless disabled themselves. A nil
+// Toggles enables everything.
+func (t *Toggles) CommandEnabled(module, name string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return !t.commands[CommandKey(module, name)]
+}
+
+// SetModule enables or disables a module, reporting whether its state changed
+func (t *Toggles) SetModule(name string, enabled bool) (bool, error) {
+	return t.set(t.modules, name, enabled)
+}
+
+// SetCommand enables or disables the command with the given full name in the module,
+// reporting whether its state changed
+func (t *Toggles) SetCommand(module, name string, enabled bool) (bool, error) {
+	return t.set(t.commands, CommandKey(module, name), enabled)
+}
+
+// DisabledModules returns the names of all disabled modules
+func (t *Toggles) DisabledModules() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return sortedKeys(t.modules)
+}
+
+// DisabledCommands returns the keys of all disabled commands
+func (t *Toggles) DisabledCommands() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return sortedKeys(t.commands)
+}
+
+func (t *Toggles) set(disabled map[string]bool, name string, enabled bool) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if disabled[name] == !enabled {
+		return false, nil
+	}
+	if enabled {
+		delete(disabled, name)
+	} else {
+		disabled[name] = true
+	}
+
+	state := toggleState{
+		DisabledModules:  sortedKeys(t.modules),
+		DisabledCommands: sortedKeys(t.commands),
+	}
+	if err := saveJSON(t.path, state); err != nil {
+		return false, fmt.Errorf("failed to save toggles: %w", err)
+	}
+	return true, nil
+}
+
+// CommandKey returns the key a command is toggled by, its module and full name separated by
+// a slash
+func CommandKey(module, name string) string {
+	return module + "/" + name
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadJSON decodes the JSON file at path into v, leaving v untouched if the file doesn't exist
+func loadJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON atomically replaces the file at path with the JSON encoding of v
+func saveJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,115 @@
+package command
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// toggleRegistry returns a registry with toggles where two modules have a note command
+func toggleRegistry(t *testing.T) *Registry {
+	t.Helper()
+	notes := NewBaseModule("notes", "Notes")
+	notes.AddCommand(NewCommand("note").
+		WithAliases("n").
+		WithSubcommands(NewCommand("add").WithAliases("a"), NewCommand("list")))
+	notes.AddCommand(NewCommand("todo"))
+	music := NewBaseModule("music", "Music")
+	music.AddCommand(NewCommand("note"))
+
+	toggles, err := NewToggles(filepath.Join(t.TempDir(), "toggles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(".")
+	r.AddModule(notes)
+	r.AddModule(music)
+	r.SetToggles(toggles)
+	return r
+}
+
+func TestRegistry_LookupCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "unambiguous name", ref: "todo", want: "notes/todo"},
+		{name: "alias", ref: "n", want: "notes/note"},
+		{name: "qualified name", ref: "music/note", want: "music/note"},
+		{name: "qualified alias", ref: "notes/n", want: "notes/note"},
+		{name: "subcommand", ref: "notes/note add", want: "notes/note add"},
+		{name: "subcommand alias", ref: "n a", want: "notes/note add"},
+		{name: "ambiguous name", ref: "note", wantErr: true},
+		{name: "unknown command", ref: "nope", wantErr: true},
+		{name: "wrong module", ref: "music/todo", wantErr: true},
+		{name: "unknown subcommand", ref: "n remove", wantErr: true},
+	}
+
+	r := toggleRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, module, err := r.LookupCommand(tt.ref)
+			if tt.wantErr {
+				if !IsUserError(err) {
+					t.Errorf("LookupCommand(%q) error = %v, want a user error", tt.ref, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LookupCommand(%q) error = %v", tt.ref, err)
+			}
+			if got := CommandKey(module.Name(), cmd.FullName()); got != tt.want {
+				t.Errorf("LookupCommand(%q) = %s, want %s", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Enabled(t *testing.T) {
+	r := toggleRegistry(t)
+	note, notes, _ := r.LookupCommand("notes/note")
+	add, _, _ := r.LookupCommand("notes/note add")
+	list, _, _ := r.LookupCommand("notes/note list")
+	musicNote, music, _ := r.LookupCommand("music/note")
+
+	if _, err := r.Toggles().SetCommand("notes", "note add", false); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Enabled(note, notes) || r.Enabled(add, notes) || !r.Enabled(list, notes) {
+		t.Errorf("disabling a subcommand should leave its parent and siblings enabled")
+	}
+
+	if _, err := r.Toggles().SetCommand("music", "note", false); err != nil {
+		t.Fatal(err)
+	}
+	if r.Enabled(musicNote, music) || !r.Enabled(note, notes) {
+		t.Errorf("disabling a command should leave commands of the same name in other modules enabled")
+	}
+
+	if _, err := r.Toggles().SetCommand("notes", "note", false); err != nil {
+		t.Fatal(err)
+	}
+	if r.Enabled(list, notes) {
+		t.Errorf("disabling a command should disable its subcommands")
+	}
+
+	if _, err := r.Toggles().SetModule("notes", false); err != nil {
+		t.Fatal(err)
+	}
+	todo, _, _ := r.LookupCommand("todo")
+	if r.Enabled(todo, notes) {
+		t.Errorf("disabling a module should disable its commands")
+	}
+
+	want := []string{"music/note", "notes/note", "notes/note add"}
+	reloaded, err := NewToggles(r.Toggles().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.DisabledCommands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded DisabledCommands() = %v, want %v", got, want)
+	}
+}
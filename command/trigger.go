@@ -0,0 +1,180 @@
+package command
+
+import (
+	"regexp"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+)
+
+// Trigger is a handler invoked by messages matching a regular expression rather than a prefix
+// and command name, such as sed-style corrections or keyword replies. The capture groups of the
+// match are exposed to the handler through Arguments.
+type Trigger struct {
+	// Name identifies the trigger in help, logs and errors
+	Name string
+	// Description is a short description of what the trigger does
+	Description string
+	// Pattern is matched against the text of every message
+	Pattern *regexp.Regexp
+	// Outgoing determines if the trigger should handle outgoing messages
+	Outgoing bool
+	// Incoming determines if the trigger should handle incoming messages
+	Incoming bool
+	// Role is required to fire the trigger from incoming messages. Outgoing messages are
+	// always allowed.
+	Role Role
+	// Handler is called with the capture groups of the match
+	Handler HandlerFunc
+	// Middlewares wrap the handler, outermost first, inside the registry's and the module's
+	Middlewares []Middleware
+	// Cooldown limits how often the trigger fires per user, per chat and globally
+	Cooldown Cooldown
+}
+
+// NewTrigger creates a new trigger matching the given regular expression. Like commands,
+// triggers only handle outgoing messages by default. It panics if the pattern doesn't compile.
+func NewTrigger(name, pattern string) *Trigger {
+	return &Trigger{
+		Name:     name,
+		Pattern:  regexp.MustCompile(pattern),
+		Outgoing: true,
+		Incoming: false,
+		Role:     RoleSudo,
+	}
+}
+
+// WithDescription sets the trigger description
+func (t *Trigger) WithDescription(description string) *Trigger {
+	t.Description = description
+	return t
+}
+
+// WithOutgoing sets whether the trigger handles outgoing messages
+func (t *Trigger) WithOutgoing(outgoing bool) *Trigger {
+	t.Outgoing = outgoing
+	return t
+}
+
+// WithIncoming sets whether the trigger handles incoming messages
+func (t *Trigger) WithIncoming(incoming bool) *Trigger {
+	t.Incoming = incoming
+	return t
+}
+
+// WithRole sets the role required to fire the trigger from incoming messages
+func (t *Trigger) WithRole(role Role) *Trigger {
+	t.Role = role
+	return t
+}
+
+// WithHandler sets the trigger handler
+func (t *Trigger) WithHandler(handler HandlerFunc) *Trigger {
+	t.Handler = handler
+	return t
+}
+
+// WithMiddleware appends middlewares that wrap the trigger's handler
+func (t *Trigger) WithMiddleware(middlewares ...Middleware) *Trigger {
+	t.Middlewares = append(t.Middlewares, middlewares...)
+	return t
+}
+
+// WithCooldown limits how often the trigger fires. Zero limits are not enforced.
+func (t *Trigger) WithCooldown(perUser, perChat, global Limit) *Trigger {
+	t.Cooldown = Cooldown{PerUser: perUser, PerChat: perChat, Global: global}
+	return t
+}
+
+// Register registers the trigger with the given dispatcher, wrapping its handler with the
+// registry's, the module's and its own middlewares
+func (t *Trigger) Register(d dispatcher.Dispatcher, r *Registry, module Module) {
+	if t.Handler == nil {
+		return
+	}
+
+	var middlewares []Middleware
+	middlewares = append(middlewares, r.Middlewares()...)
+	if t.Cooldown.IsLimited() {
+		middlewares = append(middlewares, Throttle(NewRateLimiter(t.Cooldown), r.ThrottleMessage()))
+	}
+	middlewares = append(middlewares, module.Middlewares()...)
+	middlewares = append(middlewares, t.Middlewares...)
+	handler := Chain(t.Handler, middlewares...)
+
+	// Triggers can match messages that also invoke a command, so they remember their own
+	// responses rather than sharing the registry's
+	responses := NewResponses(defaultResponseLimit)
+
+	filter := func(m *types.Message) bool {
+		if m.Out && !t.Outgoing {
+			return false
+		}
+		if !m.Out && !t.Incoming {
+			return false
+		}
+		return t.Pattern.MatchString(m.Text)
+	}
+
+	d.AddHandler(handlers.NewMessage(filter, func(ctx *ext.Context, u *ext.Update) error {
+		m := u.EffectiveMessage
+		edited := isEdit(u)
+
+		// Only edits of our own messages fire triggers again
+		if edited && !m.Out {
+			return nil
+		}
+
+		// Quietly ignore incoming messages from senders without the required role
+		if !m.Out {
+			if !r.Permissions().Allowed(t.Role, senderID(m), u.EffectiveChat().GetID(), module.Name()) {
+				return nil
+			}
+		}
+
+		// Triggers of disabled modules stay registered, so they're skipped here instead
+		if !r.Toggles().ModuleEnabled(module.Name()) {
+			return nil
+		}
+
+		if !responses.Track(u.EffectiveChat().GetID(), m.ID, m.Text) {
+			return nil
+		}
+
+		return handler(ctx, u, &Arguments{
+			Raw:       m.Text,
+			Reply:     m.ReplyToMessage,
+			Trigger:   t,
+			Matches:   t.Pattern.FindStringSubmatch(m.Text),
+			Edited:    edited,
+			responses: responses,
+			telegram:  r.Telegram(),
+		})
+	}))
+}
+
+// Group returns the text captured by the named group of a trigger's pattern, or an empty string
+// if the group didn't participate in the match
+func (a *Arguments) Group(name string) string {
+	if a.Trigger == nil {
+		return ""
+	}
+	i := a.Trigger.Pattern.SubexpIndex(name)
+	if i < 0 || i >= len(a.Matches) {
+		return ""
+	}
+	return a.Matches[i]
+}
+
+// name returns the full name of the command or the name of the trigger being run
+func (a *Arguments) name() string {
+	if a.Trigger != nil {
+		return a.Trigger.Name
+	}
+	if a.Command != nil {
+		return a.Command.FullName()
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package command
 import (
 	"fmt"
 	"strings"
+	"unicode"
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/dispatcher/handlers"
@@ -12,8 +13,8 @@ import (
 
 // Module represents a collection of related commands and functionality
 type Module interface {
-	// Load registers all module commands with the dispatcher
-	Load(d dispatcher.Dispatcher, prefix string)
+	// Load registers all module commands and triggers with the dispatcher
+	Load(d dispatcher.Dispatcher, r *Registry)
 	// Name returns the module's name
 	Name() string
 	// Description returns the module's description
@@ -22,6 +23,12 @@ type Module interface {
 	AddCommand(cmd *Command)
 	// GetCommands returns the module's commands
 	GetCommands() []*Command
+	// AddTrigger adds a trigger to the module
+	AddTrigger(trigger *Trigger)
+	// GetTriggers returns the module's triggers
+	GetTriggers() []*Trigger
+	// Middlewares returns the middlewares applied to every command in the module
+	Middlewares() []Middleware
 }
 
 // BaseModule provides a default implementation of Module
@@ -29,6 +36,8 @@ type BaseModule struct {
 	name        string
 	description string
 	commands    []*Command
+	triggers    []*Trigger
+	middlewares []Middleware
 }
 
 // NewBaseModule creates a new base module
@@ -60,10 +69,33 @@ func (m *BaseModule) GetCommands() []*Command {
 	return m.commands
 }
 
-// Load registers all module commands with the dispatcher
-func (m *BaseModule) Load(d dispatcher.Dispatcher, prefix string) {
+// AddTrigger adds a trigger to the module
+func (m *BaseModule) AddTrigger(trigger *Trigger) {
+	m.triggers = append(m.triggers, trigger)
+}
+
+// GetTriggers returns the module's triggers
+func (m *BaseModule) GetTriggers() []*Trigger {
+	return m.triggers
+}
+
+// Use adds middlewares that wrap every command in the module
+func (m *BaseModule) Use(middlewares ...Middleware) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+// Middlewares returns the middlewares applied to every command in the module
+func (m *BaseModule) Middlewares() []Middleware {
+	return m.middlewares
+}
+
+// Load registers all module commands and triggers with the dispatcher
+func (m *BaseModule) Load(d dispatcher.Dispatcher, r *Registry) {
 	for _, cmd := range m.commands {
-		cmd.Register(d, prefix)
+		cmd.Register(d, r, m)
+	}
+	for _, trigger := range m.triggers {
+		trigger.Register(d, r, m)
 	}
 }
 
@@ -80,7 +112,7 @@ type Command struct {
 	Usage string
 	// Description is a longer help text explaining what the command does
 	Description string
-	// Prefix is the command prefix (e.g. "!", "/"). If empty, uses registry's default prefix
+	// Prefix is the command prefix (e.g. "!", "/"). If empty, uses the prefixes accepted in the chat
 	Prefix string
 	// Hidden determines if this command should be hidden from help listings
 	Hidden bool
@@ -88,10 +120,29 @@ type Command struct {
 	Outgoing bool
 	// Incoming determines if this command responds to incoming messages
 	Incoming bool
+	// Role is the role a sender needs to invoke this command from an incoming message
+	Role Role
 	// Arguments defines the expected arguments for this command
 	Arguments []ArgumentDefinition
 	// Handler is the function that handles the command with parsed arguments
 	Handler HandlerFunc
+	// Middlewares wrap the handler, running after any registry and module middlewares
+	Middlewares []Middleware
+	// Cooldown limits how often the command can be invoked
+	Cooldown Cooldown
+	// Strict makes unknown flags an error instead of treating them as text
+	Strict bool
+	// Subcommands are dispatched to by the first word of the arguments (e.g. "notes add")
+	Subcommands []*Command
+	// Parent is the command this command is a subcommand of, if any
+	Parent *Command
+	// Sink delivers what the command passes to Arguments.Output when it isn't piped into another
+	// command. RespondSink is used if nil.
+	Sink Sink
+	// Job runs the command as a job, which can report progress and be cancelled
+	Job bool
+
+	chain HandlerFunc // Handler wrapped with every middleware, set when registered
 }
 
 // NewCommand creates a new command with the given name
@@ -100,6 +151,7 @@ func NewCommand(name string) *Command {
 		Name:     name,
 		Outgoing: true, // Default to outgoing only
 		Incoming: false,
+		Role:     RoleSudo,
 	}
 }
 
@@ -139,12 +191,49 @@ func (c *Command) WithIncoming(incoming bool) *Command {
 	return c
 }
 
+// WithRole sets the role a sender needs to invoke the command from an incoming message
+func (c *Command) WithRole(role Role) *Command {
+	c.Role = role
+	return c
+}
+
 // WithHandler sets the command's handler function
 func (c *Command) WithHandler(handler HandlerFunc) *Command {
 	c.Handler = handler
 	return c
 }
 
+// WithMiddleware adds middlewares that wrap the command's handler
+func (c *Command) WithMiddleware(middlewares ...Middleware) *Command {
+	c.Middlewares = append(c.Middlewares, middlewares...)
+	return c
+}
+
+// WithCooldown limits how often the command can be invoked per user, per chat and globally.
+// Pass a zero Limit for scopes that shouldn't be limited.
+func (c *Command) WithCooldown(perUser, perChat, global Limit) *Command {
+	c.Cooldown = Cooldown{PerUser: perUser, PerChat: perChat, Global: global}
+	return c
+}
+
+// WithStrict sets whether unknown flags are an error instead of being treated as text
+func (c *Command) WithStrict(strict bool) *Command {
+	c.Strict = strict
+	return c
+}
+
+// WithSink sets how the command's output is delivered when it isn't piped
+func (c *Command) WithSink(sink Sink) *Command {
+	c.Sink = sink
+	return c
+}
+
+// WithJob sets whether the command runs as a job that can report progress and be cancelled
+func (c *Command) WithJob(job bool) *Command {
+	c.Job = job
+	return c
+}
+
 // WithAliases sets alternative names for the command
 func (c *Command) WithAliases(aliases ...string) *Command {
 	c.Aliases = aliases
@@ -157,29 +246,85 @@ func (c *Command) WithArguments(args ...ArgumentDefinition) *Command {
 	return c
 }
 
-// Register registers the command with the given dispatcher and default prefix
-func (c *Command) Register(d dispatcher.Dispatcher, defaultPrefix string) {
-	if c.Handler == nil {
-		return // Skip registration if no handler is set
+// WithSubcommands adds subcommands which are dispatched to by the first word of the arguments.
+// Each subcommand has its own arguments, handler, middlewares and cooldown. If no subcommand
+// matches, the command's own handler runs, if it has one.
+func (c *Command) WithSubcommands(subcommands ...*Command) *Command {
+	for _, sub := range subcommands {
+		sub.Parent = c
+	}
+	c.Subcommands = append(c.Subcommands, subcommands...)
+	return c
+}
+
+// FindSubcommand looks up a direct subcommand by name or alias
+func (c *Command) FindSubcommand(name string) *Command {
+	for _, sub := range c.Subcommands {
+		if sub.Matches(name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// FullName returns the command's name prefixed with the names of its parents (e.g. "notes add")
+func (c *Command) FullName() string {
+	if c.Parent == nil {
+		return c.Name
+	}
+	return c.Parent.FullName() + " " + c.Name
+}
+
+// Root returns the top-level command the command is a subcommand of, or the command itself
+func (c *Command) Root() *Command {
+	for c.Parent != nil {
+		c = c.Parent
+	}
+	return c
+}
+
+// Matches reports whether the given name is the command's name or one of its aliases
+func (c *Command) Matches(name string) bool {
+	if c.Name == name {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Register registers the command with the given dispatcher, wrapping its handler with the
+// registry's, the module's and its own middlewares
+func (c *Command) Register(d dispatcher.Dispatcher, r *Registry, module Module) {
+	if c.Handler == nil && len(c.Subcommands) == 0 {
+		return // Skip registration if there is nothing to dispatch to
 	}
 
-	// Use command's prefix if set, otherwise use default
-	prefix := c.Prefix
-	if prefix == "" {
-		prefix = defaultPrefix
+	// Build the middleware chain once, parsing arguments innermost so that parse errors
+	// flow through the middlewares like any other handler error. Cooldowns are enforced right
+	// after the registry middlewares so throttled invocations are still rendered and logged,
+	// followed by the job tracking of long-running commands.
+	var middlewares []Middleware
+	middlewares = append(middlewares, r.Middlewares()...)
+	if c.Cooldown.IsLimited() {
+		middlewares = append(middlewares, Throttle(NewRateLimiter(c.Cooldown), r.ThrottleMessage()))
 	}
+	if c.Job {
+		middlewares = append(middlewares, r.Jobs().Middleware())
+	}
+	middlewares = append(middlewares, module.Middlewares()...)
+	middlewares = append(middlewares, c.Middlewares...)
+	handler := Chain(c.dispatch(r, module), middlewares...)
 
 	// Create message filter for messages that match the command or its aliases
 	createMessageFilter := func(cmdName string) func(m *types.Message) bool {
 		return func(m *types.Message) bool {
-			// Check if message starts with the command
-			if !strings.HasPrefix(m.Text, prefix+cmdName) {
-				return false
-			}
-
-			// Check if it's an exact match or followed by a space or end of string
-			cmdLen := len(prefix + cmdName)
-			if len(m.Text) > cmdLen && m.Text[cmdLen] != ' ' {
+			// Check if the message starts with the command and one of the chat's prefixes,
+			// followed by a space or the end of the text
+			if _, ok := matchCommand(m.Text, r.CommandPrefixes(c, messageChatID(m)), cmdName); !ok {
 				return false
 			}
 
@@ -195,21 +340,14 @@ func (c *Command) Register(d dispatcher.Dispatcher, defaultPrefix string) {
 		}
 	}
 
-	// Create a wrapper handler that parses arguments
+	c.chain = handler
+
+	// Create a wrapper handler that extracts the argument text
 	createHandler := func(cmdName string) func(ctx *ext.Context, u *ext.Update) error {
 		return func(ctx *ext.Context, u *ext.Update) error {
-			// Extract the argument text (everything after the command)
-			cmdPrefix := prefix + cmdName
-			argText := strings.TrimSpace(strings.TrimPrefix(u.EffectiveMessage.Text, cmdPrefix))
-
-			// Parse arguments with message context
-			args, err := ParseArguments(argText, c.Arguments, u.EffectiveMessage)
-			if err != nil {
-				return fmt.Errorf("invalid arguments: %v", err)
-			}
-
-			// Call the original handler with parsed arguments
-			return c.Handler(ctx, u, args)
+			prefix, _ := matchCommand(u.EffectiveMessage.Text, r.CommandPrefixes(c, u.EffectiveChat().GetID()), cmdName)
+			argText := strings.TrimSpace(strings.TrimPrefix(u.EffectiveMessage.Text, prefix+cmdName))
+			return c.invoke(ctx, u, r, module, prefix, argText)
 		}
 	}
 
@@ -221,3 +359,156 @@ func (c *Command) Register(d dispatcher.Dispatcher, defaultPrefix string) {
 		d.AddHandler(handlers.NewMessage(createMessageFilter(alias), createHandler(alias)))
 	}
 }
+
+// invoke runs the command for a message with the given prefix and argument text, unless the
+// sender lacks the required role or the command is disabled
+func (c *Command) invoke(ctx *ext.Context, u *ext.Update, r *Registry, module Module, prefix, argText string) error {
+	m := u.EffectiveMessage
+	edited := isEdit(u)
+
+	// Only edits of our own messages re-run commands
+	if edited && !m.Out {
+		return nil
+	}
+
+	// Quietly ignore incoming messages from senders without the required role
+	if !m.Out {
+		if !r.Permissions().Allowed(c.Role, senderID(m), u.EffectiveChat().GetID(), module.Name()) {
+			return nil
+		}
+	}
+
+	// Skip edits that didn't change the text, like link previews being attached
+	if !r.Responses().Track(u.EffectiveChat().GetID(), m.ID, m.Text) {
+		return nil
+	}
+
+	// Disabled commands stay registered, so they're skipped here instead
+	if !r.Enabled(c, module) {
+		if !m.Out {
+			return nil
+		}
+		args := &Arguments{Command: c, Prefix: prefix, Edited: edited, responses: r.Responses(), telegram: r.Telegram()}
+		if !r.Toggles().ModuleEnabled(module.Name()) {
+			return args.RespondText(ctx, u, fmt.Sprintf("⛔ The %s module is disabled", module.Name()))
+		}
+		return args.RespondText(ctx, u, fmt.Sprintf("⛔ The %s%s command is disabled", prefix, c.Name))
+	}
+
+	// Commands piped into other commands run as a pipeline
+	argText, stages, err := r.splitPipeline(argText)
+	if err != nil {
+		if !m.Out {
+			return nil
+		}
+		args := &Arguments{Command: c, Prefix: prefix, Edited: edited, responses: r.Responses(), telegram: r.Telegram()}
+		return args.RespondText(ctx, u, "❌ "+err.Error())
+	}
+	if len(stages) > 0 {
+		return r.runPipeline(ctx, u, prefix, append([]pipeStage{{cmd: c, module: module, argText: argText}}, stages...))
+	}
+
+	// Run the handler chain, which parses the arguments before calling the handler
+	return c.chain(ctx, u, &Arguments{
+		Raw:       argText,
+		Reply:     m.ReplyToMessage,
+		Command:   c,
+		Prefix:    prefix,
+		Edited:    edited,
+		responses: r.Responses(),
+		telegram:  r.Telegram(),
+	})
+}
+
+// dispatch returns the innermost handler of the command. It hands invocations whose first word
+// names a subcommand to that subcommand's chain, and otherwise parses the arguments and calls
+// the command's own handler.
+func (c *Command) dispatch(r *Registry, module Module) HandlerFunc {
+	subcommands := make(map[*Command]HandlerFunc, len(c.Subcommands))
+	for _, sub := range c.Subcommands {
+		var middlewares []Middleware
+		if sub.Cooldown.IsLimited() {
+			middlewares = append(middlewares, Throttle(NewRateLimiter(sub.Cooldown), r.ThrottleMessage()))
+		}
+		if sub.Job && !c.Job {
+			middlewares = append(middlewares, r.Jobs().Middleware())
+		}
+		middlewares = append(middlewares, sub.Middlewares...)
+		subcommands[sub] = Chain(sub.dispatch(r, module), middlewares...)
+	}
+
+	return func(ctx *ext.Context, u *ext.Update, args *Arguments) error {
+		if len(c.Subcommands) > 0 {
+			name, rest := splitWord(args.Raw)
+			if sub := c.FindSubcommand(name); sub != nil {
+				// Subcommands may require a higher role than their parent
+				m := u.EffectiveMessage
+				if !m.Out {
+					if !r.Permissions().Allowed(sub.Role, senderID(m), u.EffectiveChat().GetID(), module.Name()) {
+						return nil
+					}
+				}
+
+				// Subcommands can be disabled on their own
+				if !r.Toggles().CommandEnabled(module.Name(), sub.FullName()) {
+					if !m.Out {
+						return nil
+					}
+					return args.RespondText(ctx, u, fmt.Sprintf("⛔ The %s%s command is disabled", args.Prefix, sub.FullName()))
+				}
+
+				args.Raw = rest
+				args.Command = sub
+				return subcommands[sub](ctx, u, args)
+			}
+
+			if c.Handler == nil {
+				names := make([]string, len(c.Subcommands))
+				for i, sub := range c.Subcommands {
+					names[i] = sub.Name
+				}
+				if name == "" {
+					return UserErrorf("missing subcommand, expected one of: %s", strings.Join(names, ", "))
+				}
+				if suggestions := Suggest(name, names, 1); len(suggestions) > 0 {
+					return UserErrorf("unknown subcommand %q, did you mean %s?", name, suggestions[0])
+				}
+				return UserErrorf("unknown subcommand %q, expected one of: %s", name, strings.Join(names, ", "))
+			}
+		}
+
+		parsed, err := parseArguments(args.Raw, c.Arguments, u.EffectiveMessage, c.Strict)
+		if err != nil {
+			return err
+		}
+		parsed.Command = args.Command
+		parsed.Prefix = args.Prefix
+		parsed.Edited = args.Edited
+		parsed.responses = args.responses
+		parsed.input = args.input
+		parsed.pipe = args.pipe
+		parsed.job = args.job
+		parsed.telegram = args.telegram
+
+		// Piped input becomes the end of the rest argument
+		if args.input != nil {
+			rest := strings.TrimSpace(parsed.GetRest() + " " + *args.input)
+			parsed.Rest = &ParsedArgument{Name: "rest", Value: rest, RawValue: rest}
+			parsed.Raw = strings.TrimSpace(parsed.Raw + " " + *args.input)
+		}
+		*args = *parsed
+
+		return c.Handler(ctx, u, args)
+	}
+}
+
+// splitWord splits off the first whitespace-separated word of the text, returning it along with
+// the trimmed remainder
+func splitWord(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
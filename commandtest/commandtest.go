@@ -0,0 +1,223 @@
+// Package commandtest runs commands the way the dispatcher would, without a Telegram client.
+// Messages are delivered to the handlers registered by the real Command.Register, and whatever
+// the commands send back through command.Telegram is recorded for assertions.
+//
+//	h := commandtest.New(module)
+//	h.Send(commandtest.Message{Text: ".echo hi"})
+//	h.Recorder.AssertReplied(t, "hi")
+package commandtest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/watzon/macron/command"
+)
+
+const (
+	// SelfID is the ID of the account the commands run as
+	SelfID int64 = 1000
+	// ChatID is the chat messages are sent to unless they name another
+	ChatID int64 = 2000
+)
+
+// Message is a message delivered to the registered handlers. The zero value is an outgoing
+// message in a private chat.
+type Message struct {
+	// ID of the message. Messages without one get the next free ID.
+	ID int
+	// Text of the message
+	Text string
+	// Entities are the formatting entities of the text
+	Entities []tg.MessageEntityClass
+	// Incoming makes the message one sent by someone else rather than by the account
+	Incoming bool
+	// SenderID is the sender of an incoming message. It defaults to the chat in private chats.
+	SenderID int64
+	// ChatID is the chat the message is sent in, ChatID if 0
+	ChatID int64
+	// Group sends the message in a supergroup rather than a private chat
+	Group bool
+	// ReplyTo is the message being replied to
+	ReplyTo *Message
+	// Edit delivers the message as an edit of the message with the same ID
+	Edit bool
+}
+
+// Harness delivers messages to the handlers of a registry, recording what they send
+type Harness struct {
+	Registry *command.Registry
+	Recorder *Recorder
+
+	dispatcher *Dispatcher
+	lastID     atomic.Int64
+}
+
+// New creates a harness for a registry with the default "." prefix, registering the given
+// modules with it. Errors are replied to and panics recovered, like main wires the registry.
+func New(modules ...command.Module) *Harness {
+	registry := command.NewRegistry(".")
+	registry.Use(command.ErrorReply(), command.Recover())
+	for _, module := range modules {
+		registry.AddModule(module)
+	}
+	return NewWithRegistry(registry)
+}
+
+// NewWithRegistry creates a harness for a registry that's already set up with its modules,
+// middlewares and settings. The registry's modules are registered with the harness.
+func NewWithRegistry(registry *command.Registry) *Harness {
+	h := &Harness{
+		Registry:   registry,
+		dispatcher: &Dispatcher{},
+	}
+	h.Recorder = &Recorder{nextID: h.nextID}
+	registry.SetTelegram(h.Recorder)
+	registry.RegisterAll(h.dispatcher)
+	return h
+}
+
+// Send delivers a message to every registered handler and returns the errors the handlers
+// returned, joined
+func (h *Harness) Send(msg Message) error {
+	ctx, u := h.Update(msg)
+	return h.dispatcher.Dispatch(ctx, u)
+}
+
+// SendText delivers an outgoing message with the given text in the default chat
+func (h *Harness) SendText(text string) error {
+	return h.Send(Message{Text: text})
+}
+
+// Update builds the context and update the dispatcher would hand to handlers for a message.
+// The context has no client, so handlers must go through command.Telegram.
+func (h *Harness) Update(msg Message) (*ext.Context, *ext.Update) {
+	entities := &tg.Entities{
+		Users:    map[int64]*tg.User{SelfID: {ID: SelfID, Self: true}},
+		Chats:    map[int64]*tg.Chat{},
+		Channels: map[int64]*tg.Channel{},
+	}
+
+	m := h.message(msg, entities)
+	if msg.ReplyTo != nil {
+		reply := *msg.ReplyTo
+		if reply.ChatID == 0 {
+			reply.ChatID, reply.Group = msg.ChatID, msg.Group
+		}
+		m.ReplyToMessage = h.message(reply, entities)
+		m.ReplyTo = &tg.MessageReplyHeader{ReplyToMsgID: m.ReplyToMessage.ID}
+	}
+
+	var update tg.UpdateClass
+	switch {
+	case msg.Edit && msg.Group:
+		update = &tg.UpdateEditChannelMessage{Message: m.Message}
+	case msg.Edit:
+		update = &tg.UpdateEditMessage{Message: m.Message}
+	case msg.Group:
+		update = &tg.UpdateNewChannelMessage{Message: m.Message}
+	default:
+		update = &tg.UpdateNewMessage{Message: m.Message}
+	}
+
+	ctx := &ext.Context{
+		Context:  context.Background(),
+		Self:     entities.Users[SelfID],
+		Entities: entities,
+	}
+	u := &ext.Update{
+		EffectiveMessage: m,
+		UpdateClass:      update,
+		Entities:         entities,
+	}
+	return ctx, u
+}
+
+// message builds a message and adds its chat and sender to the entities
+func (h *Harness) message(msg Message, entities *tg.Entities) *types.Message {
+	if msg.ID == 0 {
+		msg.ID = h.nextID()
+	}
+	if msg.ChatID == 0 {
+		msg.ChatID = ChatID
+	}
+
+	m := &tg.Message{
+		ID:       msg.ID,
+		Out:      !msg.Incoming,
+		Message:  msg.Text,
+		Entities: msg.Entities,
+	}
+
+	if msg.Group {
+		entities.Channels[msg.ChatID] = &tg.Channel{ID: msg.ChatID, Megagroup: true}
+		m.PeerID = &tg.PeerChannel{ChannelID: msg.ChatID}
+	} else {
+		entities.Users[msg.ChatID] = &tg.User{ID: msg.ChatID}
+		m.PeerID = &tg.PeerUser{UserID: msg.ChatID}
+	}
+
+	switch {
+	case !msg.Incoming:
+		m.FromID = &tg.PeerUser{UserID: SelfID}
+	case msg.SenderID != 0:
+		entities.Users[msg.SenderID] = &tg.User{ID: msg.SenderID}
+		m.FromID = &tg.PeerUser{UserID: msg.SenderID}
+	}
+
+	return types.ConstructMessage(m)
+}
+
+// nextID returns an ID no message of the harness has used yet
+func (h *Harness) nextID() int {
+	return int(h.lastID.Add(1))
+}
+
+// Dispatcher collects the handlers registered with it and hands them updates in the order
+// they were added, like gotgproto's dispatcher does for a single handler group
+type Dispatcher struct {
+	handlers []dispatcher.Handler
+}
+
+var _ dispatcher.Dispatcher = (*Dispatcher)(nil)
+
+// Initialize is a no-op, the dispatcher isn't connected to a client
+func (d *Dispatcher) Initialize(context.Context, context.CancelFunc, *telegram.Client, *tg.User) {}
+
+// Handle is a no-op, use Dispatch to deliver updates
+func (d *Dispatcher) Handle(context.Context, tg.UpdatesClass) error {
+	return nil
+}
+
+// AddHandler adds a handler
+func (d *Dispatcher) AddHandler(handler dispatcher.Handler) {
+	d.handlers = append(d.handlers, handler)
+}
+
+// AddHandlerToGroup adds a handler. Groups are ignored, as the registry only uses one.
+func (d *Dispatcher) AddHandlerToGroup(handler dispatcher.Handler, _ int) {
+	d.AddHandler(handler)
+}
+
+// Dispatch hands an update to every handler, stopping early if one ends or skips the group. It
+// returns the other errors the handlers returned, joined.
+func (d *Dispatcher) Dispatch(ctx *ext.Context, u *ext.Update) error {
+	var errs []error
+	for _, handler := range d.handlers {
+		err := handler.CheckUpdate(ctx, u)
+		switch {
+		case err == nil, errors.Is(err, dispatcher.ContinueGroups):
+		case errors.Is(err, dispatcher.EndGroups), errors.Is(err, dispatcher.SkipCurrentGroup):
+			return errors.Join(errs...)
+		default:
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package commandtest
+
+import (
+	"testing"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/watzon/macron/command"
+)
+
+func testModule() *command.BaseModule {
+	m := command.NewBaseModule("test", "Test commands")
+	m.AddCommand(command.NewCommand("echo").
+		WithUsage("echo <text>").
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			text := args.GetRestString()
+			if text == "" {
+				return command.UserErrorf("text is required")
+			}
+			return args.RespondText(ctx, u, text)
+		}))
+	m.AddCommand(command.NewCommand("del").
+		WithMiddleware(command.DeleteTrigger()).
+		WithHandler(func(*ext.Context, *ext.Update, *command.Arguments) error {
+			return nil
+		}))
+	m.AddCommand(command.NewCommand("job").
+		WithJob(true).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			job := args.Job()
+			if job == nil {
+				return command.UserErrorf("not running as a job")
+			}
+			return args.RespondText(ctx, u, job.Name)
+		}))
+	return m
+}
+
+func TestHarness(t *testing.T) {
+	t.Run("reply", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.Send(Message{ID: 10, Text: ".echo hello"}); err != nil {
+			t.Fatal(err)
+		}
+		action := h.Recorder.AssertReplied(t, "hello")
+		if action.ReplyTo != 10 {
+			t.Errorf("expected a reply to #10, got #%d", action.ReplyTo)
+		}
+	})
+
+	t.Run("user error", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.SendText(".echo"); err != nil {
+			t.Fatal(err)
+		}
+		h.Recorder.AssertReplied(t, "❌ text is required\nUsage: .echo <text>")
+	})
+
+	t.Run("edited trigger", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.Send(Message{ID: 10, Text: ".echo one"}); err != nil {
+			t.Fatal(err)
+		}
+		reply := h.Recorder.AssertReplied(t, "one")
+		if err := h.Send(Message{ID: 10, Text: ".echo two", Edit: true}); err != nil {
+			t.Fatal(err)
+		}
+		edit := h.Recorder.AssertEdited(t, "two")
+		if edit.MessageID != reply.MessageID {
+			t.Errorf("expected #%d to be edited, got #%d", reply.MessageID, edit.MessageID)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.Send(Message{ID: 10, Text: ".del"}); err != nil {
+			t.Fatal(err)
+		}
+		h.Recorder.AssertDeleted(t, 10)
+	})
+
+	t.Run("job", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.SendText(".job"); err != nil {
+			t.Fatal(err)
+		}
+		h.Recorder.AssertReplied(t, "job")
+		if jobs := h.Registry.Jobs().List(); len(jobs) != 0 {
+			t.Errorf("expected the job to be finished, got %d running", len(jobs))
+		}
+	})
+
+	t.Run("incoming", func(t *testing.T) {
+		h := New(testModule())
+		if err := h.Send(Message{Text: ".echo hello", Incoming: true, SenderID: 3000}); err != nil {
+			t.Fatal(err)
+		}
+		h.Recorder.AssertSilent(t)
+	})
+}
@@ -0,0 +1,272 @@
+package commandtest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"github.com/watzon/macron/command"
+)
+
+// Kind is the kind of a recorded action
+type Kind int
+
+const (
+	KindReply  Kind = iota // A message was sent
+	KindEdit               // A message was edited
+	KindDelete             // Messages were deleted
+	KindUpload             // A file was sent
+)
+
+// String returns a human-readable name for the kind
+func (k Kind) String() string {
+	switch k {
+	case KindReply:
+		return "reply"
+	case KindEdit:
+		return "edit"
+	case KindDelete:
+		return "delete"
+	case KindUpload:
+		return "upload"
+	default:
+		return "unknown"
+	}
+}
+
+// Action is something a command sent to Telegram
+type Action struct {
+	Kind Kind
+	// ChatID is the chat the action happened in
+	ChatID int64
+	// MessageID is the ID of the sent or edited message
+	MessageID int
+	// ReplyTo is the message a reply or upload was sent in reply to
+	ReplyTo int
+	// Text and Entities are the formatted text of a reply or edit
+	Text     string
+	Entities []tg.MessageEntityClass
+	// Deleted are the IDs of the deleted messages
+	Deleted []int
+	// FileName, MimeType and Data describe an uploaded file
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+// String describes the action for failure messages
+func (a Action) String() string {
+	switch a.Kind {
+	case KindReply:
+		return fmt.Sprintf("reply #%d to #%d: %q", a.MessageID, a.ReplyTo, a.Text)
+	case KindEdit:
+		return fmt.Sprintf("edit #%d: %q", a.MessageID, a.Text)
+	case KindDelete:
+		return fmt.Sprintf("delete %v", a.Deleted)
+	case KindUpload:
+		return fmt.Sprintf("upload %s (%s, %d bytes)", a.FileName, a.MimeType, len(a.Data))
+	default:
+		return a.Kind.String()
+	}
+}
+
+// Recorder is a command.Telegram that records every action instead of sending it
+type Recorder struct {
+	mu      sync.Mutex
+	actions []Action
+	nextID  func() int
+	err     error
+}
+
+var _ command.Telegram = (*Recorder)(nil)
+
+// Fail makes every following action fail with err, until it's called again with nil
+func (r *Recorder) Fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// Reply records a sent message
+func (r *Recorder) Reply(_ *ext.Context, u *ext.Update, replyTo int, text ...styling.StyledTextOption) (int, error) {
+	if replyTo == 0 {
+		replyTo = u.EffectiveMessage.ID
+	}
+	s, entities, err := render(text)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	id := r.nextID()
+	r.actions = append(r.actions, Action{
+		Kind:      KindReply,
+		ChatID:    u.EffectiveChat().GetID(),
+		MessageID: id,
+		ReplyTo:   replyTo,
+		Text:      s,
+		Entities:  entities,
+	})
+	return id, nil
+}
+
+// Edit records an edited message
+func (r *Recorder) Edit(_ *ext.Context, u *ext.Update, messageID int, text ...styling.StyledTextOption) error {
+	s, entities, err := render(text)
+	if err != nil {
+		return err
+	}
+	return r.record(Action{
+		Kind:      KindEdit,
+		ChatID:    u.EffectiveChat().GetID(),
+		MessageID: messageID,
+		Text:      s,
+		Entities:  entities,
+	})
+}
+
+// Delete records deleted messages
+func (r *Recorder) Delete(_ *ext.Context, u *ext.Update, messageIDs ...int) error {
+	return r.record(Action{
+		Kind:    KindDelete,
+		ChatID:  u.EffectiveChat().GetID(),
+		Deleted: slices.Clone(messageIDs),
+	})
+}
+
+// Upload records a sent file
+func (r *Recorder) Upload(_ *ext.Context, u *ext.Update, name, mimeType string, data []byte) error {
+	return r.record(Action{
+		Kind:     KindUpload,
+		ChatID:   u.EffectiveChat().GetID(),
+		ReplyTo:  u.EffectiveMessage.ID,
+		FileName: name,
+		MimeType: mimeType,
+		Data:     slices.Clone(data),
+	})
+}
+
+func (r *Recorder) record(action Action) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.actions = append(r.actions, action)
+	return nil
+}
+
+// render converts styled text into its plain text and entities
+func render(text []styling.StyledTextOption) (string, []tg.MessageEntityClass, error) {
+	var b entity.Builder
+	if err := styling.Perform(&b, text...); err != nil {
+		return "", nil, err
+	}
+	s, entities := b.Complete()
+	return s, entities, nil
+}
+
+// Actions returns every recorded action, oldest first
+func (r *Recorder) Actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.actions)
+}
+
+// Of returns the recorded actions of the given kind, oldest first
+func (r *Recorder) Of(kind Kind) []Action {
+	var actions []Action
+	for _, action := range r.Actions() {
+		if action.Kind == kind {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// Reset forgets every recorded action
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = nil
+}
+
+// AssertReplied fails the test unless a message with the given text was sent
+func (r *Recorder) AssertReplied(t testing.TB, text string) Action {
+	t.Helper()
+	return r.assertText(t, KindReply, text)
+}
+
+// AssertEdited fails the test unless a message was edited to the given text
+func (r *Recorder) AssertEdited(t testing.TB, text string) Action {
+	t.Helper()
+	return r.assertText(t, KindEdit, text)
+}
+
+// AssertDeleted fails the test unless the message with the given ID was deleted
+func (r *Recorder) AssertDeleted(t testing.TB, messageID int) {
+	t.Helper()
+	for _, action := range r.Of(KindDelete) {
+		if slices.Contains(action.Deleted, messageID) {
+			return
+		}
+	}
+	t.Errorf("message #%d wasn't deleted\n%s", messageID, r.describe())
+}
+
+// AssertUploaded fails the test unless a file with the given name was sent, and returns it
+func (r *Recorder) AssertUploaded(t testing.TB, name string) Action {
+	t.Helper()
+	for _, action := range r.Of(KindUpload) {
+		if action.FileName == name {
+			return action
+		}
+	}
+	t.Errorf("%s wasn't uploaded\n%s", name, r.describe())
+	return Action{}
+}
+
+// AssertSilent fails the test if anything was sent, edited, deleted or uploaded
+func (r *Recorder) AssertSilent(t testing.TB) {
+	t.Helper()
+	if len(r.Actions()) > 0 {
+		t.Errorf("expected no actions\n%s", r.describe())
+	}
+}
+
+func (r *Recorder) assertText(t testing.TB, kind Kind, text string) Action {
+	t.Helper()
+	for _, action := range r.Of(kind) {
+		if action.Text == text {
+			return action
+		}
+	}
+	t.Errorf("no %s with text %q\n%s", kind, text, r.describe())
+	return Action{}
+}
+
+// describe lists the recorded actions for failure messages
+func (r *Recorder) describe() string {
+	actions := r.Actions()
+	if len(actions) == 0 {
+		return "nothing was recorded"
+	}
+
+	var b strings.Builder
+	b.WriteString("recorded:")
+	for _, action := range actions {
+		b.WriteString("\n  " + action.String())
+	}
+	return b.String()
+}
@@ -12,14 +12,19 @@ import (
 
 // Config holds all configuration values for the userbot
 type Config struct {
-	Phone         string
-	AppID         int
-	AppHash       string
-	Debug         bool
-	DataDir       string
-	SessionDir    string
-	LogChannel    int64
-	CommandPrefix string
+	Phone      string
+	AppID      int
+	AppHash    string
+	Debug      bool
+	DataDir    string
+	SessionDir string
+	LogChannel int64
+	// CommandPrefixes are the prefixes accepted in every chat without its own override. The
+	// first one is the primary prefix shown in help.
+	CommandPrefixes []string
+	// ThrottleMessage is shown when a command is rate limited. It is nil when unset so the
+	// default message is used, and an empty string silences throttled commands.
+	ThrottleMessage *string
 
 	OpenRouterAPIKey string
 }
@@ -66,9 +71,9 @@ func Load() (*Config, error) {
 	}
 
 	debug := os.Getenv("DEBUG") == "true"
-	cmdPrefix := os.Getenv("COMMAND_PREFIX")
-	if cmdPrefix == "" {
-		cmdPrefix = "."
+	cmdPrefixes := strings.Fields(os.Getenv("COMMAND_PREFIX"))
+	if len(cmdPrefixes) == 0 {
+		cmdPrefixes = []string{"."}
 	}
 
 	logChannel, err := strconv.ParseInt(strings.TrimPrefix(os.Getenv("LOG_CHANNEL"), "-100"), 10, 64)
@@ -81,6 +86,11 @@ func Load() (*Config, error) {
 		dataDir = "~/.config/macron"
 	}
 
+	var throttleMessage *string
+	if msg, ok := os.LookupEnv("THROTTLE_MESSAGE"); ok {
+		throttleMessage = &msg
+	}
+
 	sessionDir := filepath.Join(dataDir, sessionFolder(phone))
 	err = os.MkdirAll(sessionDir, 0700)
 	if err != nil {
@@ -95,7 +105,8 @@ func Load() (*Config, error) {
 		DataDir:          dataDir,
 		SessionDir:       sessionDir,
 		LogChannel:       logChannel,
-		CommandPrefix:    cmdPrefix,
+		CommandPrefixes:  cmdPrefixes,
+		ThrottleMessage:  throttleMessage,
 		OpenRouterAPIKey: os.Getenv("OPENROUTER_API_KEY"),
 	}
 	return configInstance, nil
@@ -2,12 +2,14 @@ package logger
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/celestix/gotgproto"
-	"github.com/celestix/gotgproto/parsemode"
 	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	markup "github.com/watzon/macron/styling"
 )
 
 // Logger represents our custom logging implementation
@@ -19,6 +21,10 @@ type Logger struct {
 	mu         sync.Mutex
 }
 
+// maxStackLength is how much of a stack trace is sent to the log channel, leaving room for the
+// error message within Telegram's message length limit
+const maxStackLength = 3500
+
 var (
 	instance *Logger
 	once     sync.Once
@@ -43,10 +49,45 @@ func Log(msg string, args ...interface{}) {
 		return
 	}
 
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+
+	// Messages that aren't valid MarkdownV2 are logged as they are rather than lost
+	texts, err := markup.ParseMarkdownV2(msg)
+	if err != nil {
+		texts = []styling.StyledTextOption{styling.Plain(msg)}
+	}
+
+	_, err = instance.sender.To(instance.logChannel).StyledText(instance.ctx, texts...)
+	if err != nil {
+		return
+	}
+}
+
+// ErrorWithStack logs an error message to the configured log channel followed by a stack
+// trace. The stack is sent as a pre-formatted block so it isn't mangled by markdown parsing.
+func ErrorWithStack(msg string, stack []byte) {
+	if instance == nil || instance.logChannel == nil {
+		return
+	}
+
+	texts := []styling.StyledTextOption{styling.Plain("❌ Error: " + msg)}
+	if len(stack) > 0 {
+		trace := string(stack)
+		if len(trace) > maxStackLength {
+			trace = trace[:maxStackLength] + "\n..."
+		}
+		texts = append(texts, styling.Plain("\n\n"), styling.Pre(trace, "go"))
+	}
+
 	instance.mu.Lock()
 	defer instance.mu.Unlock()
 
-	_, err := instance.sender.To(instance.logChannel).StyledText(instance.ctx, parsemode.StylizeText(msg)...)
+	_, err := instance.sender.To(instance.logChannel).StyledText(instance.ctx, texts...)
 	if err != nil {
 		return
 	}
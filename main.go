@@ -5,17 +5,23 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/ext"
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"github.com/watzon/macron/command"
 	"github.com/watzon/macron/config"
 	"github.com/watzon/macron/logger"
 	"github.com/watzon/macron/modules"
+	"github.com/watzon/macron/services"
+	"github.com/watzon/macron/storage"
 	"github.com/watzon/macron/utilities"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -33,16 +39,31 @@ func parseArgs() Args {
 	return args
 }
 
-func registerModules(cfg *config.Config) *command.Registry {
+// shutdownTimeout is how long modules get to stop their background work on shutdown
+const shutdownTimeout = 10 * time.Second
+
+func registerModules(cfg *config.Config, deps *command.Deps) *command.Registry {
 	// Set up command registry with config's command prefix
-	registry := command.NewRegistry(cfg.CommandPrefix)
+	registry := command.NewRegistry(cfg.CommandPrefixes[0])
+	if cfg.ThrottleMessage != nil {
+		registry.SetThrottleMessage(*cfg.ThrottleMessage)
+	}
 
 	registry.AddModule(modules.NewMiscModule())
 	registry.AddModule(modules.NewUserModule())
 	registry.AddModule(modules.NewExecModule())
-	registry.AddModule(modules.NewSystemModule())
+	registry.AddModule(modules.NewSystemModule(registry))
 	registry.AddModule(modules.NewLangModule())
 	registry.AddModule(modules.NewUtilitiesModule())
+	registry.AddModule(modules.NewSudoModule(registry))
+	registry.AddModule(modules.NewScheduleModule(registry))
+	registry.AddModule(modules.NewModulesModule(registry))
+	registry.AddModule(modules.NewHelpModule(registry))
+
+	// Hand modules their dependencies, letting them add commands depending on what's configured
+	if err := registry.Init(deps); err != nil {
+		deps.Logger.Fatal("Failed to initialize modules", zap.Error(err))
+	}
 
 	for _, module := range registry.GetModules() {
 		fmt.Printf("Registered module: %s\n", module.Name())
@@ -91,6 +112,9 @@ func main() {
 			Session:        sessionMaker.SqlSession(sqlite.Open(filepath.Join(cfg.SessionDir, "session.db"))),
 			Logger:         lg,
 			AutoFetchReply: true,
+			Middlewares: []telegram.Middleware{
+				utilities.FloodWait(lg, 3, time.Minute),
+			},
 			ErrorHandler: func(ctx *ext.Context, u *ext.Update, err string) error {
 				logger.Error(err)
 				return nil
@@ -113,8 +137,71 @@ func main() {
 		}
 	}
 
+	// Open the storage modules keep their state in
+	store, err := storage.New(client.PeerStorage.SqlSession)
+	if err != nil {
+		lg.Fatal("Failed to open storage", zap.Error(err))
+	}
+
+	// Set up the services shared by modules
+	svcs := &command.Services{}
+	if cfg.OpenRouterAPIKey != "" {
+		svcs.LLM = services.NewLLMService(cfg.OpenRouterAPIKey)
+	}
+
 	// Register modules
-	registry := registerModules(cfg)
+	registry := registerModules(cfg, &command.Deps{
+		Config:   cfg,
+		Client:   client,
+		Logger:   lg,
+		DB:       client.PeerStorage.SqlSession,
+		Store:    store,
+		Services: svcs,
+	})
+
+	// Load sudo users and grants from the session database
+	perms, err := command.NewPermissions(client.PeerStorage.SqlSession)
+	if err != nil {
+		lg.Fatal("Failed to load permissions", zap.Error(err))
+	}
+	registry.SetPermissions(perms)
+
+	// Load which modules and commands were disabled at runtime
+	toggles, err := command.NewToggles(filepath.Join(cfg.SessionDir, "toggles.json"))
+	if err != nil {
+		lg.Fatal("Failed to load toggles", zap.Error(err))
+	}
+	registry.SetToggles(toggles)
+
+	// Load the accepted prefixes along with per-chat overrides
+	prefixes, err := command.NewPrefixes(cfg.CommandPrefixes, filepath.Join(cfg.SessionDir, "prefixes.json"))
+	if err != nil {
+		lg.Fatal("Failed to load prefixes", zap.Error(err))
+	}
+	registry.SetPrefixes(prefixes)
+
+	// Load the aliases defined with the alias command
+	aliases, err := command.NewAliases(filepath.Join(cfg.SessionDir, "aliases.json"))
+	if err != nil {
+		lg.Fatal("Failed to load aliases", zap.Error(err))
+	}
+	registry.SetAliases(aliases)
+
+	// Record every command invocation for the stats and history commands
+	history, err := command.NewHistory(client.PeerStorage.SqlSession)
+	if err != nil {
+		lg.Fatal("Failed to open command history", zap.Error(err))
+	}
+	registry.SetHistory(history)
+
+	// Wrap every command with the framework middlewares. Panics are recovered innermost so
+	// they get logged, recorded and replied to like any other error.
+	registry.Use(
+		command.ErrorReply(),
+		command.Logging(lg),
+		command.Audit(history),
+		command.Recover(),
+	)
 
 	// Register all modules with the dispatcher
 	lg.Info("Registering modules...")
@@ -137,5 +224,33 @@ func main() {
 		}
 	}
 
-	client.Idle()
+	// Start the modules' background work, stopping it again on Ctrl+C or kill
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := registry.Start(ctx); err != nil {
+		lg.Fatal("Failed to start modules", zap.Error(err))
+	}
+	go store.Run(ctx, time.Hour)
+
+	idle := make(chan error, 1)
+	go func() { idle <- client.Idle() }()
+
+	select {
+	case <-ctx.Done():
+		fmt.Println("Shutting down...")
+	case err := <-idle:
+		if err != nil {
+			lg.Error("Client stopped", zap.Error(err))
+		}
+	}
+	stop()
+
+	// Modules are stopped while the client is still connected, so they can still send messages
+	lg.Info("Stopping modules...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := registry.Stop(shutdownCtx); err != nil {
+		lg.Error("Failed to stop modules", zap.Error(err))
+	}
+	client.Stop()
 }
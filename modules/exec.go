@@ -2,6 +2,8 @@ package modules
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -9,9 +11,7 @@ import (
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/ext"
-	"github.com/celestix/gotgproto/parsemode"
-	"github.com/gotd/td/telegram/uploader"
-	"github.com/gotd/td/tg"
+	"github.com/gotd/td/telegram/message/styling"
 	"github.com/traefik/yaegi/interp"
 	"github.com/traefik/yaegi/stdlib"
 	"github.com/watzon/macron/command"
@@ -38,14 +38,18 @@ func NewExecModule() *ExecModule {
 }
 
 // Load registers all module commands with the dispatcher
-func (m *ExecModule) Load(d dispatcher.Dispatcher, prefix string) {
-	m.BaseModule.Load(d, prefix)
+func (m *ExecModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
 }
 
+// execTimeout is how long code may run before the interpreter is stopped
+const execTimeout = 30 * time.Second
+
 var execGo = command.NewCommand("exec").
 	WithUsage("exec <code>").
 	WithAliases("eval").
 	WithDescription("Execute Go code using yaegi interpreter").
+	WithJob(true).
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "trunc",
@@ -53,7 +57,7 @@ var execGo = command.NewCommand("exec").
 			Kind:        command.KindNamed,
 			Required:    false,
 			Default:     false,
-			Description: "Truncate the output to the max message length of 4096 characters",
+			Description: "Truncate the output to fit in a single message instead of splitting it",
 		},
 		command.ArgumentDefinition{
 			Name:        "file",
@@ -67,9 +71,8 @@ var execGo = command.NewCommand("exec").
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		code := args.GetRestString()
 		if code == "" {
-			return fmt.Errorf("code argument is required")
+			return command.UserErrorf("code argument is required")
 		}
-		fmt.Println(code)
 
 		// Setup stdout and stderr capture
 		var stdout, stderr bytes.Buffer
@@ -80,24 +83,6 @@ var execGo = command.NewCommand("exec").
 			Stderr: &stderr,
 		})
 
-		// Setup timeout channel
-		timeoutChan := make(chan bool, 1)
-		done := make(chan bool, 1)
-
-		go func() {
-			select {
-			case <-time.After(30 * time.Second):
-				timeoutChan <- true
-			case <-done:
-				return
-			}
-		}()
-		defer close(done)
-
-		// Create error channel for the evaluation goroutine
-		errChan := make(chan error, 1)
-		resultChan := make(chan reflect.Value, 1)
-
 		stdlib.Symbols["macron/macron"] = map[string]reflect.Value{
 			"Context": reflect.ValueOf(ctx),
 			"Update":  reflect.ValueOf(u),
@@ -130,47 +115,22 @@ var execGo = command.NewCommand("exec").
 		// Split the code into lines and prepare for evaluation
 		lines := strings.Split(code, "\n")
 
-		// Start evaluation in a goroutine
-		go func() {
-			defer func() {
-				done <- true
-				if r := recover(); r != nil {
-					errChan <- fmt.Errorf("panic in evaluation: %v", r)
-				}
-			}()
-
-			// Evaluate all lines except the last one
-			for _, line := range lines[:len(lines)-1] {
-				if line == "" {
-					continue
-				}
-				if _, err := i.Eval(line); err != nil {
-					errChan <- err
-					return
-				}
-			}
+		if err := args.Progress(ctx, u, "Running..."); err != nil {
+			return err
+		}
 
-			// Evaluate the last line
-			if v, err := i.Eval(lines[len(lines)-1]); err != nil {
-				errChan <- err
-			} else {
-				resultChan <- v
-			}
-		}()
-
-		// Wait for either completion or timeout
-		var v reflect.Value
-		select {
-		case <-timeoutChan:
-			return fmt.Errorf("execution timed out after 30 seconds")
-		case err := <-errChan:
-			_, replyErr := ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Error: %v", err)), &ext.ReplyOpts{})
-			if replyErr != nil {
-				return fmt.Errorf("failed to send error message: %v", replyErr)
-			}
-			return nil
-		case result := <-resultChan:
-			v = result
+		// The interpreter is stopped when the job is cancelled or runs out of time
+		evalCtx, cancel := context.WithTimeout(ctx, execTimeout)
+		defer cancel()
+
+		v, err := evalLines(evalCtx, i, lines)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return command.UserErrorf("execution timed out after %s", execTimeout)
+		case errors.Is(err, context.Canceled):
+			return err
+		case err != nil:
+			return args.RespondText(ctx, u, fmt.Sprintf("Error: %v", err))
 		}
 
 		// Combine outputs
@@ -199,36 +159,49 @@ var execGo = command.NewCommand("exec").
 			output = "Code executed successfully with no output"
 		}
 
-		// If the message is too long and truncation is enabled, truncate it
-		if args.GetBool("trunc") && len(output) > 4087 {
-			output = output[:4087] + "..."
-		} else if args.GetBool("file") || len(output) > 4096 {
-			// Otherwise send the output as a file instead
-			f, err := uploader.NewUploader(ctx.Raw).FromBytes(ctx, "output.txt", []byte(output))
-			if err != nil {
-				return fmt.Errorf("failed to upload output result document: %v", err)
-			}
-			_, err = ctx.SendMedia(u.EffectiveChat().GetID(), &tg.MessagesSendMediaRequest{
-				Media: &tg.InputMediaUploadedDocument{
-					MimeType: "text/plain",
-					File:     f,
-					Attributes: []tg.DocumentAttributeClass{
-						&tg.DocumentAttributeFilename{
-							FileName: "output.txt",
-						},
-					},
-				},
-			})
-			if err != nil {
+		// Piped output is passed on as is, without truncating or formatting it
+		if args.Piped() {
+			return args.Output(ctx, u, output)
+		}
+
+		if args.GetBool("file") {
+			if err := args.Telegram().Upload(ctx, u, "output.txt", "text/plain", []byte(output)); err != nil {
 				return fmt.Errorf("failed to send result: %v", err)
 			}
 			return nil
 		}
 
-		msg := fmt.Sprintf("```\n%s\n```", output)
-		_, err := ctx.Reply(u, ext.ReplyTextStyledTextArray(parsemode.StylizeText(msg)), &ext.ReplyOpts{})
-		if err != nil {
+		// Output too long for a single message is split across several unless truncation is
+		// enabled, with the code block continuing in each
+		overflow := command.OverflowSplit
+		if args.GetBool("trunc") {
+			overflow = command.OverflowTruncate
+		}
+		if err := args.RespondLong(ctx, u, overflow, "output.txt", styling.Pre(output, "")); err != nil {
 			return fmt.Errorf("failed to send result: %v", err)
 		}
 		return nil
 	})
+
+// evalLines evaluates the lines of code one by one, returning the value of the last line. The
+// interpreter is stopped as soon as the context is done.
+func evalLines(ctx context.Context, i *interp.Interpreter, lines []string) (v reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in evaluation: %v", r)
+		}
+	}()
+
+	// Evaluate all lines except the last one
+	for _, line := range lines[:len(lines)-1] {
+		if line == "" {
+			continue
+		}
+		if _, err := i.EvalWithContext(ctx, line); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	// Evaluate the last line
+	return i.EvalWithContext(ctx, lines[len(lines)-1])
+}
@@ -0,0 +1,359 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/watzon/macron/command"
+)
+
+// maxListedChoices is the most choices listed for a choice argument before only their number
+// is shown
+const maxListedChoices = 10
+
+// HelpModule contains the auto-generated help command
+type HelpModule struct {
+	*command.BaseModule
+}
+
+// NewHelpModule creates a new help module which documents every command in the given registry
+func NewHelpModule(registry *command.Registry) *HelpModule {
+	m := &HelpModule{
+		BaseModule: command.NewBaseModule(
+			"help",
+			"Lists available modules and commands",
+		),
+	}
+
+	m.AddCommand(newHelpCommand(registry))
+
+	return m
+}
+
+// Load registers all module commands with the dispatcher
+func (m *HelpModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
+}
+
+func newHelpCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("help").
+		WithUsage("help [command] [subcommand...]").
+		WithDescription("Lists all modules and their commands, or shows detailed help for a single command").
+		WithAliases("h").
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "command",
+				Type:        command.TypeString,
+				Kind:        command.KindPositional,
+				Required:    false,
+				Description: "The command to show detailed help for",
+			},
+			command.ArgumentDefinition{
+				Name:        "file",
+				Type:        command.TypeBool,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Default:     false,
+				Description: "Force sending the help text as a file",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			page := &helpPage{}
+
+			if name := strings.TrimPrefix(args.GetPositionalString(0), args.Prefix); name != "" {
+				cmd, module := registry.FindCommand(name)
+				if cmd == nil {
+					if expansion, ok := registry.Aliases().Get(name); ok {
+						cmd, module = writeAliasHelp(page, registry, args.Prefix, name, expansion)
+						if cmd == nil {
+							return args.Respond(ctx, u, page.opts...)
+						}
+						page.Write(styling.Plain, "\n\n")
+					}
+				}
+				if cmd == nil || cmd.Hidden {
+					return command.UserErrorf("unknown command: %s", name)
+				}
+
+				// Descend into subcommands, e.g. "help botbot stop"
+				for _, subName := range strings.Fields(args.GetRestString()) {
+					sub := cmd.FindSubcommand(subName)
+					if sub == nil || sub.Hidden {
+						return command.UserErrorf("unknown subcommand: %s %s", cmd.FullName(), subName)
+					}
+					cmd = sub
+				}
+				writeCommandHelp(page, registry, args.Prefix, module, cmd)
+			} else {
+				writeModuleList(page, registry, args.Prefix)
+			}
+
+			if args.GetBool("file") {
+				return args.Telegram().Upload(ctx, u, "help.txt", "text/plain", []byte(page.String()))
+			}
+
+			// Fall back to a file upload when the help text doesn't fit in a single message
+			return args.RespondLong(ctx, u, command.OverflowFile, "help.txt", page.opts...)
+		})
+}
+
+// writeModuleList writes an overview of every module and its visible commands, shown with the
+// prefix help was invoked with
+func writeModuleList(page *helpPage, registry *command.Registry, prefix string) {
+	page.Write(styling.Bold, "📚 Available commands\n")
+
+	for _, module := range registry.GetModules() {
+		var visible []*command.Command
+		for _, cmd := range module.GetCommands() {
+			if !cmd.Hidden {
+				visible = append(visible, cmd)
+			}
+		}
+		triggers := module.GetTriggers()
+		if len(visible) == 0 && len(triggers) == 0 {
+			continue
+		}
+
+		page.Write(styling.Plain, "\n")
+		page.Write(styling.Bold, module.Name())
+		if !registry.Toggles().ModuleEnabled(module.Name()) {
+			page.Write(styling.Italic, " (disabled)")
+		}
+		if description := module.Description(); description != "" {
+			page.Write(styling.Plain, " — "+description)
+		}
+		page.Write(styling.Plain, "\n")
+
+		for i, cmd := range visible {
+			branch, stem := " ├─ ", " │  "
+			if i == len(visible)-1 && len(triggers) == 0 {
+				branch, stem = " └─ ", "    "
+			}
+			page.Write(styling.Plain, branch)
+			page.Write(styling.Code, commandPrefix(prefix, cmd)+cmd.Name)
+			if !registry.Toggles().CommandEnabled(module.Name(), cmd.FullName()) {
+				page.Write(styling.Italic, " (disabled)")
+			}
+			if cmd.Description != "" {
+				page.Write(styling.Plain, " — "+cmd.Description)
+			}
+			page.Write(styling.Plain, "\n")
+			writeSubcommandTree(page, cmd, stem)
+		}
+
+		// Triggers fire on any message matching their pattern rather than being invoked
+		for i, trigger := range triggers {
+			branch := " ├─ "
+			if i == len(triggers)-1 {
+				branch = " └─ "
+			}
+			page.Write(styling.Plain, branch+"⚡ ")
+			page.Write(styling.Code, trigger.Name)
+			if trigger.Description != "" {
+				page.Write(styling.Plain, " — "+trigger.Description)
+			}
+			page.Write(styling.Plain, "\n")
+		}
+	}
+
+	if aliases := registry.Aliases().List(); len(aliases) > 0 {
+		page.Write(styling.Plain, "\n")
+		page.Write(styling.Bold, "aliases")
+		page.Write(styling.Plain, " — Shortcuts defined with ")
+		page.Write(styling.Code, prefix+"alias")
+		page.Write(styling.Plain, "\n")
+		for i, alias := range aliases {
+			branch := " ├─ "
+			if i == len(aliases)-1 {
+				branch = " └─ "
+			}
+			page.Write(styling.Plain, branch)
+			page.Write(styling.Code, prefix+alias.Name)
+			page.Write(styling.Plain, " → "+alias.Expansion+"\n")
+		}
+	}
+
+	page.Write(styling.Plain, "\nUse ")
+	page.Write(styling.Code, prefix+"help <command>")
+	page.Write(styling.Plain, " for details about a command. Commands can be chained with ")
+	page.Write(styling.Code, "|")
+	page.Write(styling.Plain, " to feed one's output into the next, e.g. ")
+	page.Write(styling.Code, prefix+"echo hello | mock")
+	page.Write(styling.Plain, ".")
+}
+
+// writeAliasHelp writes what a user-defined alias expands into, returning the command it runs
+// so its help can follow. It returns nil if the alias expands into another alias or an unknown
+// command.
+func writeAliasHelp(page *helpPage, registry *command.Registry, prefix, name, expansion string) (*command.Command, command.Module) {
+	page.Write(styling.Plain, "🔗 ")
+	page.Write(styling.Code, prefix+name)
+	page.Write(styling.Plain, " is an alias for ")
+	page.Write(styling.Code, prefix+expansion)
+
+	target, _, _ := strings.Cut(expansion, " ")
+	return registry.FindCommand(target)
+}
+
+// writeCommandHelp writes the detailed help page for a single command, shown with the prefix
+// help was invoked with
+func writeCommandHelp(page *helpPage, registry *command.Registry, prefix string, module command.Module, cmd *command.Command) {
+	prefix = commandPrefix(prefix, cmd)
+
+	page.Write(styling.Plain, "📖 ")
+	page.Write(styling.Bold, prefix+cmd.FullName())
+	if registry.Enabled(cmd.Root(), module) {
+		page.Write(styling.Plain, fmt.Sprintf(" (%s)\n", module.Name()))
+	} else {
+		page.Write(styling.Plain, fmt.Sprintf(" (%s, disabled)\n", module.Name()))
+	}
+
+	if cmd.Description != "" {
+		page.Write(styling.Plain, cmd.Description+"\n")
+	}
+
+	page.Write(styling.Plain, "\n")
+	if cmd.Usage != "" {
+		page.Write(styling.Bold, "Usage: ")
+		page.Write(styling.Code, prefix+cmd.Usage)
+		page.Write(styling.Plain, "\n")
+	}
+
+	if len(cmd.Aliases) > 0 {
+		aliases := make([]string, len(cmd.Aliases))
+		for i, alias := range cmd.Aliases {
+			aliases[i] = prefix + alias
+		}
+		page.Write(styling.Bold, "Aliases: ")
+		page.Write(styling.Code, strings.Join(aliases, ", "))
+		page.Write(styling.Plain, "\n")
+	}
+
+	if hasVisibleSubcommands(cmd) {
+		page.Write(styling.Plain, "\n")
+		page.Write(styling.Bold, "Subcommands\n")
+		writeSubcommandTree(page, cmd, "")
+	}
+
+	if len(cmd.Arguments) == 0 {
+		return
+	}
+
+	page.Write(styling.Plain, "\n")
+	page.Write(styling.Bold, "Arguments\n")
+	for i, arg := range cmd.Arguments {
+		branch, stem := " ├─ ", " │  "
+		if i == len(cmd.Arguments)-1 {
+			branch, stem = " └─ ", "    "
+		}
+
+		page.Write(styling.Plain, branch)
+		page.Write(styling.Code, argumentLabel(arg))
+		page.Write(styling.Plain, " ("+strings.Join(argumentDetails(arg), ", ")+")\n")
+		if arg.Description != "" {
+			page.Write(styling.Plain, stem+"└─ "+arg.Description+"\n")
+		}
+	}
+}
+
+// writeSubcommandTree writes the visible subcommands of a command as a tree, recursing into
+// nested subcommands. Each line is indented with the given stem.
+func writeSubcommandTree(page *helpPage, cmd *command.Command, stem string) {
+	var visible []*command.Command
+	for _, sub := range cmd.Subcommands {
+		if !sub.Hidden {
+			visible = append(visible, sub)
+		}
+	}
+
+	for i, sub := range visible {
+		branch, next := " ├─ ", " │  "
+		if i == len(visible)-1 {
+			branch, next = " └─ ", "    "
+		}
+		page.Write(styling.Plain, stem+branch)
+		page.Write(styling.Code, sub.Name)
+		if sub.Description != "" {
+			page.Write(styling.Plain, " — "+sub.Description)
+		}
+		page.Write(styling.Plain, "\n")
+		writeSubcommandTree(page, sub, stem+next)
+	}
+}
+
+// hasVisibleSubcommands reports whether the command has any subcommands shown in help
+func hasVisibleSubcommands(cmd *command.Command) bool {
+	for _, sub := range cmd.Subcommands {
+		if !sub.Hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// argumentLabel returns how an argument is written on the command line
+func argumentLabel(arg command.ArgumentDefinition) string {
+	switch {
+	case arg.Type == command.TypeReply:
+		return "(reply)"
+	case arg.Kind == command.KindNamed:
+		return "-" + arg.Name
+	default:
+		return "<" + arg.Name + ">"
+	}
+}
+
+// argumentDetails returns the type, kind, requirement and default of an argument, along with
+// the allowed choices or element type where relevant
+func argumentDetails(arg command.ArgumentDefinition) []string {
+	details := []string{arg.Type.String(), arg.Kind.String()}
+	switch arg.Type {
+	case command.TypeChoice:
+		if len(arg.Choices) <= maxListedChoices {
+			details[0] = "one of " + strings.Join(arg.Choices, "|")
+		} else {
+			details[0] = fmt.Sprintf("one of %d choices", len(arg.Choices))
+		}
+	case command.TypeList:
+		details[0] = "list of " + arg.Elem.String()
+	}
+	if arg.Required {
+		details = append(details, "required")
+	} else {
+		details = append(details, "optional")
+	}
+	if arg.Default != nil {
+		details = append(details, fmt.Sprintf("default: %v", arg.Default))
+	}
+	return details
+}
+
+// commandPrefix returns the prefix that triggers the given command, which is its root
+// command's own prefix or otherwise the given chat prefix
+func commandPrefix(prefix string, cmd *command.Command) string {
+	if root := cmd.Root(); root.Prefix != "" {
+		return root.Prefix
+	}
+	return prefix
+}
+
+// helpPage accumulates styled help text alongside its plain text equivalent, which is uploaded
+// when the help is requested as a file
+type helpPage struct {
+	opts  []styling.StyledTextOption
+	plain strings.Builder
+}
+
+// Write appends text to the page using the given style
+func (p *helpPage) Write(style func(string) styling.StyledTextOption, text string) {
+	p.opts = append(p.opts, style(text))
+	p.plain.WriteString(text)
+}
+
+// String returns the page as plain text
+func (p *helpPage) String() string {
+	return p.plain.String()
+}
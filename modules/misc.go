@@ -2,9 +2,12 @@ package modules
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image/png"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,11 +20,14 @@ import (
 	messagefilters "github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/message"
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/ext"
-	"github.com/celestix/gotgproto/parsemode"
 	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
 	"github.com/watzon/macron/command"
+	"github.com/watzon/macron/storage"
+	markup "github.com/watzon/macron/styling"
 	"github.com/watzon/macron/utilities"
+	"go.uber.org/zap"
 )
 
 type botInstance struct {
@@ -29,14 +35,21 @@ type botInstance struct {
 	updater *gotgbotext.Updater
 }
 
-var (
-	activeBots = make(map[string]*botInstance)
-	botsLock   sync.RWMutex
-)
+// savedBot is a bot started with botbot, stored so it's started again after a restart
+type savedBot struct {
+	Token string `json:"token"`
+	Tail  bool   `json:"tail"`
+}
 
 // MiscModule contains miscellaneous utility commands
 type MiscModule struct {
 	*command.BaseModule
+
+	// bots holds the bots started with botbot, keyed by username
+	bots      map[string]*botInstance
+	botsLock  sync.RWMutex
+	savedBots *storage.Collection[savedBot]
+	logger    *zap.Logger
 }
 
 // NewMiscModule creates a new misc module
@@ -46,6 +59,7 @@ func NewMiscModule() *MiscModule {
 			"misc",
 			"Miscellaneous utility commands like ping, echo, mock, reverse, leet, vaporwave, and cowsay",
 		),
+		bots: make(map[string]*botInstance),
 	}
 
 	// Add commands to the module
@@ -56,39 +70,79 @@ func NewMiscModule() *MiscModule {
 	m.AddCommand(leet)
 	m.AddCommand(vaporwave)
 	m.AddCommand(cowsayCmd)
-	m.AddCommand(botbot)
+	m.AddCommand(newBotbotCommand(m))
 	m.AddCommand(screenshot)
 
 	return m
 }
 
 // Load registers all module commands with the dispatcher
-func (m *MiscModule) Load(d dispatcher.Dispatcher, prefix string) {
-	m.BaseModule.Load(d, prefix)
+func (m *MiscModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
+}
+
+// Init opens the module's storage
+func (m *MiscModule) Init(deps *command.Deps) error {
+	m.savedBots = storage.NewCollection[savedBot](deps.Namespace(m), "bots")
+	m.logger = deps.Logger
+	return nil
+}
+
+// Start starts the bots that were running when the userbot was last stopped
+func (m *MiscModule) Start(_ context.Context) error {
+	saved, err := m.savedBots.All()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range saved {
+		instance, err := startBot(doc.Doc.Token, doc.Doc.Tail)
+		if err != nil {
+			m.logger.Error("Failed to restart bot", zap.String("bot", doc.ID), zap.Error(err))
+			continue
+		}
+		m.botsLock.Lock()
+		m.bots[instance.bot.User.Username] = instance
+		m.botsLock.Unlock()
+	}
+	return nil
+}
+
+// Stop stops every bot started with botbot. They're kept in storage, so they're started again
+// along with the module.
+func (m *MiscModule) Stop(_ context.Context) error {
+	m.botsLock.Lock()
+	defer m.botsLock.Unlock()
+
+	var errs []error
+	for username, instance := range m.bots {
+		if err := instance.updater.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop bot @%s: %w", username, err))
+		}
+		delete(m.bots, username)
+	}
+	return errors.Join(errs...)
 }
 
 var ping = command.NewCommand("ping").
 	WithUsage("ping").
 	WithDescription("Responds with pong, optionally multiple times").
-	WithHandler(func(ctx *ext.Context, u *ext.Update, _ *command.Arguments) error {
+	WithCooldown(command.Limit{}, command.Burst(3, 5*time.Second), command.Limit{}).
+	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		start := time.Now()
-		msg, err := ctx.Reply(u, ext.ReplyTextString("pong"), &ext.ReplyOpts{})
+		msgID, err := args.Telegram().Reply(ctx, u, 0, styling.Plain("pong"))
 		if err != nil {
 			return err
 		}
 
 		rtt := time.Since(start)
-		_, err = ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
-			ID:      msg.ID,
-			Peer:    u.EffectiveChat().GetInputPeer(),
-			Message: fmt.Sprintf("Pong (%.2fms)", float64(rtt.Microseconds())/1000.0),
-		})
-		return err
+		return args.Telegram().Edit(ctx, u, msgID, styling.Plain(fmt.Sprintf("Pong (%.2fms)", float64(rtt.Microseconds())/1000.0)))
 	})
 
 var echo = command.NewCommand("echo").
 	WithUsage("echo [-repeat N] [-uppercase] <text>").
 	WithDescription("Echoes back text with optional modifications").
+	WithCooldown(command.Limit{}, command.Burst(3, 10*time.Second), command.Limit{}).
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "repeat",
@@ -107,6 +161,7 @@ var echo = command.NewCommand("echo").
 			Description: "Convert the text to uppercase",
 		},
 	).
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		// Get named arguments
 		repeat := args.GetInt("repeat")
@@ -130,6 +185,7 @@ var echo = command.NewCommand("echo").
 var mock = command.NewCommand("mock").
 	WithUsage("mock <text>").
 	WithDescription("Transforms text to look like the spongebob meme").
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		return handleTextCommand(ctx, u, args, func(text string) string {
 			var result strings.Builder
@@ -147,6 +203,7 @@ var mock = command.NewCommand("mock").
 var reverse = command.NewCommand("reverse").
 	WithUsage("reverse <text>").
 	WithDescription("Reverses the input text").
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		return handleTextCommand(ctx, u, args, func(text string) string {
 			var result strings.Builder
@@ -160,6 +217,7 @@ var reverse = command.NewCommand("reverse").
 var leet = command.NewCommand("leet").
 	WithUsage("leet <text>").
 	WithDescription("Converts text to leetspeak").
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		return handleTextCommand(ctx, u, args, func(text string) string {
 			leetMap := map[rune]string{
@@ -185,6 +243,7 @@ var leet = command.NewCommand("leet").
 var vaporwave = command.NewCommand("vaporwave").
 	WithUsage("vaporwave <text>").
 	WithDescription("Converts text to vaporwave aesthetic").
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		return handleTextCommand(ctx, u, args, func(text string) string {
 			var result strings.Builder
@@ -213,33 +272,37 @@ var cowsayCmd = command.NewCommand("cowsay").
 		},
 		command.ArgumentDefinition{
 			Name:        "cow",
-			Type:        command.TypeString,
+			Type:        command.TypeChoice,
 			Kind:        command.KindNamed,
 			Required:    false,
 			Default:     "cow",
+			Choices:     cowsay.Cows(),
 			Description: "The cow type to use",
 		},
 	).
+	WithSink(replaceTrigger).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		if args.GetBool("list") {
 			cows := cowsay.Cows()
 			msgBuilder := strings.Builder{}
 			msgBuilder.WriteString("🐮 *Available cows:*\n")
 			for i, cow := range cows {
-				msgBuilder.WriteString(fmt.Sprintf("`%s`", cow))
+				msgBuilder.WriteString(fmt.Sprintf("`%s`", markup.EscapeMarkdownV2Code(cow)))
 				if i < len(cows)-1 {
 					msgBuilder.WriteString(", ")
 				}
 			}
-			msg := parsemode.StylizeText(msgBuilder.String())
-			_, err := ctx.Reply(u, ext.ReplyTextStyledTextArray(msg), &ext.ReplyOpts{})
-			return err
+			msg, err := markup.ParseMarkdownV2(msgBuilder.String())
+			if err != nil {
+				return err
+			}
+			return args.Respond(ctx, u, msg...)
 		}
 
 		return handleTextCommand(ctx, u, args, func(text string) string {
 			cow, err := cowsay.Say(
 				cowsay.Phrase(text),
-				cowsay.Type(args.GetString("cow")),
+				cowsay.Type(args.GetChoice("cow")),
 			)
 			if err != nil {
 				return fmt.Sprintf("Error: %v", err)
@@ -248,110 +311,161 @@ var cowsayCmd = command.NewCommand("cowsay").
 		})
 	})
 
-var botbot = command.NewCommand("botbot").
-	WithUsage("botbot [-stop BOT_USERNAME] [-delete BOT_USERNAME] <bot_token>").
-	WithDescription("Spin up a new bot instance, or manage existing bots").
-	WithArguments(
-		command.ArgumentDefinition{
-			Name:        "stop",
-			Type:        command.TypeString,
-			Kind:        command.KindNamed,
-			Required:    false,
-			Description: "Stop a running bot by username",
-		},
-		command.ArgumentDefinition{
-			Name:        "tail",
-			Type:        command.TypeBool,
-			Kind:        command.KindNamed,
-			Required:    false,
-			Default:     false,
-			Description: "Tail the bot's logs, sending them to wherever the botbot command was run from",
-		},
-	).
-	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
-		// Handle stop flag
-		if stopBot := args.GetString("stop"); stopBot != "" {
-			botsLock.Lock()
-			defer botsLock.Unlock()
-
-			if instance, exists := activeBots[stopBot]; exists {
-				instance.updater.Stop()
-				delete(activeBots, stopBot)
-				_, err := ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Bot @%s has been stopped", stopBot)), nil)
+func newBotbotCommand(m *MiscModule) *command.Command {
+	return command.NewCommand("botbot").
+		WithUsage("botbot <start|stop|list>").
+		WithDescription("Spin up a new bot instance, or manage existing bots").
+		WithSubcommands(newBotbotStartCommand(m), newBotbotStopCommand(m), newBotbotListCommand(m))
+}
+
+func newBotbotStartCommand(m *MiscModule) *command.Command {
+	return command.NewCommand("start").
+		WithUsage("botbot start [-tail] <bot_token>").
+		WithDescription("Starts a new bot instance with the given token").
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "tail",
+				Type:        command.TypeBool,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Default:     false,
+				Description: "Tail the bot's logs, sending them to wherever the botbot command was run from",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			token := args.GetRestString()
+			if token == "" {
+				return command.UserErrorf("please provide a bot token")
+			}
+
+			instance, err := startBot(token, args.GetBool("tail"))
+			if err != nil {
+				return args.RespondText(ctx, u, fmt.Sprintf("Couldn't start bot: %v", err))
+			}
+			username := instance.bot.User.Username
+
+			// Store both bot and updater in active bots map
+			m.botsLock.Lock()
+			m.bots[username] = instance
+			m.botsLock.Unlock()
+
+			if err := m.savedBots.Put(username, savedBot{Token: token, Tail: args.GetBool("tail")}); err != nil {
 				return err
 			}
-			_, err := ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Bot @%s not found", stopBot)), nil)
-			return err
-		}
 
-		// Handle new bot creation
-		token := args.GetRestString()
-		if token == "" {
-			_, err := ctx.Reply(u, ext.ReplyTextString("Please provide a bot token"), nil)
+			err = args.RespondText(ctx, u, fmt.Sprintf("Bot @%s is now running!", username))
 			return err
-		}
+		})
+}
 
-		// Create new bot instance with BotOpts
-		bot, err := gotgbot.NewBot(token, &gotgbot.BotOpts{})
-		if err != nil {
-			_, err := ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Failed to create bot: %v", err)), nil)
-			return err
-		}
+// startBot starts polling updates for the bot with the given token. With tail set, incoming
+// messages are logged.
+func startBot(token string, tail bool) (*botInstance, error) {
+	// Create new bot instance with BotOpts
+	bot, err := gotgbot.NewBot(token, &gotgbot.BotOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot: %w", err)
+	}
 
-		// Create dispatcher first
-		dispatcher := gotgbotext.NewDispatcher(&gotgbotext.DispatcherOpts{
-			// Error handler
-			Error: func(b *gotgbot.Bot, ctx *gotgbotext.Context, err error) gotgbotext.DispatcherAction {
-				fmt.Printf("Error handling update: %v\n", err)
-				return gotgbotext.DispatcherActionNoop
-			},
-		})
+	// Create dispatcher first
+	dispatcher := gotgbotext.NewDispatcher(&gotgbotext.DispatcherOpts{
+		// Error handler
+		Error: func(b *gotgbot.Bot, ctx *gotgbotext.Context, err error) gotgbotext.DispatcherAction {
+			fmt.Printf("Error handling update: %v\n", err)
+			return gotgbotext.DispatcherActionNoop
+		},
+	})
 
-		// Add /help command handler to dispatcher
-		dispatcher.AddHandler(handlers.NewCommand("help", func(b *gotgbot.Bot, ctx *gotgbotext.Context) error {
-			_, err := ctx.EffectiveMessage.Reply(b, "Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", &gotgbot.SendMessageOpts{})
-			return err
+	// Add /help command handler to dispatcher
+	dispatcher.AddHandler(handlers.NewCommand("help", func(b *gotgbot.Bot, ctx *gotgbotext.Context) error {
+		_, err := ctx.EffectiveMessage.Reply(b, "Lorem ipsum dolor sit amet, consectetur adipiscing elit. Sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", &gotgbot.SendMessageOpts{})
+		return err
+	}))
+
+	// Check if tail flag is set. If so log all incoming updates to the EffectiveChat
+	if tail {
+		dispatcher.AddHandler(handlers.NewMessage(messagefilters.All, func(b *gotgbot.Bot, ctx *gotgbotext.Context) error {
+			fmt.Printf("Received update: %+v\n", ctx.EffectiveMessage)
+			return nil
 		}))
+	}
 
-		// Check if tail flag is set. If so log all incoming updates to the EffectiveChat
-		if args.GetBool("tail") {
-			dispatcher.AddHandler(handlers.NewMessage(messagefilters.All, func(b *gotgbot.Bot, ctx *gotgbotext.Context) error {
-				fmt.Printf("Received update: %+v\n", ctx.EffectiveMessage)
-				return nil
-			}))
-		}
+	// Create updater with dispatcher
+	updater := gotgbotext.NewUpdater(dispatcher, &gotgbotext.UpdaterOpts{
+		ErrorLog: nil,
+		UnhandledErrFunc: func(err error) {
+			fmt.Printf("Unhandled error: %v\n", err)
+		},
+	})
+
+	// Start receiving updates
+	err = updater.StartPolling(bot, &gotgbotext.PollingOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start polling: %w", err)
+	}
 
-		// Create updater with dispatcher
-		updater := gotgbotext.NewUpdater(dispatcher, &gotgbotext.UpdaterOpts{
-			ErrorLog: nil,
-			UnhandledErrFunc: func(err error) {
-				fmt.Printf("Unhandled error: %v\n", err)
+	return &botInstance{bot: bot, updater: updater}, nil
+}
+
+func newBotbotStopCommand(m *MiscModule) *command.Command {
+	return command.NewCommand("stop").
+		WithUsage("botbot stop <bot_username>").
+		WithDescription("Stops a running bot").
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "bot",
+				Type:        command.TypeString,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "Username of the bot to stop",
 			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			username := strings.TrimPrefix(args.GetPositionalString(0), "@")
+
+			m.botsLock.Lock()
+			defer m.botsLock.Unlock()
+
+			instance, exists := m.bots[username]
+			if !exists {
+				return command.UserErrorf("bot @%s not found", username)
+			}
+			instance.updater.Stop()
+			delete(m.bots, username)
+			if _, err := m.savedBots.Delete(username); err != nil {
+				return err
+			}
+
+			return args.RespondText(ctx, u, fmt.Sprintf("Bot @%s has been stopped", username))
 		})
+}
 
-		// Start receiving updates
-		err = updater.StartPolling(bot, &gotgbotext.PollingOpts{})
-		if err != nil {
-			_, err := ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Failed to start bot: %v", err)), nil)
-			return err
-		}
+func newBotbotListCommand(m *MiscModule) *command.Command {
+	return command.NewCommand("list").
+		WithUsage("botbot list").
+		WithDescription("Lists running bots").
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			m.botsLock.RLock()
+			usernames := make([]string, 0, len(m.bots))
+			for username := range m.bots {
+				usernames = append(usernames, "@"+username)
+			}
+			m.botsLock.RUnlock()
 
-		// Store both bot and updater in active bots map
-		botsLock.Lock()
-		activeBots[bot.User.Username] = &botInstance{
-			bot:     bot,
-			updater: updater,
-		}
-		botsLock.Unlock()
+			if len(usernames) == 0 {
+				return args.RespondText(ctx, u, "No bots are running")
+			}
 
-		_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("Bot @%s is now running!", bot.User.Username)), nil)
-		return err
-	})
+			sort.Strings(usernames)
+			return args.RespondText(ctx, u, "Running bots: "+strings.Join(usernames, ", "))
+		})
+}
 
 var screenshot = command.NewCommand("screenshot").
 	WithUsage("screenshot [-count N]").
 	WithDescription("Creates a fake screenshot of messages. If -count is provided, includes N messages before the replied message. Otherwise only shows the replied message.").
 	WithAliases("sc").
+	WithCooldown(command.Every(10*time.Second), command.Limit{}, command.Burst(5, time.Minute)).
 	WithOutgoing(true).
 	WithIncoming(false).
 	WithArguments(
@@ -366,8 +480,7 @@ var screenshot = command.NewCommand("screenshot").
 	).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		if u.EffectiveMessage.ReplyToMessage == nil {
-			_, err := ctx.Reply(u, ext.ReplyTextString("Please reply to a message to create a screenshot"), &ext.ReplyOpts{})
-			return err
+			return command.UserErrorf("please reply to a message to create a screenshot")
 		}
 
 		count := args.GetInt("count")
@@ -484,66 +597,34 @@ var screenshot = command.NewCommand("screenshot").
 			return fmt.Errorf("failed to encode image: %v", err)
 		}
 
-		// Create a random ID for the file
-		fileID := rand.Int63()
-
-		// Upload the file data first
-		uploaded, err := ctx.Raw.UploadSaveFilePart(ctx.Context, &tg.UploadSaveFilePartRequest{
-			FileID:   fileID,
-			FilePart: 0,
-			Bytes:    buf.Bytes(),
-		})
-		if err != nil {
-			return fmt.Errorf("failed to upload file: %w", err)
-		}
-		if !uploaded {
-			return fmt.Errorf("failed to upload file: server returned false")
-		}
-
-		// Send the image
-		_, err = ctx.SendMedia(u.EffectiveChat().GetID(), &tg.MessagesSendMediaRequest{
-			Media: &tg.InputMediaUploadedPhoto{
-				File: &tg.InputFile{
-					ID:    fileID,
-					Name:  "screenshot.png",
-					Parts: 1,
-				},
-			},
-			ReplyTo: &tg.InputReplyToMessage{
-				ReplyToMsgID: u.EffectiveMessage.ID,
-			},
-		})
-		return err
+		// Images are sent as photos
+		return args.Telegram().Upload(ctx, u, "screenshot.png", "image/png", buf.Bytes())
 	})
 
 func handleTextCommand(ctx *ext.Context, u *ext.Update, args *command.Arguments, transform func(string) string) error {
 	text := args.GetRestString()
 	if text == "" {
 		if u.EffectiveMessage.ReplyToMessage == nil || u.EffectiveMessage.ReplyToMessage.Message == nil {
-			return fmt.Errorf("text argument is required or reply to a message")
+			return command.UserErrorf("text argument is required or reply to a message")
 		}
 		text = u.EffectiveMessage.ReplyToMessage.Text
 	}
 
-	transformedText := transform(text)
+	return args.Output(ctx, u, transform(text))
+}
 
+// replaceTrigger is the sink of the text commands. It replies to the replied-to message and
+// deletes the command message, or otherwise edits the command message in place.
+func replaceTrigger(ctx *ext.Context, u *ext.Update, args *command.Arguments, text string) error {
 	if u.EffectiveMessage.ReplyToMessage != nil {
 		// Reply to the original message and delete the command message
-		_, err := ctx.Reply(u, ext.ReplyTextString(transformedText), &ext.ReplyOpts{
-			ReplyToMessageId: u.EffectiveMessage.ReplyToMessage.ID,
-		})
+		_, err := args.Telegram().Reply(ctx, u, u.EffectiveMessage.ReplyToMessage.ID, styling.Plain(text))
 		if err != nil {
 			return err
 		}
-		err = ctx.DeleteMessages(u.EffectiveChat().GetID(), []int{u.EffectiveMessage.ID})
-		return err
-	} else {
-		// Edit the current message
-		_, err := ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
-			ID:      u.EffectiveMessage.ID,
-			Peer:    u.EffectiveChat().GetInputPeer(),
-			Message: transformedText,
-		})
-		return err
+		return args.Telegram().Delete(ctx, u, u.EffectiveMessage.ID)
 	}
+
+	// Edit the current message
+	return command.EditSink(ctx, u, args, text)
 }
@@ -0,0 +1,221 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/watzon/macron/command"
+)
+
+// ModulesModule contains commands for enabling and disabling modules and commands at runtime
+type ModulesModule struct {
+	*command.BaseModule
+}
+
+// NewModulesModule creates a new modules module toggling the given registry's modules and
+// commands
+func NewModulesModule(registry *command.Registry) *ModulesModule {
+	m := &ModulesModule{
+		BaseModule: command.NewBaseModule(
+			"modules",
+			"Enables and disables modules and commands at runtime",
+		),
+	}
+
+	m.AddCommand(newModuleCommand(registry))
+	m.AddCommand(newCmdCommand(registry))
+
+	return m
+}
+
+// Load registers all module commands with the dispatcher
+func (m *ModulesModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
+}
+
+func newModuleCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("module").
+		WithUsage("module <enable|disable|list>").
+		WithDescription("Enables or disables whole modules").
+		WithRole(command.RoleOwner).
+		WithSubcommands(
+			newModuleToggleCommand(registry, true),
+			newModuleToggleCommand(registry, false),
+			command.NewCommand("list").
+				WithUsage("module list").
+				WithDescription("Lists every module and whether it's enabled").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					toggles := registry.Toggles()
+
+					var b strings.Builder
+					b.WriteString("🧩 Modules\n")
+					modules := registry.GetModules()
+					for i, module := range modules {
+						branch := " ├─ "
+						if i == len(modules)-1 {
+							branch = " └─ "
+						}
+						state := "✅"
+						if !toggles.ModuleEnabled(module.Name()) {
+							state = "⛔"
+						}
+						b.WriteString(fmt.Sprintf("%s%s %s\n", branch, state, module.Name()))
+					}
+
+					return args.RespondText(ctx, u, b.String())
+				}),
+		)
+}
+
+// newModuleToggleCommand creates the subcommand which enables or disables a module
+func newModuleToggleCommand(registry *command.Registry, enable bool) *command.Command {
+	action, description := toggleAction(enable)
+
+	return command.NewCommand(action).
+		WithUsage("module " + action + " <name>").
+		WithDescription(description + "s a module and all of its commands").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "name",
+				Type:        command.TypeString,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "Name of the module",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			toggles := registry.Toggles()
+			if toggles == nil {
+				return fmt.Errorf("toggles are not configured")
+			}
+
+			name := args.GetPositionalString(0)
+			module := findModule(registry, name)
+			if module == nil {
+				var names []string
+				for _, module := range registry.GetModules() {
+					names = append(names, module.Name())
+				}
+				if suggestions := command.Suggest(name, names, 1); len(suggestions) > 0 {
+					return command.UserErrorf("unknown module %q, did you mean %s?", name, suggestions[0])
+				}
+				return command.UserErrorf("unknown module: %s", name)
+			}
+			if _, self := registry.FindCommand(args.Command.Parent.Name); !enable && module == self {
+				return command.UserErrorf("the %s module can't be disabled, it would lock you out", module.Name())
+			}
+
+			changed, err := toggles.SetModule(module.Name(), enable)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				return command.UserErrorf("the %s module is already %sd", module.Name(), action)
+			}
+			return args.RespondText(ctx, u, fmt.Sprintf("✅ %sd the %s module", description, module.Name()))
+		})
+}
+
+func newCmdCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("cmd").
+		WithUsage("cmd <enable|disable|list>").
+		WithDescription("Enables or disables individual commands").
+		WithRole(command.RoleOwner).
+		WithSubcommands(
+			newCmdToggleCommand(registry, true),
+			newCmdToggleCommand(registry, false),
+			command.NewCommand("list").
+				WithUsage("cmd list").
+				WithDescription("Lists every disabled command").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					toggles := registry.Toggles()
+					if toggles == nil {
+						return fmt.Errorf("toggles are not configured")
+					}
+
+					disabled := toggles.DisabledCommands()
+					if len(disabled) == 0 {
+						return args.RespondText(ctx, u, "No commands are disabled")
+					}
+
+					var b strings.Builder
+					b.WriteString("⛔ Disabled commands\n")
+					for i, name := range disabled {
+						branch := " ├─ "
+						if i == len(disabled)-1 {
+							branch = " └─ "
+						}
+						b.WriteString(branch + name + "\n")
+					}
+
+					return args.RespondText(ctx, u, b.String())
+				}),
+		)
+}
+
+// newCmdToggleCommand creates the subcommand which enables or disables a single command
+func newCmdToggleCommand(registry *command.Registry, enable bool) *command.Command {
+	action, description := toggleAction(enable)
+
+	return command.NewCommand(action).
+		WithUsage("cmd " + action + " <[module/]name> [subcommand]").
+		WithDescription(description + "s a single command or subcommand, including its aliases").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "name",
+				Type:        command.TypeString,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "Name or alias of the command, prefixed with its module when more than one module has it",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			toggles := registry.Toggles()
+			if toggles == nil {
+				return fmt.Errorf("toggles are not configured")
+			}
+
+			name := strings.TrimPrefix(args.GetPositionalString(0), args.Prefix)
+			cmd, module, err := registry.LookupCommand(name + " " + args.GetRestString())
+			if err != nil {
+				return err
+			}
+			if _, self := registry.FindCommand(args.Command.Parent.Name); !enable && module == self {
+				return command.UserErrorf("the %s command can't be disabled, it would lock you out", cmd.Name)
+			}
+
+			changed, err := toggles.SetCommand(module.Name(), cmd.FullName(), enable)
+			if err != nil {
+				return err
+			}
+			key := command.CommandKey(module.Name(), cmd.FullName())
+			if !changed {
+				return command.UserErrorf("%s is already %sd", key, action)
+			}
+			return args.RespondText(ctx, u, fmt.Sprintf("✅ %sd %s (%s%s)", description, key, args.Prefix, cmd.FullName()))
+		})
+}
+
+// toggleAction returns the subcommand name and capitalized verb for enabling or disabling
+func toggleAction(enable bool) (string, string) {
+	if enable {
+		return "enable", "Enable"
+	}
+	return "disable", "Disable"
+}
+
+// findModule returns the registered module with the given name, or nil if there is none
+func findModule(registry *command.Registry, name string) command.Module {
+	for _, module := range registry.GetModules() {
+		if module.Name() == name {
+			return module
+		}
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	gotgstorage "github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"github.com/watzon/macron/command"
+	"github.com/watzon/macron/logger"
+	"github.com/watzon/macron/scheduler"
+)
+
+// ScheduleModule contains commands that run other commands later
+type ScheduleModule struct {
+	*command.BaseModule
+	registry  *command.Registry
+	client    *gotgproto.Client
+	scheduler *scheduler.Scheduler
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewScheduleModule creates a new schedule module running the given registry's commands
+func NewScheduleModule(registry *command.Registry) *ScheduleModule {
+	m := &ScheduleModule{
+		BaseModule: command.NewBaseModule(
+			"schedule",
+			"Runs commands at a given time, after a delay or repeatedly",
+		),
+		registry: registry,
+	}
+
+	m.AddCommand(newAtCommand(m))
+	m.AddCommand(newInCommand(m))
+	m.AddCommand(newEveryCommand(m))
+	m.AddCommand(newSchedulesCommand(m))
+	m.AddCommand(newUnscheduleCommand(m))
+
+	return m
+}
+
+// Load registers all module commands with the dispatcher
+func (m *ScheduleModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
+}
+
+// Init sets up the scheduler, keeping the scheduled jobs in the module's storage
+func (m *ScheduleModule) Init(deps *command.Deps) error {
+	m.client = deps.Client
+	m.scheduler = scheduler.New(deps.Namespace(m), m.run)
+	return nil
+}
+
+// Start runs the scheduler in the background, catching up on the jobs missed while offline
+func (m *ScheduleModule) Start(ctx context.Context) error {
+	ctx, m.stop = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		_ = m.scheduler.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop stops the scheduler once the running jobs return. The jobs stay scheduled.
+func (m *ScheduleModule) Stop(ctx context.Context) error {
+	if m.stop == nil {
+		return nil
+	}
+	m.stop()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run runs a due job by sending a message in its chat and invoking the command in response to
+// it, as if the message was the command
+func (m *ScheduleModule) run(_ context.Context, job scheduler.Job) {
+	ctx := m.client.CreateContext()
+	u, err := scheduledUpdate(ctx, job)
+	if err != nil {
+		logger.Error("Failed to run scheduled job #%d (%s): %v", job.ID, job.Text, err)
+		return
+	}
+	if err := m.registry.Invoke(ctx, u, job.Text); err != nil {
+		logger.Error("Scheduled job #%d (%s) failed: %v", job.ID, job.Text, err)
+	}
+}
+
+// scheduledUpdate sends the message a job's command responds to and builds the update the
+// dispatcher would have handed to handlers for it
+func scheduledUpdate(ctx *ext.Context, job scheduler.Job) (*ext.Update, error) {
+	peer := ctx.PeerStorage.GetPeerById(job.ChatID)
+	if peer.ID == 0 {
+		return nil, fmt.Errorf("chat %d isn't known", job.ChatID)
+	}
+
+	entities := &tg.Entities{
+		Users:    map[int64]*tg.User{ctx.Self.ID: ctx.Self},
+		Chats:    map[int64]*tg.Chat{},
+		Channels: map[int64]*tg.Channel{},
+	}
+	var peerID tg.PeerClass
+	switch gotgstorage.EntityType(peer.Type) {
+	case gotgstorage.TypeUser:
+		if peer.ID != ctx.Self.ID {
+			entities.Users[peer.ID] = &tg.User{ID: peer.ID, AccessHash: peer.AccessHash, Username: peer.Username}
+		}
+		peerID = &tg.PeerUser{UserID: peer.ID}
+	case gotgstorage.TypeChat:
+		entities.Chats[peer.ID] = &tg.Chat{ID: peer.ID}
+		peerID = &tg.PeerChat{ChatID: peer.ID}
+	case gotgstorage.TypeChannel:
+		entities.Channels[peer.ID] = &tg.Channel{ID: peer.ID, AccessHash: peer.AccessHash, Username: peer.Username}
+		peerID = &tg.PeerChannel{ChannelID: peer.ID}
+	default:
+		return nil, fmt.Errorf("chat %d has an unknown type", job.ChatID)
+	}
+
+	msg, err := ctx.SendMessage(job.ChatID, &tg.MessagesSendMessageRequest{Message: "⏰ " + job.Text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+
+	// Messages sent in private chats come back without their peer
+	msg.PeerID = peerID
+	msg.FromID = &tg.PeerUser{UserID: ctx.Self.ID}
+	msg.Out = true
+
+	return &ext.Update{
+		EffectiveMessage: msg,
+		UpdateClass:      &tg.UpdateNewMessage{Message: msg.Message},
+		Entities:         entities,
+	}, nil
+}
+
+// schedule adds a job for the command in the rest of the arguments, replacing the job scheduled
+// by the message before it was edited
+func (m *ScheduleModule) schedule(ctx *ext.Context, u *ext.Update, args *command.Arguments, job scheduler.Job) error {
+	text := args.GetRestString()
+	if text == "" {
+		return command.UserErrorf("please provide the command to run")
+	}
+
+	// The prefix may be left out of the scheduled command
+	chatID := u.EffectiveChat().GetID()
+	if m.registry.CheckInvocation(text, chatID) != nil {
+		if err := m.registry.CheckInvocation(args.Prefix+text, chatID); err == nil {
+			text = args.Prefix + text
+		}
+	}
+	if err := m.registry.CheckInvocation(text, chatID); err != nil {
+		return err
+	}
+
+	if policy := args.GetString("catchup"); policy != "" {
+		job.CatchUp = scheduler.Policy(policy)
+	}
+	job.ChatID = chatID
+	job.TriggerID = u.EffectiveMessage.ID
+	job.Text = text
+
+	if args.Edited {
+		if err := m.unscheduleTrigger(chatID, job.TriggerID); err != nil {
+			return err
+		}
+	}
+
+	job, err := m.scheduler.Add(job)
+	if err != nil {
+		return err
+	}
+
+	response := fmt.Sprintf("⏰ Scheduled #%d: %s %s", job.ID, job.Text, formatNextRun(job.Next))
+	if job.Repeating() {
+		response += ", then every " + job.Every.String()
+	}
+	return args.RespondText(ctx, u, response)
+}
+
+// unscheduleTrigger cancels the jobs scheduled by the given message
+func (m *ScheduleModule) unscheduleTrigger(chatID int64, triggerID int) error {
+	jobs, err := m.scheduler.List()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.ChatID == chatID && job.TriggerID == triggerID {
+			if _, err := m.scheduler.Cancel(job.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catchUpArgument is the flag choosing what happens to runs missed while offline
+var catchUpArgument = command.ArgumentDefinition{
+	Name:        "catchup",
+	Type:        command.TypeChoice,
+	Kind:        command.KindNamed,
+	Required:    false,
+	Choices:     scheduler.Policies,
+	Description: "What to do about runs missed while offline: run once (default), run all of them or skip them",
+}
+
+func newAtCommand(m *ScheduleModule) *command.Command {
+	return command.NewCommand("at").
+		WithUsage("at <time> [-catchup once|all|skip] <command>").
+		WithDescription("Runs a command in the current chat the next time it's the given time of day").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "time",
+				Type:        command.TypeTime,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "Time of day like 18:00 or 6pm",
+			},
+			catchUpArgument,
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			at, _ := args.GetPositional(0).(command.TimeOfDay)
+			return m.schedule(ctx, u, args, scheduler.Job{Next: at.Next(time.Now())})
+		})
+}
+
+func newInCommand(m *ScheduleModule) *command.Command {
+	return command.NewCommand("in").
+		WithUsage("in <duration> [-catchup once|all|skip] <command>").
+		WithDescription("Runs a command in the current chat after a delay").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "delay",
+				Type:        command.TypeDuration,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "How long to wait, like 2h or 1d12h",
+			},
+			catchUpArgument,
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			now := time.Now()
+			next := args.GetPositionalDuration(0).Add(now)
+			if !next.After(now) {
+				return command.UserErrorf("the delay must be positive")
+			}
+			return m.schedule(ctx, u, args, scheduler.Job{Next: next})
+		})
+}
+
+func newEveryCommand(m *ScheduleModule) *command.Command {
+	return command.NewCommand("every").
+		WithUsage("every <interval> [-at time] [-catchup once|all|skip] <command>").
+		WithDescription("Runs a command in the current chat repeatedly, starting after one interval or at the given time of day").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "interval",
+				Type:        command.TypeDuration,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "Time between runs, like 1d or 30m",
+			},
+			command.ArgumentDefinition{
+				Name:        "at",
+				Type:        command.TypeTime,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Description: "Time of day of the first run",
+			},
+			catchUpArgument,
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			now := time.Now()
+			every := args.GetPositionalDuration(0)
+			next := every.Add(now)
+			if next.Sub(now) < scheduler.MinInterval {
+				return command.UserErrorf("the interval must be at least %s", scheduler.MinInterval)
+			}
+			if at, ok := args.Get("at").(command.TimeOfDay); ok {
+				next = at.Next(now)
+			}
+			return m.schedule(ctx, u, args, scheduler.Job{Next: next, Every: every})
+		})
+}
+
+func newSchedulesCommand(m *ScheduleModule) *command.Command {
+	return command.NewCommand("schedules").
+		WithUsage("schedules [-all]").
+		WithDescription("Lists the commands scheduled in the current chat, the next to run first").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "all",
+				Type:        command.TypeBool,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Default:     false,
+				Description: "List the commands scheduled in every chat",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			jobs, err := m.scheduler.List()
+			if err != nil {
+				return err
+			}
+
+			all := args.GetBool("all")
+			if !all {
+				chatID := u.EffectiveChat().GetID()
+				var inChat []scheduler.Job
+				for _, job := range jobs {
+					if job.ChatID == chatID {
+						inChat = append(inChat, job)
+					}
+				}
+				jobs = inChat
+			}
+			if len(jobs) == 0 {
+				return args.RespondText(ctx, u, "No commands are scheduled")
+			}
+
+			var b strings.Builder
+			b.WriteString("⏰ Scheduled commands\n")
+			for i, job := range jobs {
+				branch := " ├─ "
+				if i == len(jobs)-1 {
+					branch = " └─ "
+				}
+				b.WriteString(fmt.Sprintf("%s#%d %s %s", branch, job.ID, job.Text, formatNextRun(job.Next)))
+				if job.Repeating() {
+					b.WriteString(", every " + job.Every.String())
+				}
+				if job.CatchUp != scheduler.CatchUpOnce {
+					b.WriteString(", catch-up " + string(job.CatchUp))
+				}
+				if all {
+					b.WriteString(fmt.Sprintf(", in chat %d", job.ChatID))
+				}
+				b.WriteString("\n")
+			}
+
+			return args.RespondText(ctx, u, b.String())
+		})
+}
+
+func newUnscheduleCommand(m *ScheduleModule) *command.Command {
+	return command.NewCommand("unschedule").
+		WithUsage("unschedule <id>").
+		WithDescription("Cancels a scheduled command by its ID").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "id",
+				Type:        command.TypeInt,
+				Kind:        command.KindPositional,
+				Required:    true,
+				Description: "ID of the scheduled command, as listed by the schedules command",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			id := args.GetPositionalInt(0)
+			job, ok, err := m.scheduler.Get(id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return command.UserErrorf("nothing is scheduled as #%d", id)
+			}
+
+			if _, err := m.scheduler.Cancel(id); err != nil {
+				return err
+			}
+			return args.RespondText(ctx, u, fmt.Sprintf("✅ Unscheduled #%d (%s)", job.ID, job.Text))
+		})
+}
+
+// formatNextRun describes when a job runs next, like "at Mar 1 18:00 (in 3h0m0s)"
+func formatNextRun(next time.Time) string {
+	return fmt.Sprintf("at %s (in %s)", next.Format("Jan 2 15:04"), time.Until(next).Round(time.Second))
+}
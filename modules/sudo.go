@@ -0,0 +1,199 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+	"github.com/watzon/macron/command"
+	"github.com/watzon/macron/utilities"
+)
+
+// SudoModule contains commands for managing who may invoke incoming commands
+type SudoModule struct {
+	*command.BaseModule
+}
+
+// NewSudoModule creates a new sudo module managing the given registry's permissions
+func NewSudoModule(registry *command.Registry) *SudoModule {
+	m := &SudoModule{
+		BaseModule: command.NewBaseModule(
+			"sudo",
+			"Manages sudo users, per-chat allowlists and per-module grants",
+		),
+	}
+
+	m.AddCommand(newSudoCommand(registry))
+
+	return m
+}
+
+// Load registers all module commands with the dispatcher
+func (m *SudoModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
+}
+
+func newSudoCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("sudo").
+		WithUsage("sudo <add|remove|list>").
+		WithDescription("Grants or revokes access to incoming commands").
+		WithRole(command.RoleOwner).
+		WithSubcommands(
+			newSudoGrantCommand(registry, "add"),
+			newSudoGrantCommand(registry, "remove"),
+			newSudoListCommand(registry),
+		)
+}
+
+// newSudoGrantCommand creates the subcommand which adds or removes a grant
+func newSudoGrantCommand(registry *command.Registry, action string) *command.Command {
+	description := "Grants access to a user. Without flags the user becomes a sudo user; -chat allowlists them in the current chat and -module grants them a single module."
+	if action == "remove" {
+		description = "Revokes a grant given with sudo add, using the same flags"
+	}
+
+	return command.NewCommand(action).
+		WithUsage("sudo "+action+" [username/id] [-chat] [-module NAME]").
+		WithDescription(description).
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "user",
+				Type:        command.TypeEntity,
+				Kind:        command.KindPositional,
+				Required:    false,
+				Description: "Username or ID of the user (optional if replying to a message)",
+			},
+			command.ArgumentDefinition{
+				Name:        "chat",
+				Type:        command.TypeBool,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Default:     false,
+				Description: "Only applies to the current chat",
+			},
+			command.ArgumentDefinition{
+				Name:        "module",
+				Type:        command.TypeString,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Description: "Only applies to a single module",
+			},
+			command.ArgumentDefinition{
+				Name:        "reply",
+				Type:        command.TypeReply,
+				Required:    false,
+				Description: "Reply to a message to manage its sender",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			perms := registry.Permissions()
+			if perms == nil {
+				return fmt.Errorf("permissions are not configured")
+			}
+
+			user, err := sudoTarget(ctx, args)
+			if err != nil {
+				return err
+			}
+
+			kind, scope := command.GrantSudo, ""
+			if module := args.GetString("module"); module != "" {
+				if findModule(registry, module) == nil {
+					return command.UserErrorf("unknown module: %s", module)
+				}
+				kind, scope = command.GrantModule, module
+			} else if args.GetBool("chat") {
+				kind, scope = command.GrantChat, strconv.FormatInt(u.EffectiveChat().GetID(), 10)
+			}
+
+			var msg string
+			if action == "add" {
+				if err := perms.Grant(user.ID, kind, scope); err != nil {
+					return err
+				}
+				msg = fmt.Sprintf("✅ Granted %s to %s", describeGrant(kind, scope), utilities.FormatUserName(user))
+			} else {
+				removed, err := perms.Revoke(user.ID, kind, scope)
+				if err != nil {
+					return err
+				}
+				if !removed {
+					return command.UserErrorf("%s doesn't have %s", utilities.FormatUserName(user), describeGrant(kind, scope))
+				}
+				msg = fmt.Sprintf("✅ Revoked %s from %s", describeGrant(kind, scope), utilities.FormatUserName(user))
+			}
+
+			err = args.RespondText(ctx, u, msg)
+			return err
+		})
+}
+
+// newSudoListCommand creates the subcommand which lists every grant
+func newSudoListCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("list").
+		WithUsage("sudo list").
+		WithDescription("Lists every grant").
+		WithRole(command.RoleOwner).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			perms := registry.Permissions()
+			if perms == nil {
+				return fmt.Errorf("permissions are not configured")
+			}
+			return listGrants(ctx, u, args, perms)
+		})
+}
+
+// sudoTarget resolves the user a sudo command applies to from its arguments or the reply
+func sudoTarget(ctx *ext.Context, args *command.Arguments) (*types.User, error) {
+	if raw := args.GetPositionalEntity(0); raw != "" {
+		user, err := utilities.ResolveUser(ctx, raw)
+		if err != nil {
+			return nil, command.UserErrorf("failed to resolve user: %v", err)
+		}
+		return user, nil
+	}
+	if args.Reply != nil && args.Reply.Message != nil {
+		user, err := utilities.UserFromMessage(ctx, args.Reply.Message)
+		if err != nil {
+			return nil, command.UserErrorf("failed to get user from replied message: %v", err)
+		}
+		return user, nil
+	}
+	return nil, command.UserErrorf("please provide a username/ID or reply to a message")
+}
+
+// listGrants responds with every persisted grant
+func listGrants(ctx *ext.Context, u *ext.Update, args *command.Arguments, perms *command.Permissions) error {
+	grants := perms.List()
+	if len(grants) == 0 {
+		return args.RespondText(ctx, u, "No grants yet")
+	}
+
+	var b strings.Builder
+	b.WriteString("🔐 Grants\n")
+	for i, grant := range grants {
+		branch := " ├─ "
+		if i == len(grants)-1 {
+			branch = " └─ "
+		}
+		b.WriteString(fmt.Sprintf("%s%d: %s\n", branch, grant.UserID, describeGrant(grant.Kind, grant.Scope)))
+	}
+
+	return args.RespondText(ctx, u, b.String())
+}
+
+// describeGrant returns a human-readable description of a grant
+func describeGrant(kind command.GrantKind, scope string) string {
+	switch kind {
+	case command.GrantChat:
+		return "access in chat " + scope
+	case command.GrantModule:
+		return "access to the " + scope + " module"
+	default:
+		return "sudo"
+	}
+}
@@ -3,20 +3,27 @@ package modules
 import (
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
 	"github.com/watzon/macron/command"
 )
 
+// maxListed is the most entries the stats and history commands list at once
+const maxListed = 50
+
 // SystemModule contains system-related commands
 type SystemModule struct {
 	*command.BaseModule
 }
 
-// NewSystemModule creates a new system module
-func NewSystemModule() *SystemModule {
+// NewSystemModule creates a new system module configuring the given registry
+func NewSystemModule(registry *command.Registry) *SystemModule {
 	m := &SystemModule{
 		BaseModule: command.NewBaseModule(
 			"system",
@@ -25,13 +32,19 @@ func NewSystemModule() *SystemModule {
 	}
 
 	m.AddCommand(kill)
+	m.AddCommand(newPrefixCommand(registry))
+	m.AddCommand(newAliasCommand(registry))
+	m.AddCommand(newJobsCommand(registry))
+	m.AddCommand(newCancelCommand(registry))
+	m.AddCommand(newStatsCommand(registry))
+	m.AddCommand(newHistoryCommand(registry))
 
 	return m
 }
 
 // Load registers all module commands with the dispatcher
-func (m *SystemModule) Load(d dispatcher.Dispatcher, prefix string) {
-	m.BaseModule.Load(d, prefix)
+func (m *SystemModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
 }
 
 var kill = command.NewCommand("kill").
@@ -42,3 +55,397 @@ var kill = command.NewCommand("kill").
 		syscall.Kill(os.Getpid(), syscall.SIGTERM)
 		return nil
 	})
+
+func newPrefixCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("prefix").
+		WithUsage("prefix [set|reset|list]").
+		WithDescription("Shows the command prefixes accepted in the current chat").
+		WithRole(command.RoleOwner).
+		WithSubcommands(
+			command.NewCommand("set").
+				WithUsage("prefix set <prefix> [prefix...]").
+				WithDescription("Replaces the prefixes accepted in the current chat, e.g. to avoid colliding with another bot").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					// Prefixes like "-" would be parsed as flags, so they're read from the raw text
+					prefixes := strings.Fields(args.Raw)
+					if len(prefixes) == 0 {
+						return command.UserErrorf("please provide at least one prefix")
+					}
+					for _, prefix := range prefixes {
+						if strings.IndexFunc(prefix, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+							return command.UserErrorf("prefix %q can't contain letters or digits", prefix)
+						}
+					}
+
+					if err := registry.Prefixes().Set(u.EffectiveChat().GetID(), prefixes); err != nil {
+						return err
+					}
+					return args.RespondText(ctx, u, "✅ Commands in this chat now use "+strings.Join(prefixes, " "))
+				}),
+			command.NewCommand("reset").
+				WithUsage("prefix reset").
+				WithDescription("Makes the current chat accept the default prefixes again").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					removed, err := registry.Prefixes().Reset(u.EffectiveChat().GetID())
+					if err != nil {
+						return err
+					}
+					if !removed {
+						return command.UserErrorf("this chat already uses the default prefixes")
+					}
+					return args.RespondText(ctx, u, "✅ Commands in this chat now use "+strings.Join(registry.Prefixes().Defaults(), " "))
+				}),
+			command.NewCommand("list").
+				WithUsage("prefix list").
+				WithDescription("Lists every chat with its own prefixes").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					prefixes := registry.Prefixes()
+
+					var b strings.Builder
+					b.WriteString("🔤 Prefixes\n")
+					b.WriteString(" ├─ default: " + strings.Join(prefixes.Defaults(), " ") + "\n")
+					chatIDs := prefixes.Overrides()
+					if len(chatIDs) == 0 {
+						b.WriteString(" └─ no chat overrides\n")
+					}
+					for i, chatID := range chatIDs {
+						branch := " ├─ "
+						if i == len(chatIDs)-1 {
+							branch = " └─ "
+						}
+						override, _ := prefixes.Override(chatID)
+						b.WriteString(fmt.Sprintf("%s%d: %s\n", branch, chatID, strings.Join(override, " ")))
+					}
+
+					return args.RespondLong(ctx, u, command.OverflowSplit, "", styling.Plain(b.String()))
+				}),
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			chatID := u.EffectiveChat().GetID()
+			prefixes := registry.Prefixes()
+
+			source := "the defaults"
+			if _, ok := prefixes.Override(chatID); ok {
+				source = "an override for this chat"
+			}
+			return args.RespondText(ctx, u, fmt.Sprintf("🔤 Commands in this chat use %s (%s)", strings.Join(prefixes.For(chatID), " "), source))
+		})
+}
+
+func newAliasCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("alias").
+		WithUsage("alias <name> <command> [args...]").
+		WithDescription("Defines a shortcut for a command and its arguments. Use $1 to $9 for the shortcut's arguments and $@ for all of them, otherwise they're appended.").
+		WithRole(command.RoleOwner).
+		WithSubcommands(
+			command.NewCommand("remove").
+				WithUsage("alias remove <name>").
+				WithDescription("Removes an alias").
+				WithRole(command.RoleOwner).
+				WithArguments(
+					command.ArgumentDefinition{
+						Name:        "name",
+						Type:        command.TypeString,
+						Kind:        command.KindPositional,
+						Required:    true,
+						Description: "Name of the alias",
+					},
+				).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					aliases := registry.Aliases()
+					if aliases == nil {
+						return fmt.Errorf("aliases are not configured")
+					}
+
+					name := strings.TrimPrefix(args.GetPositionalString(0), args.Prefix)
+					removed, err := aliases.Remove(name)
+					if err != nil {
+						return err
+					}
+					if !removed {
+						return command.UserErrorf("unknown alias: %s", name)
+					}
+					return args.RespondText(ctx, u, "✅ Removed alias "+args.Prefix+name)
+				}),
+			command.NewCommand("list").
+				WithUsage("alias list").
+				WithDescription("Lists every alias").
+				WithRole(command.RoleOwner).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					aliases := registry.Aliases().List()
+					if len(aliases) == 0 {
+						return args.RespondText(ctx, u, "No aliases yet")
+					}
+
+					var b strings.Builder
+					b.WriteString("🔗 Aliases\n")
+					for i, alias := range aliases {
+						branch := " ├─ "
+						if i == len(aliases)-1 {
+							branch = " └─ "
+						}
+						b.WriteString(branch + args.Prefix + alias.Name + " → " + alias.Expansion + "\n")
+					}
+
+					return args.RespondLong(ctx, u, command.OverflowSplit, "", styling.Plain(b.String()))
+				}),
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			aliases := registry.Aliases()
+			if aliases == nil {
+				return fmt.Errorf("aliases are not configured")
+			}
+
+			// The expansion is kept verbatim so its flags aren't parsed as flags of alias itself
+			name, expansion, _ := strings.Cut(strings.TrimSpace(args.Raw), " ")
+			name = strings.TrimPrefix(name, args.Prefix)
+			expansion = strings.TrimPrefix(strings.TrimSpace(expansion), args.Prefix)
+			if name == "" || expansion == "" {
+				return command.UserErrorf("please provide a name and the command it expands into")
+			}
+			if cmd, _ := registry.FindCommand(name); cmd != nil {
+				return command.UserErrorf("%s%s is already a command", args.Prefix, name)
+			}
+
+			target := strings.Fields(expansion)[0]
+			if cmd, _ := registry.FindCommand(target); cmd == nil {
+				if _, ok := aliases.Get(target); !ok {
+					return command.UserErrorf("unknown command: %s", target)
+				}
+			}
+
+			// Aliases that would expand into themselves are rejected here, before they're saved
+			if err := aliases.Set(name, expansion); err != nil {
+				return err
+			}
+			return args.RespondText(ctx, u, fmt.Sprintf("✅ %s%s now runs %s%s", args.Prefix, name, args.Prefix, expansion))
+		})
+}
+
+func newJobsCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("jobs").
+		WithUsage("jobs").
+		WithDescription("Lists the long-running commands that are still running").
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			jobs := registry.Jobs().List()
+			if len(jobs) == 0 {
+				return args.RespondText(ctx, u, "No jobs are running")
+			}
+
+			var b strings.Builder
+			b.WriteString("⚙️ Jobs\n")
+			for i, job := range jobs {
+				branch := " ├─ "
+				if i == len(jobs)-1 {
+					branch = " └─ "
+				}
+				b.WriteString(fmt.Sprintf("%s#%d %s%s, running for %s", branch, job.ID, args.Prefix, job.Name, time.Since(job.Started).Round(time.Second)))
+				if progress := job.Progress(); progress != "" {
+					b.WriteString(" (" + progress + ")")
+				}
+				b.WriteString("\n")
+			}
+
+			return args.RespondLong(ctx, u, command.OverflowSplit, "", styling.Plain(b.String()))
+		})
+}
+
+func newCancelCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("cancel").
+		WithUsage("cancel [id]").
+		WithDescription("Cancels a running job by its ID, or the job whose status message is replied to").
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "id",
+				Type:        command.TypeInt,
+				Kind:        command.KindPositional,
+				Required:    false,
+				Description: "ID of the job, as listed by the jobs command",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			jobs := registry.Jobs()
+
+			var job *command.Job
+			if id := args.GetPositionalInt(0); id > 0 {
+				if job = jobs.Get(id); job == nil {
+					return command.UserErrorf("job #%d isn't running", id)
+				}
+			} else if args.Reply != nil {
+				if job = jobs.ForMessage(u.EffectiveChat().GetID(), args.Reply.ID); job == nil {
+					return command.UserErrorf("the replied message doesn't belong to a running job")
+				}
+			} else {
+				return command.UserErrorf("please provide a job ID or reply to a job's status message")
+			}
+
+			job.Cancel()
+			return args.RespondText(ctx, u, fmt.Sprintf("✅ Cancelled job #%d (%s%s)", job.ID, args.Prefix, job.Name))
+		})
+}
+
+func newStatsCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("stats").
+		WithUsage("stats [count] [-days n]").
+		WithDescription("Shows the most used commands with their failure rates and latencies").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "count",
+				Type:        command.TypeInt,
+				Kind:        command.KindPositional,
+				Required:    false,
+				Default:     10,
+				Description: fmt.Sprintf("Number of commands to show, at most %d", maxListed),
+			},
+			command.ArgumentDefinition{
+				Name:        "days",
+				Type:        command.TypeInt,
+				Kind:        command.KindNamed,
+				Required:    false,
+				Default:     7,
+				Description: "Number of days to include",
+			},
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			history := registry.History()
+			if history == nil {
+				return command.UserErrorf("command history isn't enabled")
+			}
+
+			count, days := args.GetPositionalInt(0), args.GetInt("days")
+			if count < 1 || count > maxListed || days < 1 {
+				return command.UserErrorf("count must be between 1 and %d, and days at least 1", maxListed)
+			}
+
+			stats, err := history.Stats(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				return err
+			}
+			if len(stats) == 0 {
+				return args.RespondText(ctx, u, fmt.Sprintf("No commands ran in the last %d days", days))
+			}
+			if len(stats) > count {
+				stats = stats[:count]
+			}
+
+			var b strings.Builder
+			b.WriteString(fmt.Sprintf("📊 Commands in the last %d days\n", days))
+			for i, s := range stats {
+				branch := " ├─ "
+				if i == len(stats)-1 {
+					branch = " └─ "
+				}
+				b.WriteString(fmt.Sprintf("%s%s%s: %d runs, %.1f%% failed, p50 %s, p90 %s, p99 %s\n",
+					branch, args.Prefix, s.Command, s.Count, s.FailureRate()*100,
+					formatLatency(s.P50), formatLatency(s.P90), formatLatency(s.P99)))
+			}
+
+			return args.RespondLong(ctx, u, command.OverflowSplit, "", styling.Plain(b.String()))
+		})
+}
+
+func newHistoryCommand(registry *command.Registry) *command.Command {
+	return command.NewCommand("history").
+		WithUsage("history [n] | history run <id>").
+		WithDescription("Lists the last commands that ran, newest first").
+		WithRole(command.RoleOwner).
+		WithArguments(
+			command.ArgumentDefinition{
+				Name:        "n",
+				Type:        command.TypeInt,
+				Kind:        command.KindPositional,
+				Required:    false,
+				Default:     10,
+				Description: fmt.Sprintf("Number of invocations to list, at most %d", maxListed),
+			},
+		).
+		WithSubcommands(
+			command.NewCommand("run").
+				WithUsage("history run <id>").
+				WithDescription("Runs a listed invocation again in the current chat").
+				WithRole(command.RoleOwner).
+				WithArguments(
+					command.ArgumentDefinition{
+						Name:        "id",
+						Type:        command.TypeInt,
+						Kind:        command.KindPositional,
+						Required:    true,
+						Description: "ID of the invocation, as listed by the history command",
+					},
+				).
+				WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+					history := registry.History()
+					if history == nil {
+						return command.UserErrorf("command history isn't enabled")
+					}
+
+					id := args.GetPositionalInt(0)
+					if id < 1 {
+						return command.UserErrorf("invocation ID must be a positive number")
+					}
+					inv, err := history.Get(uint(id))
+					if err != nil {
+						return err
+					}
+					if inv == nil {
+						return command.UserErrorf("invocation #%d doesn't exist", id)
+					}
+
+					// The command responds to this message in its place
+					return registry.Rerun(ctx, u, inv)
+				}),
+		).
+		WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+			history := registry.History()
+			if history == nil {
+				return command.UserErrorf("command history isn't enabled")
+			}
+
+			n := args.GetPositionalInt(0)
+			if n < 1 || n > maxListed {
+				return command.UserErrorf("n must be between 1 and %d", maxListed)
+			}
+
+			invocations, err := history.Recent(n)
+			if err != nil {
+				return err
+			}
+			if len(invocations) == 0 {
+				return args.RespondText(ctx, u, "No commands ran yet")
+			}
+
+			var b strings.Builder
+			b.WriteString("🕘 History\n")
+			for i, inv := range invocations {
+				branch := " ├─ "
+				if i == len(invocations)-1 {
+					branch = " └─ "
+				}
+				b.WriteString(fmt.Sprintf("%s#%d %s (%s, %s, %s ago)", branch, inv.ID, inv.Text(),
+					inv.Outcome, formatLatency(inv.Duration), time.Since(inv.CreatedAt).Round(time.Second)))
+				if inv.Error != "" {
+					b.WriteString(": " + inv.Error)
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString(fmt.Sprintf("\nRe-run one with %shistory run <id>", args.Prefix))
+
+			return args.RespondLong(ctx, u, command.OverflowSplit, "", styling.Plain(b.String()))
+		})
+}
+
+// formatLatency rounds a duration to a precision that's readable next to others
+func formatLatency(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
@@ -6,11 +6,11 @@ import (
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/ext"
-	"github.com/celestix/gotgproto/parsemode"
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/tg"
 	"github.com/watzon/hdur"
 	"github.com/watzon/macron/command"
+	"github.com/watzon/macron/styling"
 	"github.com/watzon/macron/utilities"
 )
 
@@ -40,8 +40,8 @@ func NewUserModule() *UserModule {
 }
 
 // Load registers all module commands with the dispatcher
-func (m *UserModule) Load(d dispatcher.Dispatcher, prefix string) {
-	m.BaseModule.Load(d, prefix)
+func (m *UserModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
 }
 
 var user = command.NewCommand("user").
@@ -92,7 +92,7 @@ var user = command.NewCommand("user").
 			// Try to resolve user from the provided argument
 			basicUser, err = utilities.ResolveUser(ctx, args.GetPositionalEntity(0))
 			if err != nil {
-				return fmt.Errorf("failed to resolve user: %v", err)
+				return command.UserErrorf("failed to resolve user: %v", err)
 			}
 		} else if args.Reply != nil {
 			// Try to get the user from the replied message
@@ -102,24 +102,29 @@ var user = command.NewCommand("user").
 					return fmt.Errorf("failed to get user from replied message: %v", err)
 				}
 			} else {
-				return fmt.Errorf("could not get replied message")
+				return command.UserErrorf("could not get replied message")
 			}
 		} else {
-			return fmt.Errorf("please provide a username/ID or reply to a message")
+			return command.UserErrorf("please provide a username/ID or reply to a message")
+		}
+
+		b := styling.NewBuilder()
+		b.Text("👤 ").Bold("User Information").Text("\n")
+
+		// field starts a line of the info tree with the given branch and field name
+		field := func(branch, name string) *styling.Builder {
+			return b.Text(branch).Bold(name + ":").Text(" ")
 		}
 
 		if args.GetBool("id") {
 			// Send the user ID as a reply
-			info := "👤 *User Information*\n"
-			info += " └─ *Basic Info*\n"
-			info += fmt.Sprintf("    └─ *ID:* `%d`\n", basicUser.ID)
-			_, err = ctx.Reply(u, ext.ReplyTextStyledTextArray(parsemode.StylizeText(info)), &ext.ReplyOpts{})
-			return err
+			b.Text(" └─ ").Bold("Basic Info").Text("\n")
+			field("    └─ ", "ID").Code(fmt.Sprint(basicUser.ID)).Text("\n")
+			return args.Respond(ctx, u, b.Build()...)
 		}
 
-		info := "👤 *User Information*\n"
-		info += " ├─ *Basic Info*\n"
-		info += fmt.Sprintf(" │  ├─ *ID:* `%d`\n", basicUser.ID)
+		b.Text(" ├─ ").Bold("Basic Info").Text("\n")
+		field(" │  ├─ ", "ID").Code(fmt.Sprint(basicUser.ID)).Text("\n")
 
 		// Get full user info
 		userFull, err = ctx.GetUser(basicUser.ID)
@@ -128,54 +133,54 @@ var user = command.NewCommand("user").
 		}
 
 		if basicUser.Username != "" {
-			var username string
+			field(" │  ├─ ", "Username")
 			if args.GetBool("mention") {
-				username = fmt.Sprintf("@%s", basicUser.Username)
+				b.Mention("@" + basicUser.Username)
 			} else {
-				username = fmt.Sprintf("`@%s`", basicUser.Username)
+				b.Code("@" + basicUser.Username)
 			}
-			info += fmt.Sprintf(" │  ├─ *Username:* %s\n", username)
+			b.Text("\n")
 		}
 
 		if basicUser.FirstName != "" {
-			info += fmt.Sprintf(" │  ├─ *First Name:* %s\n", basicUser.FirstName)
+			field(" │  ├─ ", "First Name").Text(basicUser.FirstName + "\n")
 		}
 
 		if basicUser.LastName != "" {
-			info += fmt.Sprintf(" │  └─ *Last Name:* %s\n", basicUser.LastName)
+			field(" │  └─ ", "Last Name").Text(basicUser.LastName + "\n")
 		}
 
-		info += " ├─ *Status*\n"
-		info += fmt.Sprintf(" │  ├─ *Bot:* %v\n", basicUser.Bot)
-		info += fmt.Sprintf(" │  ├─ *Verified:* %v\n", basicUser.Verified)
-		info += fmt.Sprintf(" │  ├─ *Premium:* %v\n", basicUser.Premium)
-		info += fmt.Sprintf(" │  ├─ *Scam:* %v\n", basicUser.Scam)
-		info += fmt.Sprintf(" │  └─ *Fake:* %v\n", basicUser.Fake)
+		b.Text(" ├─ ").Bold("Status").Text("\n")
+		field(" │  ├─ ", "Bot").Text(fmt.Sprintf("%v\n", basicUser.Bot))
+		field(" │  ├─ ", "Verified").Text(fmt.Sprintf("%v\n", basicUser.Verified))
+		field(" │  ├─ ", "Premium").Text(fmt.Sprintf("%v\n", basicUser.Premium))
+		field(" │  ├─ ", "Scam").Text(fmt.Sprintf("%v\n", basicUser.Scam))
+		field(" │  └─ ", "Fake").Text(fmt.Sprintf("%v\n", basicUser.Fake))
 
 		// Add additional information section
-		info += " └─ *Additional Info*\n"
+		b.Text(" └─ ").Bold("Additional Info").Text("\n")
 
 		if about, ok := userFull.GetAbout(); ok && about != "" {
-			info += fmt.Sprintf("    ├─ *Bio:* %s\n", about)
+			field("    ├─ ", "Bio").Text(about + "\n")
 		}
 
 		// Add last seen status if available
-		info += fmt.Sprintf("    ├─ *Last Seen:* %s\n", utilities.FormatUserStatus(basicUser.Status))
+		field("    ├─ ", "Last Seen").Text(utilities.FormatUserStatus(basicUser.Status) + "\n")
 
 		// Add other useful info
-		info += fmt.Sprintf("    ├─ *Phone Calls Available:* %v\n", userFull.PhoneCallsAvailable)
-		info += fmt.Sprintf("    ├─ *Phone Calls Private:* %v\n", userFull.PhoneCallsPrivate)
-		info += fmt.Sprintf("    ├─ *Can Pin Message:* %v\n", userFull.CanPinMessage)
-		info += fmt.Sprintf("    └─ *Common Chats Count:* %v\n", userFull.CommonChatsCount)
+		field("    ├─ ", "Phone Calls Available").Text(fmt.Sprintf("%v\n", userFull.PhoneCallsAvailable))
+		field("    ├─ ", "Phone Calls Private").Text(fmt.Sprintf("%v\n", userFull.PhoneCallsPrivate))
+		field("    ├─ ", "Can Pin Message").Text(fmt.Sprintf("%v\n", userFull.CanPinMessage))
+		field("    └─ ", "Common Chats Count").Text(fmt.Sprintf("%v\n", userFull.CommonChatsCount))
 
-		// Send the formatted message using StylizeText for markdown parsing
-		_, err = ctx.Reply(u, ext.ReplyTextStyledTextArray(parsemode.StylizeText(info)), &ext.ReplyOpts{})
-		return err
+		// Names and bios are plain text styles, so they can't break the formatting
+		return args.Respond(ctx, u, b.Build()...)
 	})
 
 var ban = command.NewCommand("ban").
 	WithUsage("ban [username/id]").
 	WithDescription("Ban a user from the chat using their username/ID or by replying to a message.").
+	WithStrict(true).
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "user",
@@ -242,7 +247,7 @@ var ban = command.NewCommand("ban").
 
 		if args.GetBool("delete") {
 			// Delete the replied message
-			err = ctx.DeleteMessages(chat.GetID(), []int{u.EffectiveMessage.ID})
+			err = args.Telegram().Delete(ctx, u, u.EffectiveMessage.ID)
 			if err != nil {
 				return fmt.Errorf("failed to delete message: %v", err)
 			}
@@ -250,7 +255,7 @@ var ban = command.NewCommand("ban").
 
 		if args.GetBool("silent") {
 			// Delete the sent message
-			err = ctx.DeleteMessages(chat.GetID(), []int{u.EffectiveMessage.ID})
+			err = args.Telegram().Delete(ctx, u, u.EffectiveMessage.ID)
 			if err != nil {
 				return fmt.Errorf("failed to delete message: %v", err)
 			}
@@ -260,7 +265,7 @@ var ban = command.NewCommand("ban").
 			if !duration.IsZero() {
 				durationText = fmt.Sprintf("for %s", duration.String())
 			}
-			_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("✅ Banned %s %s", utilities.FormatUserName(basicUser), durationText)), &ext.ReplyOpts{})
+			err = args.RespondText(ctx, u, fmt.Sprintf("✅ Banned %s %s", utilities.FormatUserName(basicUser), durationText))
 			return err
 		}
 
@@ -274,6 +279,7 @@ var ban = command.NewCommand("ban").
 var mute = command.NewCommand("mute").
 	WithUsage("mute [username/id]").
 	WithDescription("Mute a user in the chat using their username/ID or by replying to a message.").
+	WithStrict(true).
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "user",
@@ -335,7 +341,7 @@ var mute = command.NewCommand("mute").
 		if !duration.IsZero() {
 			durationText = fmt.Sprintf("for %s", duration.String())
 		}
-		_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("✅ Muted %s %s", utilities.FormatUserName(basicUser), durationText)), &ext.ReplyOpts{})
+		err = args.RespondText(ctx, u, fmt.Sprintf("✅ Muted %s %s", utilities.FormatUserName(basicUser), durationText))
 		return err
 	})
 
@@ -375,7 +381,7 @@ var unmute = command.NewCommand("unmute").
 		}
 
 		// Send confirmation message
-		_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("✅ Unmuted %s", utilities.FormatUserName(basicUser))), &ext.ReplyOpts{})
+		err = args.RespondText(ctx, u, fmt.Sprintf("✅ Unmuted %s", utilities.FormatUserName(basicUser)))
 		return err
 	})
 
@@ -413,13 +419,14 @@ var unban = command.NewCommand("unban").
 		}
 
 		// Send confirmation message
-		_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("✅ Unbanned %s", utilities.FormatUserName(basicUser))), &ext.ReplyOpts{})
+		err = args.RespondText(ctx, u, fmt.Sprintf("✅ Unbanned %s", utilities.FormatUserName(basicUser)))
 		return err
 	})
 
 var kick = command.NewCommand("kick").
 	WithUsage("kick [username/id]").
 	WithDescription("Kick a user from the chat using their username/ID or by replying to a message.").
+	WithStrict(true).
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "user",
@@ -457,6 +464,6 @@ var kick = command.NewCommand("kick").
 		}
 
 		// Send confirmation message
-		_, err = ctx.Reply(u, ext.ReplyTextString(fmt.Sprintf("✅ Kicked %s", utilities.FormatUserName(basicUser))), &ext.ReplyOpts{})
+		err = args.RespondText(ctx, u, fmt.Sprintf("✅ Kicked %s", utilities.FormatUserName(basicUser)))
 		return err
 	})
@@ -2,20 +2,24 @@ package modules
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/ext"
-	"github.com/gotd/td/telegram/uploader"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
 	"github.com/watzon/macron/command"
 	"github.com/watzon/macron/logger"
+	markup "github.com/watzon/macron/styling"
 	"github.com/watzon/macron/utilities"
 )
 
@@ -35,56 +39,46 @@ func NewUtilitiesModule() *UtilitiesModule {
 
 	m.AddCommand(jsonify)
 	m.AddCommand(paste)
+	m.AddCommand(raw)
+	m.AddTrigger(sed)
 
 	return m
 }
 
 // Load registers all module commands with the dispatcher
-func (m *UtilitiesModule) Load(d dispatcher.Dispatcher, prefix string) {
-	m.BaseModule.Load(d, prefix)
+func (m *UtilitiesModule) Load(d dispatcher.Dispatcher, r *command.Registry) {
+	m.BaseModule.Load(d, r)
 }
 
 var jsonify = command.NewCommand("json").
 	WithUsage("json").
 	WithDescription("Converts a message to JSON").
-	WithHandler(func(ctx *ext.Context, u *ext.Update, _ *command.Arguments) error {
+	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
 		msg := u.EffectiveMessage
-		jsonBytes, err := json.MarshalIndent(msg, "", "    ")
+
+		// The entities alone don't say which text they format, so the formatted text is included
+		// as markup too
+		output := struct {
+			*types.Message
+			MarkdownV2 string `json:",omitempty"`
+			HTML       string `json:",omitempty"`
+		}{Message: msg}
+		if len(msg.Entities) > 0 {
+			output.MarkdownV2 = markup.Render(msg.Text, msg.Entities, markup.FormatMarkdownV2)
+			output.HTML = markup.Render(msg.Text, msg.Entities, markup.FormatHTML)
+		}
+
+		jsonBytes, err := json.MarshalIndent(output, "", "    ")
 		if err != nil {
 			return err
 		}
 
-		output := string(jsonBytes)
-
 		// Send as file if too long
-		if len(output) > 4096 {
-			f, err := uploader.NewUploader(ctx.Raw).FromBytes(ctx, "message.json", []byte(output))
-			if err != nil {
-				return fmt.Errorf("failed to upload JSON document: %v", err)
-			}
-			_, err = ctx.SendMedia(u.EffectiveChat().GetID(), &tg.MessagesSendMediaRequest{
-				Media: &tg.InputMediaUploadedDocument{
-					MimeType: "application/json",
-					File:     f,
-					Attributes: []tg.DocumentAttributeClass{
-						&tg.DocumentAttributeFilename{
-							FileName: "message.json",
-						},
-					},
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("failed to send JSON: %v", err)
-			}
-			return nil
-		}
-
-		_, err = ctx.Reply(u, ext.ReplyTextString(output), nil)
-		return err
+		return args.RespondLong(ctx, u, command.OverflowFile, "message.json", styling.Plain(string(jsonBytes)))
 	})
 
 var paste = command.NewCommand("paste").
-	WithUsage("paste [--cb]").
+	WithUsage("paste [--cb] [-format markdownv2|html|markdown]").
 	WithArguments(
 		command.ArgumentDefinition{
 			Name:        "cb",
@@ -93,6 +87,13 @@ var paste = command.NewCommand("paste").
 			Default:     false,
 			Description: "Paste code blocks separately",
 		},
+		command.ArgumentDefinition{
+			Name:        "format",
+			Type:        command.TypeChoice,
+			Kind:        command.KindNamed,
+			Choices:     markup.Formats,
+			Description: "Paste the formatted source of the message instead of its text",
+		},
 		command.ArgumentDefinition{
 			Name:        "silent",
 			Type:        command.TypeBool,
@@ -101,13 +102,31 @@ var paste = command.NewCommand("paste").
 			Description: "Do not send the paste URL in the reply",
 		},
 	).
-	WithDescription("Creates a paste on 0x45.st from the replied message. Use --cb to paste code blocks separately.").
+	WithDescription("Creates a paste on 0x45.st from the replied message, or from the output of the command piped into it. Use --cb to paste code blocks separately.").
+	WithJob(true).
+	WithMiddleware(command.DeleteTrigger()).
 	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+		if err := args.Progress(ctx, u, "Creating paste..."); err != nil {
+			return err
+		}
+
+		// Output piped from another command is pasted instead of the replied message
+		if input, ok := args.Input(); ok {
+			url, err := createPaste(ctx, []byte(input), "txt")
+			if err != nil {
+				return err
+			}
+			if args.GetBool("silent") {
+				logger.Log(fmt.Sprintf("Created paste: %s", url))
+				return nil
+			}
+			return args.Output(ctx, u, fmt.Sprintf("Created paste: %s", url))
+		}
+
 		replyTo := args.Reply
-		ctx.DeleteMessages(u.EffectiveChat().GetID(), []int{u.EffectiveMessage.ID})
 
 		if replyTo == nil {
-			return fmt.Errorf("please reply to a message to create a paste")
+			return command.UserErrorf("please reply to a message to create a paste")
 		}
 
 		// The message that comes before the URL
@@ -128,31 +147,27 @@ var paste = command.NewCommand("paste").
 				return fmt.Errorf("failed to map entities")
 			}
 
-			fmt.Println(entities)
-
 			for _, entity := range entities {
 				if entity.TypeName() == "messageEntityPre" {
 					entity := entity.(*tg.MessageEntityPre)
-					offset := entity.GetOffset()
-					length := entity.GetLength()
 					language := entity.Language
 					if language == "" {
 						language = "txt"
 					}
 					codeBlocks = append(codeBlocks, codeBlock{
 						language: language,
-						content:  text[offset : offset+length],
+						content:  markup.EntityText(text, entity),
 					})
 				}
 			}
 
 			if len(codeBlocks) == 0 {
-				return fmt.Errorf("no code blocks found in the message")
+				return command.UserErrorf("no code blocks found in the message")
 			}
 
 			var urls []string
 			for i, block := range codeBlocks {
-				url, err := createPaste([]byte(block.content), block.language)
+				url, err := createPaste(ctx, []byte(block.content), block.language)
 				if err != nil {
 					return fmt.Errorf("failed to create paste for code block %d: %v", i+1, err)
 				}
@@ -169,9 +184,7 @@ var paste = command.NewCommand("paste").
 			if args.GetBool("silent") {
 				logger.Log(messageText)
 			} else {
-				_, err := ctx.Reply(u, ext.ReplyTextString(messageText), &ext.ReplyOpts{
-					ReplyToMessageId: replyTo.GetID(),
-				})
+				_, err := args.Telegram().Reply(ctx, u, replyTo.GetID(), styling.Plain(messageText))
 				return err
 			}
 		} else if replyTo.Media != nil {
@@ -188,7 +201,7 @@ var paste = command.NewCommand("paste").
 				return fmt.Errorf("failed to read media file: %v", err)
 			}
 
-			url, err := createPaste(bytes, filepath.Ext(path))
+			url, err := createPaste(ctx, bytes, filepath.Ext(path))
 			if err != nil {
 				return fmt.Errorf("failed to create paste: %v", err)
 			}
@@ -202,16 +215,18 @@ var paste = command.NewCommand("paste").
 			if args.GetBool("silent") {
 				logger.Log(messageText)
 			} else {
-				_, err = ctx.Reply(u, ext.ReplyTextString(messageText), &ext.ReplyOpts{
-					ReplyToMessageId: replyTo.GetID(),
-				})
+				_, err = args.Telegram().Reply(ctx, u, replyTo.GetID(), styling.Plain(messageText))
 				return err
 			}
 			return err
 		}
 
 		// Create a single paste from the entire message
-		url, err := createPaste([]byte(replyTo.Text), "txt")
+		content, extension := replyTo.Text, "txt"
+		if format := markup.Format(args.GetString("format")); format != "" {
+			content, extension = markup.Render(replyTo.Text, replyTo.Entities, format), formatExtension(format)
+		}
+		url, err := createPaste(ctx, []byte(content), extension)
 		if err != nil {
 			return err
 		}
@@ -225,14 +240,94 @@ var paste = command.NewCommand("paste").
 		if args.GetBool("silent") {
 			logger.Log(messageText)
 		} else {
-			_, err = ctx.Reply(u, ext.ReplyTextString(messageText), &ext.ReplyOpts{
-				ReplyToMessageId: replyTo.GetID(),
-			})
+			_, err = args.Telegram().Reply(ctx, u, replyTo.GetID(), styling.Plain(messageText))
 		}
 		return err
 	})
 
-func createPaste(content []byte, extension string) (string, error) {
+var raw = command.NewCommand("raw").
+	WithUsage("raw [-format markdownv2|html|markdown]").
+	WithDescription("Shows the formatted source of the replied message, in MarkdownV2 unless another format is given").
+	WithAliases("md").
+	WithArguments(
+		command.ArgumentDefinition{
+			Name:        "format",
+			Type:        command.TypeChoice,
+			Kind:        command.KindNamed,
+			Choices:     markup.Formats,
+			Description: "Format to show the source in",
+		},
+	).
+	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+		if args.Reply == nil || args.Reply.Text == "" {
+			return command.UserErrorf("please reply to a message with text")
+		}
+
+		format := markup.Format(args.GetString("format"))
+		if format == "" {
+			format = markup.FormatMarkdownV2
+		}
+		source := markup.Render(args.Reply.Text, args.Reply.Entities, format)
+
+		// Send as file if too long
+		return args.RespondLong(ctx, u, command.OverflowFile, "message."+formatExtension(format), styling.Pre(source, string(format)))
+	})
+
+// sedBackref matches the \1 style back references of a sed replacement
+var sedBackref = regexp.MustCompile(`\\([0-9])`)
+
+var sed = command.NewTrigger("sed", `^s/(?P<pattern>(?:\\.|[^/\\])+)/(?P<replacement>(?:\\.|[^/\\])*)(?:/(?P<flags>[gi]*))?$`).
+	WithDescription("Corrects the replied message with a sed-style substitution like s/foo/bar/g").
+	WithHandler(func(ctx *ext.Context, u *ext.Update, args *command.Arguments) error {
+		if args.Reply == nil || args.Reply.Text == "" {
+			return nil // Not meant as a correction
+		}
+
+		pattern := strings.ReplaceAll(args.Group("pattern"), `\/`, "/")
+		if strings.Contains(args.Group("flags"), "i") {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return command.UserErrorf("invalid pattern: %v", err)
+		}
+
+		// sed writes back references as \1 while Go expects ${1}
+		replacement := strings.ReplaceAll(args.Group("replacement"), `\/`, "/")
+		replacement = sedBackref.ReplaceAllString(strings.ReplaceAll(replacement, "$", "$$"), "$${$1}")
+
+		text := args.Reply.Text
+		var corrected string
+		if strings.Contains(args.Group("flags"), "g") {
+			corrected = re.ReplaceAllString(text, replacement)
+		} else if loc := re.FindStringSubmatchIndex(text); loc != nil {
+			corrected = text[:loc[0]] + string(re.ExpandString(nil, replacement, text, loc)) + text[loc[1]:]
+		} else {
+			corrected = text
+		}
+
+		if corrected == text {
+			return command.UserErrorf("no match for %s", args.Group("pattern"))
+		}
+		if strings.TrimSpace(corrected) == "" {
+			return command.UserErrorf("the correction leaves nothing of the message")
+		}
+		return command.EditSink(ctx, u, args, corrected)
+	})
+
+// formatExtension returns the file extension of text in a markup format
+func formatExtension(format markup.Format) string {
+	switch format {
+	case markup.FormatHTML:
+		return "html"
+	case markup.FormatMarkdown, markup.FormatMarkdownV2:
+		return "md"
+	default:
+		return "txt"
+	}
+}
+
+func createPaste(ctx context.Context, content []byte, extension string) (string, error) {
 	// Strip leading dot from extension
 	extension = strings.TrimPrefix(extension, ".")
 
@@ -255,7 +350,7 @@ func createPaste(content []byte, extension string) (string, error) {
 	writer.Close()
 
 	// Create request
-	req, err := http.NewRequest("POST", "https://0x45.st/p", body)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://0x45.st/p", body)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
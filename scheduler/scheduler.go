@@ -0,0 +1,267 @@
+// Package scheduler runs commands at a later time. Scheduled runs are kept in storage, so they
+// survive restarts, and runs missed while offline are made up for according to a catch-up
+// policy.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/watzon/hdur"
+	"github.com/watzon/macron/logger"
+	"github.com/watzon/macron/storage"
+)
+
+const (
+	// MinInterval is the shortest interval repeating runs may be scheduled with
+	MinInterval = time.Minute
+
+	// missedAfter is how late a run may start before it counts as missed
+	missedAfter = time.Minute
+	// maxCatchUp caps how many missed runs are made up for with CatchUpAll
+	maxCatchUp = 10
+	// maxWait is the longest the scheduler sleeps before checking again, so it stays on time
+	// when the system clock changes
+	maxWait = time.Hour
+)
+
+// Policy decides what happens to runs that were missed while offline
+type Policy string
+
+const (
+	CatchUpOnce Policy = "once" // Missed runs are made up for with a single run
+	CatchUpAll  Policy = "all"  // Every missed run is made up for, up to 10 of them
+	CatchUpSkip Policy = "skip" // Missed runs are dropped
+)
+
+// Policies lists the valid catch-up policies
+var Policies = []string{string(CatchUpOnce), string(CatchUpAll), string(CatchUpSkip)}
+
+// Job is a command scheduled to run in a chat
+type Job struct {
+	ID        int
+	ChatID    int64
+	TriggerID int           // Message the job was scheduled with, to replace it when edited
+	Text      string        // Message text that invokes the command, including its prefix
+	Next      time.Time     // When the job runs next
+	Every     hdur.Duration // Interval of repeating jobs, zero for jobs that run once
+	CatchUp   Policy
+	Created   time.Time
+}
+
+// Repeating reports whether the job runs more than once
+func (j Job) Repeating() bool {
+	return !j.Every.IsZero()
+}
+
+// RunFunc runs a due job
+type RunFunc func(ctx context.Context, job Job)
+
+// Scheduler keeps scheduled jobs in a namespace and runs them once they're due
+type Scheduler struct {
+	ns   *storage.Namespace
+	jobs *storage.Collection[Job]
+	run  RunFunc
+
+	mu      sync.Mutex // Serializes changes to the stored jobs
+	wake    chan struct{}
+	running sync.WaitGroup
+}
+
+// New creates a scheduler storing its jobs in the given namespace. Due jobs are handed to run.
+func New(ns *storage.Namespace, run RunFunc) *Scheduler {
+	return &Scheduler{
+		ns:   ns,
+		jobs: storage.NewCollection[Job](ns, "jobs"),
+		run:  run,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Add schedules a job, returning it with its ID assigned
+func (s *Scheduler) Add(job Job) (Job, error) {
+	if job.Repeating() && job.Every.Add(job.Next).Sub(job.Next) < MinInterval {
+		return Job{}, fmt.Errorf("jobs can't repeat more often than every %s", MinInterval)
+	}
+	if job.CatchUp == "" {
+		job.CatchUp = CatchUpOnce
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := storage.GetOr(s.ns, "next_id", 1)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := s.ns.Set("next_id", id+1); err != nil {
+		return Job{}, err
+	}
+
+	job.ID = id
+	job.Created = time.Now()
+	if err := s.jobs.Put(strconv.Itoa(id), job); err != nil {
+		return Job{}, err
+	}
+
+	s.notify()
+	return job, nil
+}
+
+// Get returns the job with the given ID, reporting whether it exists
+func (s *Scheduler) Get(id int) (Job, bool, error) {
+	return s.jobs.Get(strconv.Itoa(id))
+}
+
+// Cancel removes a job, reporting whether it existed
+func (s *Scheduler) Cancel(id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, err := s.jobs.Delete(strconv.Itoa(id))
+	if removed {
+		s.notify()
+	}
+	return removed, err
+}
+
+// List returns the scheduled jobs, the next to run first
+func (s *Scheduler) List() ([]Job, error) {
+	docs, err := s.jobs.All()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, len(docs))
+	for i, doc := range docs {
+		jobs[i] = doc.Doc
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].Next.Equal(jobs[b].Next) {
+			return jobs[a].Next.Before(jobs[b].Next)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+	return jobs, nil
+}
+
+// Run runs jobs as they become due until the context is done, then waits for the running jobs
+// to return. Jobs that became due while the scheduler wasn't running are caught up on first.
+func (s *Scheduler) Run(ctx context.Context) error {
+	defer s.running.Wait()
+
+	for {
+		wait, err := s.tick(ctx, time.Now())
+		if err != nil {
+			logger.Error("Failed to run scheduled jobs: %v", err)
+			wait = missedAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// tick starts the jobs that are due and returns how long to wait for the next one
+func (s *Scheduler) tick(ctx context.Context, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	wait := maxWait
+	for _, job := range jobs {
+		runs, missed, next, done := plan(job, now)
+
+		// The job is rescheduled before it runs, so a crash can't run it twice
+		if done {
+			if _, err := s.jobs.Delete(strconv.Itoa(job.ID)); err != nil {
+				return 0, err
+			}
+		} else if !next.Equal(job.Next) {
+			job.Next = next
+			if err := s.jobs.Put(strconv.Itoa(job.ID), job); err != nil {
+				return 0, err
+			}
+		}
+
+		if runs == 0 && missed > 0 {
+			logger.Warning("Skipped %d missed runs of scheduled job #%d (%s)", missed, job.ID, job.Text)
+		}
+		if runs > 0 {
+			s.start(ctx, job, runs)
+		}
+		if !done && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// start runs a job the given number of times in the background
+func (s *Scheduler) start(ctx context.Context, job Job, runs int) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for i := 0; i < runs && ctx.Err() == nil; i++ {
+			s.run(ctx, job)
+		}
+	}()
+}
+
+// notify wakes the scheduler up to pick up changed jobs
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// plan decides how often a job runs at the given time according to its catch-up policy, and
+// returns that along with the number of missed runs, when the job runs next and whether it's
+// done
+func plan(job Job, now time.Time) (runs, missed int, next time.Time, done bool) {
+	if job.Next.After(now) {
+		return 0, 0, job.Next, false
+	}
+
+	// Count the runs that are due and how many of them are too late to count as on time
+	due := 0
+	for next = job.Next; !next.After(now); next = job.Every.Add(next) {
+		due++
+		if now.Sub(next) > missedAfter {
+			missed++
+		}
+		if !job.Repeating() {
+			break
+		}
+	}
+
+	switch {
+	case missed == 0:
+		runs = 1
+	case job.CatchUp == CatchUpSkip && missed < due:
+		runs = 1 // The latest run is still on time
+	case job.CatchUp == CatchUpSkip:
+		runs = 0
+	case job.CatchUp == CatchUpAll:
+		runs = min(due, maxCatchUp)
+	default:
+		runs = 1
+	}
+	return runs, missed, next, !job.Repeating()
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/watzon/hdur"
+)
+
+func TestPlan(t *testing.T) {
+	start := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	daily := hdur.Days(1)
+
+	tests := []struct {
+		name   string
+		job    Job
+		now    time.Time
+		runs   int
+		missed int
+		next   time.Time
+		done   bool
+	}{
+		{
+			name: "not due yet",
+			job:  Job{Next: start, CatchUp: CatchUpOnce},
+			now:  start.Add(-time.Second),
+			next: start,
+		},
+		{
+			name: "one-off on time",
+			job:  Job{Next: start, CatchUp: CatchUpSkip},
+			now:  start.Add(time.Second),
+			runs: 1,
+			next: start,
+			done: true,
+		},
+		{
+			name:   "one-off missed and skipped",
+			job:    Job{Next: start, CatchUp: CatchUpSkip},
+			now:    start.Add(time.Hour),
+			missed: 1,
+			next:   start,
+			done:   true,
+		},
+		{
+			name:   "one-off missed and caught up",
+			job:    Job{Next: start, CatchUp: CatchUpOnce},
+			now:    start.Add(time.Hour),
+			runs:   1,
+			missed: 1,
+			next:   start,
+			done:   true,
+		},
+		{
+			name: "repeating on time",
+			job:  Job{Next: start, Every: daily, CatchUp: CatchUpOnce},
+			now:  start.Add(time.Second),
+			runs: 1,
+			next: start.AddDate(0, 0, 1),
+		},
+		{
+			name:   "repeating missed once",
+			job:    Job{Next: start, Every: daily, CatchUp: CatchUpOnce},
+			now:    start.AddDate(0, 0, 3).Add(time.Hour),
+			runs:   1,
+			missed: 4,
+			next:   start.AddDate(0, 0, 4),
+		},
+		{
+			name:   "repeating missed all",
+			job:    Job{Next: start, Every: daily, CatchUp: CatchUpAll},
+			now:    start.AddDate(0, 0, 3).Add(time.Hour),
+			runs:   4,
+			missed: 4,
+			next:   start.AddDate(0, 0, 4),
+		},
+		{
+			name:   "repeating missed all is capped",
+			job:    Job{Next: start, Every: daily, CatchUp: CatchUpAll},
+			now:    start.AddDate(0, 0, 30),
+			runs:   maxCatchUp,
+			missed: 30,
+			next:   start.AddDate(0, 0, 31),
+		},
+		{
+			name:   "repeating missed and skipped",
+			job:    Job{Next: start, Every: daily, CatchUp: CatchUpSkip},
+			now:    start.AddDate(0, 0, 3).Add(time.Hour),
+			missed: 4,
+			next:   start.AddDate(0, 0, 4),
+		},
+		{
+			name:   "repeating skipped but latest on time",
+			job:    Job{Next: start, Every: daily, CatchUp: CatchUpSkip},
+			now:    start.AddDate(0, 0, 3).Add(time.Second),
+			runs:   1,
+			missed: 3,
+			next:   start.AddDate(0, 0, 4),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, missed, next, done := plan(tt.job, tt.now)
+			if runs != tt.runs || missed != tt.missed || !next.Equal(tt.next) || done != tt.done {
+				t.Errorf("plan() = %d runs, %d missed, next %s, done %v; want %d runs, %d missed, next %s, done %v",
+					runs, missed, next, done, tt.runs, tt.missed, tt.next, tt.done)
+			}
+		})
+	}
+}
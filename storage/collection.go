@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Collection stores documents of type T by ID within a namespace, such as a module's notes or
+// warnings. Documents are kept under the key "<collection>/<id>", so a namespace can hold
+// several collections next to plain keys.
+type Collection[T any] struct {
+	ns     *Namespace
+	prefix string
+}
+
+// Document is a document returned when listing a collection
+type Document[T any] struct {
+	ID        string
+	Doc       T
+	ExpiresAt *time.Time
+}
+
+// NewCollection returns the collection with the given name in a namespace
+func NewCollection[T any](ns *Namespace, name string) *Collection[T] {
+	return &Collection[T]{ns: ns, prefix: name + "/"}
+}
+
+// Get returns the document with the given ID, reporting whether it exists
+func (c *Collection[T]) Get(id string) (T, bool, error) {
+	return Get[T](c.ns, c.prefix+id)
+}
+
+// Put stores a document under the given ID, replacing any existing one
+func (c *Collection[T]) Put(id string, doc T) error {
+	return c.ns.Set(c.prefix+id, doc)
+}
+
+// PutTTL stores a document under the given ID that expires once ttl has passed
+func (c *Collection[T]) PutTTL(id string, doc T, ttl time.Duration) error {
+	return c.ns.SetTTL(c.prefix+id, doc, ttl)
+}
+
+// Delete removes the document with the given ID, reporting whether it existed
+func (c *Collection[T]) Delete(id string) (bool, error) {
+	return c.ns.Delete(c.prefix + id)
+}
+
+// Clear removes every document in the collection, returning how many were removed
+func (c *Collection[T]) Clear() (int64, error) {
+	return c.ns.DeletePrefix(c.prefix)
+}
+
+// Find returns the documents whose ID starts with prefix, sorted by ID
+func (c *Collection[T]) Find(prefix string) ([]Document[T], error) {
+	items, err := c.ns.List(c.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]Document[T], 0, len(items))
+	for _, item := range items {
+		doc := Document[T]{
+			ID:        strings.TrimPrefix(item.Key, c.prefix),
+			ExpiresAt: item.ExpiresAt,
+		}
+		if err := item.Decode(&doc.Doc); err != nil {
+			return nil, fmt.Errorf("collection %s: %w", strings.TrimSuffix(c.prefix, "/"), err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// All returns every document in the collection, sorted by ID
+func (c *Collection[T]) All() ([]Document[T], error) {
+	return c.Find("")
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a change to a namespace's data or tables, such as renaming keys or creating a
+// table with DB().AutoMigrate. Migrations run once, in order, each in its own transaction.
+type Migration struct {
+	// ID identifies the migration within its namespace. It must never change once released.
+	ID string
+	// Migrate applies the migration. The namespace passed in writes through the transaction.
+	Migrate func(tx *gorm.DB, ns *Namespace) error
+}
+
+// migrationRecord records a migration that was applied
+type migrationRecord struct {
+	Namespace string `gorm:"primaryKey"`
+	ID        string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// TableName returns the name of the table applied migrations are recorded in
+func (migrationRecord) TableName() string {
+	return "macron_migrations"
+}
+
+// Migrate applies the migrations of a namespace that haven't been applied yet, in order. It
+// returns the IDs of the migrations it applied, stopping at the first one that fails.
+func (s *Store) Migrate(namespace string, migrations ...Migration) ([]string, error) {
+	seen := make(map[string]bool, len(migrations))
+	for _, m := range migrations {
+		if m.ID == "" || m.Migrate == nil {
+			return nil, fmt.Errorf("invalid migration %q in %s: missing ID or function", m.ID, namespace)
+		}
+		if seen[m.ID] {
+			return nil, fmt.Errorf("duplicate migration %q in %s", m.ID, namespace)
+		}
+		seen[m.ID] = true
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var count int64
+			err := tx.Model(&migrationRecord{}).Where("namespace = ? AND id = ?", namespace, m.ID).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return errSkipMigration
+			}
+
+			txStore := &Store{db: tx}
+			if err := m.Migrate(tx, txStore.Namespace(namespace)); err != nil {
+				return err
+			}
+			return tx.Create(&migrationRecord{Namespace: namespace, ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+		if errors.Is(err, errSkipMigration) {
+			continue
+		}
+		if err != nil {
+			return applied, fmt.Errorf("migration %s of %s failed: %w", m.ID, namespace, err)
+		}
+		applied = append(applied, m.ID)
+	}
+	return applied, nil
+}
+
+// errSkipMigration rolls back the transaction of a migration that was already applied
+var errSkipMigration = errors.New("migration already applied")
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Namespace is a set of keys belonging to a single module
+type Namespace struct {
+	store *Store
+	name  string
+}
+
+// Item is an entry returned when listing a namespace
+type Item struct {
+	Key       string
+	Value     json.RawMessage
+	ExpiresAt *time.Time
+}
+
+// Decode decodes the item's value into v
+func (i Item) Decode(v any) error {
+	if err := json.Unmarshal(i.Value, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", i.Key, err)
+	}
+	return nil
+}
+
+// Name returns the name of the namespace
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Get decodes the value stored under key into v, reporting whether it exists and hasn't
+// expired
+func (n *Namespace) Get(key string, v any) (bool, error) {
+	var entries []Entry
+	err := n.store.db.Where("namespace = ? AND key = ?", n.name, key).Limit(1).Find(&entries).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	if len(entries) == 0 || entries[0].expired(time.Now()) {
+		return false, nil
+	}
+	entry := entries[0]
+
+	if err := json.Unmarshal(entry.Value, v); err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Has reports whether a value is stored under key and hasn't expired
+func (n *Namespace) Has(key string) (bool, error) {
+	var count int64
+	err := n.live().Where("key = ?", key).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", key, err)
+	}
+	return count > 0, nil
+}
+
+// Set stores v under key, replacing any existing value. The value never expires.
+func (n *Namespace) Set(key string, v any) error {
+	return n.set(key, v, nil)
+}
+
+// SetTTL stores v under key, replacing any existing value. The value expires once ttl has
+// passed.
+func (n *Namespace) SetTTL(key string, v any, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl)
+	return n.set(key, v, &expiresAt)
+}
+
+func (n *Namespace) set(key string, v any, expiresAt *time.Time) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+
+	entry := Entry{Namespace: n.name, Key: key, Value: value, ExpiresAt: expiresAt}
+	err = n.store.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "namespace"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "expires_at", "updated_at"}),
+	}).Create(&entry).Error
+	if err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the value stored under key, reporting whether it existed
+func (n *Namespace) Delete(key string) (bool, error) {
+	res := n.store.db.Where("namespace = ? AND key = ?", n.name, key).Delete(&Entry{})
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to delete %s: %w", key, res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// DeletePrefix removes every value whose key starts with prefix, returning how many were
+// removed
+func (n *Namespace) DeletePrefix(prefix string) (int64, error) {
+	res := n.store.db.Where("namespace = ?", n.name).Scopes(keyPrefix(prefix)).Delete(&Entry{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete %s*: %w", prefix, res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// List returns the entries whose key starts with prefix, sorted by key. An empty prefix lists
+// the whole namespace.
+func (n *Namespace) List(prefix string) ([]Item, error) {
+	var entries []Entry
+	err := n.live().Scopes(keyPrefix(prefix)).Order("key").Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s*: %w", prefix, err)
+	}
+
+	items := make([]Item, len(entries))
+	for i, entry := range entries {
+		items[i] = Item{Key: entry.Key, Value: entry.Value, ExpiresAt: entry.ExpiresAt}
+	}
+	return items, nil
+}
+
+// Keys returns the keys starting with prefix, sorted
+func (n *Namespace) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := n.live().Scopes(keyPrefix(prefix)).Order("key").Pluck("key", &keys).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s*: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// live scopes a query to the namespace's entries that haven't expired
+func (n *Namespace) live() *gorm.DB {
+	return n.store.db.Model(&Entry{}).
+		Where("namespace = ?", n.name).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// keyPrefix scopes a query to keys starting with prefix. LIKE is case-insensitive in SQLite,
+// so keys are compared directly.
+func keyPrefix(prefix string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if prefix == "" {
+			return db
+		}
+		return db.Where("substr(key, 1, ?) = ?", utf8.RuneCountInString(prefix), prefix)
+	}
+}
+
+// Get returns the value of type T stored under key, reporting whether it exists
+func Get[T any](n *Namespace, key string) (T, bool, error) {
+	var v T
+	ok, err := n.Get(key, &v)
+	return v, ok, err
+}
+
+// GetOr returns the value of type T stored under key, or def if there is none
+func GetOr[T any](n *Namespace, key string, def T) (T, error) {
+	v, ok, err := Get[T](n, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	return v, nil
+}
@@ -0,0 +1,89 @@
+// Package storage provides persistent key-value and document storage for modules, backed by
+// the session database. Every module gets its own namespace, so keys never collide between
+// modules.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/watzon/macron/logger"
+	"gorm.io/gorm"
+)
+
+// Entry is a value stored under a key in a namespace. Values are encoded as JSON.
+type Entry struct {
+	Namespace string `gorm:"primaryKey"`
+	Key       string `gorm:"primaryKey"`
+	Value     []byte
+	ExpiresAt *time.Time `gorm:"index"` // Nil for values that never expire
+	UpdatedAt time.Time
+}
+
+// TableName returns the name of the table entries are stored in
+func (Entry) TableName() string {
+	return "macron_kv"
+}
+
+// expired reports whether the entry's TTL has passed
+func (e *Entry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !e.ExpiresAt.After(now)
+}
+
+// Store is the storage shared by all modules
+type Store struct {
+	db *gorm.DB
+}
+
+// New creates the storage tables if needed and removes entries that expired while the bot
+// wasn't running
+func New(db *gorm.DB) (*Store, error) {
+	if err := db.AutoMigrate(&Entry{}, &migrationRecord{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate storage tables: %w", err)
+	}
+
+	s := &Store{db: db}
+	if _, err := s.Purge(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DB returns the underlying database, for modules that need tables of their own
+func (s *Store) DB() *gorm.DB {
+	return s.db
+}
+
+// Namespace returns the namespace with the given name, usually the name of a module
+func (s *Store) Namespace(name string) *Namespace {
+	return &Namespace{store: s, name: name}
+}
+
+// Purge deletes every expired entry, returning how many were deleted. Expired entries are
+// never returned even before they're purged.
+func (s *Store) Purge() (int64, error) {
+	res := s.db.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Delete(&Entry{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to purge expired entries: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// Run purges expired entries at the given interval until the context is done. Failed purges
+// are logged and tried again at the next interval.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Purge(); err != nil {
+				logger.Error("Failed to purge expired storage entries: %v", err)
+			}
+		}
+	}
+}
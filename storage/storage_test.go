@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/watzon/macron/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// newTestStore returns a store backed by an in-memory database
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(testdb.Open(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNamespace_TTL(t *testing.T) {
+	s := newTestStore(t)
+	ns := s.Namespace("test")
+
+	if err := ns.SetTTL("expired", "old", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.SetTTL("fresh", "new", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Set("forever", "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := Get[string](ns, "expired"); err != nil || ok {
+		t.Errorf("Get() of an expired key = %v, %v, want it missing", ok, err)
+	}
+	if ok, err := ns.Has("expired"); err != nil || ok {
+		t.Errorf("Has() of an expired key = %v, %v, want false", ok, err)
+	}
+	if v, ok, err := Get[string](ns, "fresh"); err != nil || !ok || v != "new" {
+		t.Errorf("Get() of a fresh key = %q, %v, %v, want new", v, ok, err)
+	}
+	keys, err := ns.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"forever", "fresh"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+
+	if n, err := s.Purge(); err != nil || n != 1 {
+		t.Errorf("Purge() = %d, %v, want 1 entry purged", n, err)
+	}
+
+	// Setting a value without a TTL clears the old one
+	if err := ns.Set("fresh", "renewed"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ns.List("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ExpiresAt != nil {
+		t.Errorf("List() after Set() = %+v, want a single entry without expiry", items)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	s := newTestStore(t)
+	ns := s.Namespace("test")
+
+	tests := []struct {
+		name string
+		set  func() error
+		want int
+	}{
+		{name: "missing key", set: func() error { return nil }, want: 7},
+		{name: "stored value", set: func() error { return ns.Set("count", 3) }, want: 3},
+		{name: "expired value", set: func() error { return ns.SetTTL("count", 3, -time.Second) }, want: 7},
+		{name: "zero value", set: func() error { return ns.Set("count", 0) }, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.set(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := GetOr(ns, "count", 7)
+			if err != nil {
+				t.Fatalf("GetOr() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetOr() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if err := ns.Set("count", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetOr(ns, "count", 7); err == nil || got != 7 {
+		t.Errorf("GetOr() of an undecodable value = %d, %v, want the default and an error", got, err)
+	}
+}
+
+func TestNamespace_PrefixScan(t *testing.T) {
+	s := newTestStore(t)
+	ns := s.Namespace("test")
+	other := s.Namespace("other")
+
+	for _, key := range []string{"user:20", "user:100", "User:3", "user:3", "users", "chat:1", "ünï:1"} {
+		if err := ns.Set(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.Set("user:1", "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "user:", want: []string{"user:100", "user:20", "user:3"}},
+		{prefix: "user", want: []string{"user:100", "user:20", "user:3", "users"}},
+		{prefix: "User", want: []string{"User:3"}},
+		{prefix: "ünï", want: []string{"ünï:1"}},
+		{prefix: "none", want: nil},
+		{prefix: "", want: []string{"User:3", "chat:1", "user:100", "user:20", "user:3", "users", "ünï:1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			keys, err := ns.Keys(tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != len(tt.want) || (len(keys) > 0 && !reflect.DeepEqual(keys, tt.want)) {
+				t.Errorf("Keys(%q) = %v, want %v", tt.prefix, keys, tt.want)
+			}
+
+			items, err := ns.List(tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, item := range items {
+				var v string
+				if err := item.Decode(&v); err != nil || item.Key != tt.want[i] || v != tt.want[i] {
+					t.Errorf("List(%q)[%d] = %s: %q, want %s", tt.prefix, i, item.Key, v, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if n, err := ns.DeletePrefix("user:"); err != nil || n != 3 {
+		t.Errorf("DeletePrefix() = %d, %v, want 3 deleted", n, err)
+	}
+	if ok, _ := other.Has("user:1"); !ok {
+		t.Errorf("DeletePrefix() removed a key of another namespace")
+	}
+}
+
+func TestStore_Migrate(t *testing.T) {
+	s := newTestStore(t)
+	ns := s.Namespace("test")
+
+	var runs []string
+	migration := func(id string, err error) Migration {
+		return Migration{ID: id, Migrate: func(tx *gorm.DB, ns *Namespace) error {
+			runs = append(runs, id)
+			if err := ns.Set(id, true); err != nil {
+				return err
+			}
+			return err
+		}}
+	}
+	v1, v2 := migration("v1", nil), migration("v2", nil)
+	broken := migration("v3", errors.New("broken"))
+
+	applied, err := s.Migrate("test", v1)
+	if err != nil || !reflect.DeepEqual(applied, []string{"v1"}) {
+		t.Fatalf("Migrate(v1) = %v, %v, want v1 applied", applied, err)
+	}
+
+	// Migrations that were applied before are skipped
+	applied, err = s.Migrate("test", v1, v2)
+	if err != nil || !reflect.DeepEqual(applied, []string{"v2"}) {
+		t.Fatalf("Migrate(v1, v2) = %v, %v, want v2 applied", applied, err)
+	}
+
+	// A failing migration is rolled back and applied again next time
+	applied, err = s.Migrate("test", v1, v2, broken)
+	if err == nil || len(applied) != 0 {
+		t.Errorf("Migrate() with a failing migration = %v, %v, want an error", applied, err)
+	}
+	if ok, _ := ns.Has("v3"); ok {
+		t.Errorf("changes of a failing migration weren't rolled back")
+	}
+	if want := []string{"v1", "v2", "v3"}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("migrations ran as %v, want %v", runs, want)
+	}
+
+	// Migrations are recorded per namespace
+	applied, err = s.Migrate("other", v1)
+	if err != nil || !reflect.DeepEqual(applied, []string{"v1"}) {
+		t.Errorf("Migrate() of another namespace = %v, %v, want v1 applied", applied, err)
+	}
+
+	for _, invalid := range [][]Migration{{{ID: "", Migrate: v1.Migrate}}, {{ID: "v1"}}, {v1, v1}} {
+		if _, err := s.Migrate("test", invalid...); err == nil {
+			t.Errorf("Migrate(%v) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestStore_RunSurvivesFailedPurges(t *testing.T) {
+	s := newTestStore(t)
+	sqlDB, err := s.DB().DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Run() returned after a failed purge")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() didn't return once the context was cancelled")
+	}
+}
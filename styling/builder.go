@@ -6,28 +6,110 @@
 package styling
 
 import (
+	"github.com/gotd/td/telegram/message/entity"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
 )
 
+// Style is a run of text with one of Telegram's entity types, named like the Bot API names
+// them: plain, mention, hashtag, bot_command, url, email, bold, italic, code, pre, text_url,
+// mention_name, phone, cashtag, underline, strike, bank_card, spoiler, custom_emoji and
+// blockquote. A style with children wraps them instead of having text of its own.
 type Style struct {
 	Type       string
 	Text       string
-	URL        string
-	Collapsed  bool
-	DocumentID int64
+	URL        string            // Link of a text_url
+	Language   string            // Programming language of a pre
+	UserID     tg.InputUserClass // User a mention_name links to
+	Collapsed  bool              // Whether a blockquote is collapsed
+	DocumentID int64             // Document of a custom_emoji
+	Children   []Style
+}
+
+// format returns the formatter of the style's entity, or nil for plain text and unknown types
+func (s Style) format() entity.Formatter {
+	switch s.Type {
+	case "mention":
+		return entity.Mention()
+	case "hashtag":
+		return entity.Hashtag()
+	case "bot_command":
+		return entity.BotCommand()
+	case "url":
+		return entity.URL()
+	case "email":
+		return entity.Email()
+	case "bold":
+		return entity.Bold()
+	case "italic":
+		return entity.Italic()
+	case "code":
+		return entity.Code()
+	case "pre":
+		return entity.Pre(s.Language)
+	case "text_url":
+		return entity.TextURL(s.URL)
+	case "mention_name":
+		if s.UserID == nil {
+			return nil
+		}
+		return entity.MentionName(s.UserID)
+	case "phone":
+		return entity.Phone()
+	case "cashtag":
+		return entity.Cashtag()
+	case "underline":
+		return entity.Underline()
+	case "strike":
+		return entity.Strike()
+	case "bank_card":
+		return entity.BankCard()
+	case "spoiler":
+		return entity.Spoiler()
+	case "custom_emoji":
+		return entity.CustomEmoji(s.DocumentID)
+	case "blockquote":
+		return entity.Blockquote(s.Collapsed)
+	}
+	return nil
+}
+
+// node converts the style to a styled node
+func (s Style) node() *styledNode {
+	n := &styledNode{}
+	if s.Children == nil {
+		n.add(&styledNode{text: s.Text})
+	}
+	for _, c := range s.Children {
+		n.attach(c.node(), c.format())
+	}
+	return n
 }
 
+// Builder builds formatted text out of styles, which can be nested
 type Builder struct {
 	styles []Style
 }
 
+// NewBuilder creates an empty builder
 func NewBuilder() *Builder {
 	return &Builder{}
 }
 
-func (b *Builder) Append(styles ...Style) {
+// Append adds styles to the end of the text
+func (b *Builder) Append(styles ...Style) *Builder {
 	b.styles = append(b.styles, styles...)
+	return b
+}
+
+// Nest appends a style wrapping the styles fn adds, such as bold text inside a link:
+//
+//	b.Nest(Style{Type: "text_url", URL: url}, func(b *Builder) { b.Bold(name) })
+func (b *Builder) Nest(style Style, fn func(b *Builder)) *Builder {
+	inner := NewBuilder()
+	fn(inner)
+	style.Children = inner.styles
+	return b.Append(style)
 }
 
 func (b *Builder) Reset() {
@@ -62,121 +144,93 @@ func (b *Builder) Get(i int) Style {
 	return b.styles[i]
 }
 
+// Build converts the styles to styled text options. Styles of unknown types and mentions
+// without a user are kept as plain text, and styles without any text are left out.
 func (b *Builder) Build() []styling.StyledTextOption {
-	var result []styling.StyledTextOption
-	for _, style := range b.styles {
-		switch style.Type {
-		case "plain":
-			result = append(result, styling.Plain(style.Text))
-		case "bold":
-			result = append(result, styling.Bold(style.Text))
-		case "italic":
-			result = append(result, styling.Italic(style.Text))
-		case "code":
-			result = append(result, styling.Code(style.Text))
-		case "pre":
-			result = append(result, styling.Pre(style.Text, ""))
-		case "text_url":
-			result = append(result, styling.TextURL(style.Text, style.URL))
-		case "mention":
-			result = append(result, styling.Mention(style.Text))
-		case "hashtag":
-			result = append(result, styling.Hashtag(style.Text))
-		case "bot_command":
-			result = append(result, styling.BotCommand(style.Text))
-		case "email":
-			result = append(result, styling.Email(style.Text))
-		case "cashtag":
-			result = append(result, styling.Cashtag(style.Text))
-		case "underline":
-			result = append(result, styling.Underline(style.Text))
-		case "strike":
-			result = append(result, styling.Strike(style.Text))
-		case "bank_card":
-			result = append(result, styling.BankCard(style.Text))
-		case "spoiler":
-			result = append(result, styling.Spoiler(style.Text))
-		case "custom_emoji":
-			result = append(result, styling.CustomEmoji(style.Text, style.DocumentID))
-		case "blockquote":
-			result = append(result, styling.Blockquote(style.Text, style.Collapsed))
-		}
+	root := &styledNode{}
+	for _, s := range b.styles {
+		root.attach(s.node(), s.format())
 	}
-	return result
+	return root.options()
+}
+
+func (b *Builder) Text(text string) *Builder {
+	return b.Append(Style{Type: "plain", Text: text})
 }
 
-func (b *Builder) Text(text string) {
-	b.Append(Style{Type: "plain", Text: text})
+func (b *Builder) Mention(name string) *Builder {
+	return b.Append(Style{Type: "mention", Text: name})
 }
 
-func (b *Builder) Mention(name string) {
-	b.Append(Style{Type: "mention", Text: name})
+func (b *Builder) Hashtag(name string) *Builder {
+	return b.Append(Style{Type: "hashtag", Text: name})
 }
 
-func (b *Builder) Hashtag(name string) {
-	b.Append(Style{Type: "hashtag", Text: name})
+func (b *Builder) BotCommand(name string) *Builder {
+	return b.Append(Style{Type: "bot_command", Text: name})
 }
 
-func (b *Builder) BotCommand(name string) {
-	b.Append(Style{Type: "bot_command", Text: name})
+func (b *Builder) Url(url string) *Builder {
+	return b.Append(Style{Type: "url", Text: url})
 }
 
-func (b *Builder) Url(url string) {
-	b.Append(Style{Type: "url", Text: url})
+func (b *Builder) Email(email string) *Builder {
+	return b.Append(Style{Type: "email", Text: email})
 }
 
-func (b *Builder) Email(email string) {
-	b.Append(Style{Type: "email", Text: email})
+func (b *Builder) Bold(text string) *Builder {
+	return b.Append(Style{Type: "bold", Text: text})
 }
 
-func (b *Builder) Bold(text string) {
-	b.Append(Style{Type: "bold", Text: text})
+func (b *Builder) Italic(text string) *Builder {
+	return b.Append(Style{Type: "italic", Text: text})
 }
 
-func (b *Builder) Italic(text string) {
-	b.Append(Style{Type: "italic", Text: text})
+func (b *Builder) Code(text string) *Builder {
+	return b.Append(Style{Type: "code", Text: text})
 }
 
-func (b *Builder) Code(text string) {
-	b.Append(Style{Type: "code", Text: text})
+func (b *Builder) Pre(text string, language string) *Builder {
+	return b.Append(Style{Type: "pre", Text: text, Language: language})
 }
 
-func (b *Builder) Pre(text string, language string) {
-	b.Append(Style{Type: "pre", Text: text})
+func (b *Builder) TextUrl(text string, url string) *Builder {
+	return b.Append(Style{Type: "text_url", Text: text, URL: url})
 }
 
-func (b *Builder) TextUrl(text string, url string) {
-	b.Append(Style{Type: "text_url", Text: text, URL: url})
+// MentionName links the text to a user, who doesn't need to have a username
+func (b *Builder) MentionName(name string, userId tg.InputUserClass) *Builder {
+	return b.Append(Style{Type: "mention_name", Text: name, UserID: userId})
 }
 
-func (b *Builder) MentionName(name string, userId tg.InputUserClass) {
-	b.Append(Style{Type: "mention_name", Text: name})
+func (b *Builder) Phone(phone string) *Builder {
+	return b.Append(Style{Type: "phone", Text: phone})
 }
 
-func (b *Builder) Cashtag(name string) {
-	b.Append(Style{Type: "cashtag", Text: name})
+func (b *Builder) Cashtag(name string) *Builder {
+	return b.Append(Style{Type: "cashtag", Text: name})
 }
 
-func (b *Builder) Underline(text string) {
-	b.Append(Style{Type: "underline", Text: text})
+func (b *Builder) Underline(text string) *Builder {
+	return b.Append(Style{Type: "underline", Text: text})
 }
 
-func (b *Builder) Strike(text string) {
-	b.Append(Style{Type: "strike", Text: text})
+func (b *Builder) Strike(text string) *Builder {
+	return b.Append(Style{Type: "strike", Text: text})
 }
 
-func (b *Builder) BankCard(text string) {
-	b.Append(Style{Type: "bank_card", Text: text})
+func (b *Builder) BankCard(text string) *Builder {
+	return b.Append(Style{Type: "bank_card", Text: text})
 }
 
-func (b *Builder) Spoiler(text string) {
-	b.Append(Style{Type: "spoiler", Text: text})
+func (b *Builder) Spoiler(text string) *Builder {
+	return b.Append(Style{Type: "spoiler", Text: text})
 }
 
-func (b *Builder) CustomEmoji(text string, documentId int64) {
-	b.Append(Style{Type: "custom_emoji", Text: text, DocumentID: documentId})
+func (b *Builder) CustomEmoji(text string, documentId int64) *Builder {
+	return b.Append(Style{Type: "custom_emoji", Text: text, DocumentID: documentId})
 }
 
-func (b *Builder) Blockquote(text string, collapsed bool) {
-	b.Append(Style{Type: "blockquote", Text: text, Collapsed: collapsed})
+func (b *Builder) Blockquote(text string, collapsed bool) *Builder {
+	return b.Append(Style{Type: "blockquote", Text: text, Collapsed: collapsed})
 }
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestBuilder(t *testing.T) {
+	user := &tg.InputUser{UserID: 42, AccessHash: 7}
+	tests := []struct {
+		name     string
+		build    func(b *Builder)
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			name:     "mention by user",
+			build:    func(b *Builder) { b.Text("hi ").MentionName("Bob", user) },
+			text:     "hi Bob",
+			entities: []tg.MessageEntityClass{&tg.InputMessageEntityMentionName{Offset: 3, Length: 3, UserID: user}},
+		},
+		{
+			name:     "url",
+			build:    func(b *Builder) { b.Url("https://example.com") },
+			text:     "https://example.com",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityURL{Offset: 0, Length: 19}},
+		},
+		{
+			name:     "pre with language",
+			build:    func(b *Builder) { b.Pre("x := 1", "go") },
+			text:     "x := 1",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 6, Language: "go"}},
+		},
+		{
+			name: "bold inside a link",
+			build: func(b *Builder) {
+				b.Nest(Style{Type: "text_url", URL: "https://example.com"}, func(b *Builder) {
+					b.Text("see ").Bold("here")
+				})
+			},
+			text: "see here",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 4, Length: 4},
+				&tg.MessageEntityTextURL{Offset: 0, Length: 8, URL: "https://example.com"},
+			},
+		},
+		{
+			name: "deep nesting",
+			build: func(b *Builder) {
+				b.Text("😀 ").Nest(Style{Type: "blockquote"}, func(b *Builder) {
+					b.Nest(Style{Type: "italic"}, func(b *Builder) {
+						b.Underline("a").Text("b")
+					})
+				})
+			},
+			text: "😀 ab",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityUnderline{Offset: 3, Length: 1},
+				&tg.MessageEntityItalic{Offset: 3, Length: 2},
+				&tg.MessageEntityBlockquote{Offset: 3, Length: 2},
+			},
+		},
+		{
+			name: "unknown types and empty styles",
+			build: func(b *Builder) {
+				b.Append(Style{Type: "sparkle", Text: "a"}).Bold("").MentionName("b", nil)
+			},
+			text: "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder()
+			tt.build(b)
+			text, entities, err := build(b.Build())
+			if err != nil {
+				t.Fatalf("Perform() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("Build() text = %q, want %q", text, tt.text)
+			}
+			if (len(entities) > 0 || len(tt.entities) > 0) && !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("Build() entities = %+v, want %+v", entities, tt.entities)
+			}
+		})
+	}
+}
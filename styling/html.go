@@ -0,0 +1,323 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+)
+
+// ParseHTML parses a string of HTML text into a list of styled text options compatible with
+// gotd's message styling system. Only the tags Telegram supports are accepted:
+//
+//	<b>bold</b>, <strong>bold</strong>
+//	<i>italic</i>, <em>italic</em>
+//	<u>underline</u>, <ins>underline</ins>
+//	<s>strikethrough</s>, <strike>strikethrough</strike>, <del>strikethrough</del>
+//	<span class="tg-spoiler">spoiler</span>, <tg-spoiler>spoiler</tg-spoiler>
+//	<a href="http://www.example.com/">inline URL</a>
+//	<a href="tg://user?id=123456789">inline mention of a user</a>
+//	<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>
+//	<code>inline fixed-width code</code>
+//	<pre>pre-formatted fixed-width code block</pre>
+//	<pre><code class="language-python">pre-formatted fixed-width code block written in the Python programming language</code></pre>
+//	<blockquote>Block quotation</blockquote>
+//	<blockquote expandable>Expandable block quotation</blockquote>
+//
+// Only &lt;, &gt;, &amp; and &quot; are recognized as named character references, along
+// with numeric ones. Any other '&' is kept as is, while '<' must always be escaped. Use
+// html.EscapeString for untrusted text. Links without a href use their text as the URL,
+// and invalid URLs leave the text unlinked. Empty entities are dropped.
+//
+// An error is returned for unsupported tags and tags that aren't closed properly.
+func ParseHTML(text string) ([]styling.StyledTextOption, error) {
+	p := &htmlParser{src: text}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return root.options(), nil
+}
+
+// htmlFrame is a tag that has been opened but not closed yet
+type htmlFrame struct {
+	tag    string
+	offset int // Byte offset of the start tag, for errors
+	attrs  map[string]string
+	merged bool // A code tag giving the language of the pre tag it's in
+	node   *styledNode
+}
+
+type htmlParser struct {
+	src   string
+	i     int
+	buf   strings.Builder // Plain text not yet added to the innermost tag
+	stack []*htmlFrame
+}
+
+func (p *htmlParser) top() *htmlFrame {
+	return p.stack[len(p.stack)-1]
+}
+
+func (p *htmlParser) parse() (*styledNode, error) {
+	p.stack = []*htmlFrame{{node: &styledNode{}}}
+
+	for p.i < len(p.src) {
+		switch c := p.src[p.i]; {
+		case c == '&':
+			p.buf.WriteString(p.reference())
+		case c == '<' && strings.HasPrefix(p.src[p.i:], "</"):
+			if err := p.endTag(); err != nil {
+				return nil, err
+			}
+		case c == '<':
+			if err := p.startTag(); err != nil {
+				return nil, err
+			}
+		default:
+			p.buf.WriteByte(c)
+			p.i++
+		}
+	}
+
+	if len(p.stack) > 1 {
+		f := p.top()
+		return nil, fmt.Errorf("can't find end tag corresponding to start tag <%s> at byte offset %d", f.tag, f.offset)
+	}
+	p.flush()
+	return p.top().node, nil
+}
+
+// reference decodes the character reference at the current position. Unknown references are
+// left as they are.
+func (p *htmlParser) reference() string {
+	rest := p.src[p.i:]
+	end := strings.IndexByte(rest, ';')
+	if end < 0 || end > 10 {
+		p.i++
+		return "&"
+	}
+
+	name := rest[1:end]
+	var decoded string
+	switch {
+	case name == "lt":
+		decoded = "<"
+	case name == "gt":
+		decoded = ">"
+	case name == "amp":
+		decoded = "&"
+	case name == "quot":
+		decoded = "\""
+	case strings.HasPrefix(name, "#x") || strings.HasPrefix(name, "#X"):
+		decoded = codePoint(name[2:], 16)
+	case strings.HasPrefix(name, "#"):
+		decoded = codePoint(name[1:], 10)
+	}
+	if decoded == "" {
+		p.i++
+		return "&"
+	}
+	p.i += end + 1
+	return decoded
+}
+
+// codePoint returns the character with the given code, or an empty string if it isn't valid
+func codePoint(s string, base int) string {
+	code, err := strconv.ParseUint(s, base, 32)
+	if err != nil || code == 0 || !utf8.ValidRune(rune(code)) {
+		return ""
+	}
+	return string(rune(code))
+}
+
+// startTag opens the tag at the current position
+func (p *htmlParser) startTag() error {
+	f := &htmlFrame{offset: p.i, attrs: make(map[string]string)}
+	p.i++
+
+	name := p.name()
+	if name == "" {
+		return fmt.Errorf("character '<' at byte offset %d must be escaped as &lt;", f.offset)
+	}
+	f.tag = strings.ToLower(name)
+
+	for {
+		for p.i < len(p.src) && isSpace(p.src[p.i]) {
+			p.i++
+		}
+		if p.i >= len(p.src) {
+			return fmt.Errorf("can't find end of start tag <%s> at byte offset %d", f.tag, f.offset)
+		}
+		if p.src[p.i] == '>' {
+			p.i++
+			break
+		}
+
+		attr := strings.ToLower(p.name())
+		if attr == "" {
+			return fmt.Errorf("invalid attribute of start tag <%s> at byte offset %d", f.tag, p.i)
+		}
+		value, err := p.attrValue()
+		if err != nil {
+			return err
+		}
+		f.attrs[attr] = value
+	}
+
+	switch f.tag {
+	case "b", "strong", "i", "em", "u", "ins", "s", "strike", "del", "tg-spoiler", "a", "pre", "blockquote":
+	case "span":
+		if f.attrs["class"] != "tg-spoiler" {
+			return fmt.Errorf("tag <span> at byte offset %d must have class \"tg-spoiler\"", f.offset)
+		}
+	case "tg-emoji":
+		if _, err := strconv.ParseInt(f.attrs["emoji-id"], 10, 64); err != nil {
+			return fmt.Errorf("tag <tg-emoji> at byte offset %d must have a numeric emoji-id", f.offset)
+		}
+	case "code":
+		// A code tag directly inside a pre tag gives the language of the block
+		if top := p.top(); top.tag == "pre" && top.node.children == nil && p.buf.Len() == 0 {
+			top.attrs["language"] = strings.TrimPrefix(f.attrs["class"], "language-")
+			f.merged = true
+		}
+	default:
+		return fmt.Errorf("unsupported start tag <%s> at byte offset %d", f.tag, f.offset)
+	}
+
+	p.flush()
+	f.node = &styledNode{}
+	p.stack = append(p.stack, f)
+	return nil
+}
+
+// endTag closes the innermost tag, which must be the one the end tag at the current position
+// names
+func (p *htmlParser) endTag() error {
+	offset := p.i
+	p.i += 2
+	name := strings.ToLower(p.name())
+	for p.i < len(p.src) && isSpace(p.src[p.i]) {
+		p.i++
+	}
+	if p.i >= len(p.src) || p.src[p.i] != '>' {
+		return fmt.Errorf("can't find end of end tag at byte offset %d", offset)
+	}
+	p.i++
+
+	if len(p.stack) == 1 {
+		return fmt.Errorf("unexpected end tag </%s> at byte offset %d", name, offset)
+	}
+	f := p.top()
+	if name != f.tag {
+		return fmt.Errorf("unmatched end tag at byte offset %d, expected </%s>, found </%s>", offset, f.tag, name)
+	}
+
+	p.flush()
+	p.stack = p.stack[:len(p.stack)-1]
+	p.top().node.attach(f.node, p.format(f))
+	return nil
+}
+
+// format returns the formatter of a closed tag
+func (p *htmlParser) format(f *htmlFrame) entity.Formatter {
+	switch f.tag {
+	case "b", "strong":
+		return entity.Bold()
+	case "i", "em":
+		return entity.Italic()
+	case "u", "ins":
+		return entity.Underline()
+	case "s", "strike", "del":
+		return entity.Strike()
+	case "span", "tg-spoiler":
+		return entity.Spoiler()
+	case "a":
+		if href, ok := f.attrs["href"]; ok {
+			return linkFormat(href)
+		}
+		return linkFormat(f.node.plain())
+	case "tg-emoji":
+		id, _ := strconv.ParseInt(f.attrs["emoji-id"], 10, 64)
+		return entity.CustomEmoji(id)
+	case "code":
+		if f.merged {
+			return nil
+		}
+		return entity.Code()
+	case "pre":
+		return entity.Pre(f.attrs["language"])
+	case "blockquote":
+		_, expandable := f.attrs["expandable"]
+		return entity.Blockquote(expandable)
+	}
+	return nil
+}
+
+// name reads a tag or attribute name
+func (p *htmlParser) name() string {
+	start := p.i
+	for p.i < len(p.src) {
+		c := p.src[p.i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			break
+		}
+		p.i++
+	}
+	return p.src[start:p.i]
+}
+
+// attrValue reads the value of an attribute, which is empty for attributes without one
+func (p *htmlParser) attrValue() (string, error) {
+	if p.i >= len(p.src) || p.src[p.i] != '=' {
+		return "", nil
+	}
+	p.i++
+	if p.i >= len(p.src) {
+		return "", fmt.Errorf("can't find attribute value at byte offset %d", p.i)
+	}
+
+	var end func(c byte) bool
+	switch quote := p.src[p.i]; quote {
+	case '"', '\'':
+		p.i++
+		end = func(c byte) bool { return c == quote }
+	default:
+		end = func(c byte) bool { return isSpace(c) || c == '>' }
+	}
+
+	start := p.i
+	var sb strings.Builder
+	for p.i < len(p.src) && !end(p.src[p.i]) {
+		if p.src[p.i] == '&' {
+			sb.WriteString(p.reference())
+			continue
+		}
+		sb.WriteByte(p.src[p.i])
+		p.i++
+	}
+	if p.i >= len(p.src) {
+		return "", fmt.Errorf("can't find end of attribute value at byte offset %d", start)
+	}
+	if c := p.src[p.i]; c == '"' || c == '\'' {
+		p.i++
+	}
+	return sb.String(), nil
+}
+
+// flush adds the buffered plain text to the innermost tag
+func (p *htmlParser) flush() {
+	if p.buf.Len() == 0 {
+		return
+	}
+	p.top().node.add(&styledNode{text: p.buf.String()})
+	p.buf.Reset()
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// renderHTML parses the input and returns the text and entities it produces
+func renderHTML(t *testing.T, input string) (string, []tg.MessageEntityClass, error) {
+	t.Helper()
+	opts, err := ParseHTML(input)
+	if err != nil {
+		return "", nil, err
+	}
+	var b entity.Builder
+	if err := styling.Perform(&b, opts...); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	text, entities := b.Raw()
+	return text, entities, nil
+}
+
+func TestParseHTML(t *testing.T) {
+	// Entities are listed in the order they're closed
+	tests := []struct {
+		name     string
+		input    string
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			name:     "bold",
+			input:    "<b>bold</b>, <strong>bold</strong>",
+			text:     "bold, bold",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 4}, &tg.MessageEntityBold{Offset: 6, Length: 4}},
+		},
+		{
+			name:  "nested styles",
+			input: "<b>bold <i>italic bold <s>strike</s> <u>underline</u></i></b>",
+			text:  "bold italic bold strike underline",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityStrike{Offset: 17, Length: 6},
+				&tg.MessageEntityUnderline{Offset: 24, Length: 9},
+				&tg.MessageEntityItalic{Offset: 5, Length: 28},
+				&tg.MessageEntityBold{Offset: 0, Length: 33},
+			},
+		},
+		{
+			name:  "spoilers",
+			input: `<span class="tg-spoiler">a</span><tg-spoiler>b</tg-spoiler>`,
+			text:  "ab",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntitySpoiler{Offset: 0, Length: 1},
+				&tg.MessageEntitySpoiler{Offset: 1, Length: 1},
+			},
+		},
+		{
+			name:     "link",
+			input:    `<a href="https://example.com/?a=1&amp;b=2">text</a>`,
+			text:     "text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com/?a=1&b=2"}},
+		},
+		{
+			name:     "link without href",
+			input:    "<a>https://example.com</a>",
+			text:     "https://example.com",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 19, URL: "https://example.com"}},
+		},
+		{
+			name:  "user mention",
+			input: "<a href='tg://user?id=123456789'>user</a>",
+			text:  "user",
+			entities: []tg.MessageEntityClass{&tg.InputMessageEntityMentionName{
+				Offset: 0,
+				Length: 4,
+				UserID: &tg.InputUser{UserID: 123456789},
+			}},
+		},
+		{
+			name:     "custom emoji",
+			input:    `<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>`,
+			text:     "👍",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCustomEmoji{Offset: 0, Length: 2, DocumentID: 5368324170671202286}},
+		},
+		{
+			name:     "code",
+			input:    "<code>a &lt; b</code>",
+			text:     "a < b",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCode{Offset: 0, Length: 5}},
+		},
+		{
+			name:     "pre",
+			input:    "<pre>block</pre>",
+			text:     "block",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 5}},
+		},
+		{
+			name:     "pre with language",
+			input:    `<pre><code class="language-python">print("hi")</code></pre>`,
+			text:     `print("hi")`,
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 11, Language: "python"}},
+		},
+		{
+			name:     "blockquote",
+			input:    "<blockquote>quote</blockquote>\nother",
+			text:     "quote\nother",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{Offset: 0, Length: 5}},
+		},
+		{
+			name:     "expandable blockquote",
+			input:    "<blockquote expandable>quote</blockquote>",
+			text:     "quote",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{Offset: 0, Length: 5, Collapsed: true}},
+		},
+		{
+			name:  "character references",
+			input: "&lt;&gt;&amp;&quot;&#65;&#x42; &copy; & AT&T",
+			text:  "<>&\"AB &copy; & AT&T",
+		},
+		{
+			name:  "markdown characters are plain text",
+			input: "*not bold* _not italic_ [not a link]",
+			text:  "*not bold* _not italic_ [not a link]",
+		},
+		{
+			name:  "upper case tags",
+			input: "<B>bold</B>",
+			text:  "bold",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 4},
+			},
+		},
+		{
+			name:  "empty entities are dropped",
+			input: "a<b></b>b",
+			text:  "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := renderHTML(t, tt.input)
+			if err != nil {
+				t.Fatalf("ParseHTML() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("ParseHTML() text = %q, want %q", text, tt.text)
+			}
+			if (len(entities) > 0 || len(tt.entities) > 0) && !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("ParseHTML() entities = %+v, want %+v", entities, tt.entities)
+			}
+		})
+	}
+}
+
+func TestParseHTML_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "unclosed tag",
+			input: "<b>bold",
+			err:   "can't find end tag corresponding to start tag <b> at byte offset 0",
+		},
+		{
+			name:  "mismatched end tag",
+			input: "<b><i>text</b></i>",
+			err:   "expected </i>, found </b>",
+		},
+		{
+			name:  "unexpected end tag",
+			input: "text</b>",
+			err:   "unexpected end tag </b> at byte offset 4",
+		},
+		{
+			name:  "unsupported tag",
+			input: "<div>text</div>",
+			err:   "unsupported start tag <div>",
+		},
+		{
+			name:  "unescaped less-than sign",
+			input: "a < b",
+			err:   "character '<' at byte offset 2 must be escaped",
+		},
+		{
+			name:  "span without spoiler class",
+			input: "<span>text</span>",
+			err:   "must have class \"tg-spoiler\"",
+		},
+		{
+			name:  "custom emoji without ID",
+			input: "<tg-emoji>👍</tg-emoji>",
+			err:   "must have a numeric emoji-id",
+		},
+		{
+			name:  "unclosed attribute",
+			input: `<a href="https://example.com>text</a>`,
+			err:   "can't find end of attribute value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := renderHTML(t, tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseHTML() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -5,13 +5,16 @@
 package styling
 
 import (
-	"io"
-	"strconv"
+	"fmt"
 	"strings"
 
+	"github.com/gotd/td/telegram/message/entity"
 	"github.com/gotd/td/telegram/message/styling"
 )
 
+// reservedMarkdownV2 are the characters that must be escaped outside of entities
+const reservedMarkdownV2 = "_*[]()~`>#+-=|{}.!"
+
 // ParseMarkdownV2 parses a string of Markdown text into a list of styled text options
 // compatible with gotd's message styling system.
 // Example input:
@@ -43,114 +46,441 @@ import (
 //	>Hidden by default part of the expandable block quotation started
 //	>Expandable block quotation continued
 //	>The last line of the expandable block quotation with the expandability mark||
-func ParseMarkdownV2(text string) []styling.StyledTextOption {
-	builder := NewBuilder()
-	reader := NewReader(text)
+//
+// The rules are the ones Telegram applies to MarkdownV2: any character with a code between 1
+// and 126 can be escaped with a preceding '\', and the characters _*[]()~`>#+-=|{}.! must be
+// escaped unless they're part of an entity. Inside code and pre entities only '`' and '\' are
+// special, and inside the URL part of a link only ')' and '\' are. Entities can be nested,
+// with "___" closing an underline before an italic. Links without a URL part use their text
+// as the URL, and invalid URLs leave the text unlinked. Empty entities are dropped.
+//
+// An error is returned for unclosed entities and unescaped reserved characters.
+func ParseMarkdownV2(text string) ([]styling.StyledTextOption, error) {
+	p := &markdownParser{src: text}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		c, _, err := reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			panic(err)
+	return root.options(), nil
+}
+
+// EscapeMarkdownV2 escapes text so ParseMarkdownV2 reads it as plain text
+func EscapeMarkdownV2(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c == '\\' || strings.IndexByte(reservedMarkdownV2, c) >= 0 {
+			b.WriteByte('\\')
 		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
 
-		escaped := false
+// EscapeMarkdownV2Code escapes text so ParseMarkdownV2 reads it as is inside a code or pre entity
+func EscapeMarkdownV2Code(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(text)
+}
 
-		if escaped {
-			builder.Text(string(c))
-			continue
+// markdownKind is the kind of entity a markdown delimiter opens
+type markdownKind int
+
+const (
+	markdownRoot markdownKind = iota
+	markdownBold
+	markdownItalic
+	markdownUnderline
+	markdownStrike
+	markdownSpoiler
+	markdownCode
+	markdownPre
+	markdownLink
+	markdownEmoji
+	markdownQuote
+)
+
+var markdownKindNames = map[markdownKind]string{
+	markdownBold:      "bold",
+	markdownItalic:    "italic",
+	markdownUnderline: "underline",
+	markdownStrike:    "strikethrough",
+	markdownSpoiler:   "spoiler",
+	markdownCode:      "code",
+	markdownPre:       "pre",
+	markdownLink:      "link",
+	markdownEmoji:     "custom emoji",
+	markdownQuote:     "block quotation",
+}
+
+// markdownFrame is an entity that has been opened but not closed yet
+type markdownFrame struct {
+	kind      markdownKind
+	offset    int // Byte offset of the opening delimiter, for errors
+	language  string
+	collapsed bool
+	node      *styledNode
+}
+
+type markdownParser struct {
+	src   string
+	i     int
+	buf   strings.Builder // Plain text not yet added to the innermost entity
+	stack []*markdownFrame
+}
+
+// at returns the byte at the given index, or 0 past the end of the text
+func (p *markdownParser) at(i int) byte {
+	if i < len(p.src) {
+		return p.src[i]
+	}
+	return 0
+}
+
+func (p *markdownParser) top() *markdownFrame {
+	return p.stack[len(p.stack)-1]
+}
+
+// inCode reports whether the innermost entity is code or pre, where nothing nests
+func (p *markdownParser) inCode() bool {
+	kind := p.top().kind
+	return kind == markdownCode || kind == markdownPre
+}
+
+// quote returns the open block quotation, which is always the outermost entity, or nil
+func (p *markdownParser) quote() *markdownFrame {
+	if len(p.stack) > 1 && p.stack[1].kind == markdownQuote {
+		return p.stack[1]
+	}
+	return nil
+}
+
+func (p *markdownParser) parse() (*styledNode, error) {
+	p.stack = []*markdownFrame{{kind: markdownRoot, node: &styledNode{}}}
+
+	lineStart := true
+	for p.i < len(p.src) {
+		if lineStart && !p.inCode() {
+			if err := p.startLine(); err != nil {
+				return nil, err
+			}
+		}
+		lineStart = false
+		if p.i >= len(p.src) {
+			break
 		}
 
-		switch c {
-		case '\\':
-			escaped = true
-			continue
-		case '*', '_', '~', '|':
-			var styleType string
-			var delim string
-			nextByte, err := reader.PeekByte()
-			if err != nil {
-				break // TODO: do this correctly
+		c := p.src[p.i]
+		next := p.at(p.i + 1)
+		switch {
+		case c == '\\' && next > 0 && next <= 126:
+			p.buf.WriteByte(next)
+			p.i += 2
+		case c == '\n' && !p.inCode():
+			if err := p.endLine(); err != nil {
+				return nil, err
 			}
-			switch {
-			case c == '|' && nextByte == '|':
-				reader.ReadByte()
-				styleType = "spoiler"
-				delim = "||"
-			case c == '~' && nextByte == '~':
-				reader.ReadByte()
-				styleType = "strike"
-				delim = "~~"
-			case c == '_':
-				if nextByte == '_' {
-					reader.ReadByte()
-					styleType = "underline"
-					delim = "__"
-				} else {
-					styleType = "italic"
-					delim = "_"
-				}
-			case c == '*':
-				styleType = "bold"
-				delim = "*"
+			lineStart = true
+		case !p.reserved(c):
+			p.buf.WriteByte(c)
+			p.i++
+		default:
+			if err := p.delimiter(); err != nil {
+				return nil, err
 			}
+		}
+	}
+
+	if p.quote() != nil {
+		if err := p.closeQuote(); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.stack) > 1 {
+		return nil, p.unclosed(p.top())
+	}
+	p.flush()
+	return p.top().node, nil
+}
+
+// reserved reports whether a character is a delimiter in the current entity
+func (p *markdownParser) reserved(c byte) bool {
+	if p.inCode() {
+		return c == '`'
+	}
+	return strings.IndexByte(reservedMarkdownV2, c) >= 0
+}
+
+// startLine opens or continues a block quotation at the start of a line
+func (p *markdownParser) startLine() error {
+	rest := p.src[p.i:]
+	switch {
+	case p.quote() != nil:
+		// endLine only keeps the quotation open for lines starting with '>'
+		p.i++
+	case strings.HasPrefix(rest, "**>"):
+		return p.openQuote(true, 3)
+	case strings.HasPrefix(rest, ">"):
+		return p.openQuote(false, 1)
+	}
+	return nil
+}
+
+// endLine adds a line break, closing the block quotation first if the next line doesn't
+// continue it
+func (p *markdownParser) endLine() error {
+	if p.quote() != nil && p.at(p.i+1) != '>' {
+		if err := p.closeQuote(); err != nil {
+			return err
+		}
+	}
+	p.buf.WriteByte('\n')
+	p.i++
+	return nil
+}
 
-			if styleType != "" {
-				contents, err := reader.ReadUntil(delim)
-				if err != nil {
-					break // TODO: do this correctly
-				}
-
-				_, err = reader.Skip(len(delim))
-				if err != nil {
-					break // TODO: do this correctly
-				}
-
-				if styleType == "bold" {
-					builder.Bold(contents)
-				} else if styleType == "italic" {
-					builder.Italic(contents)
-				} else if styleType == "strike" {
-					builder.Strike(contents)
-				} else if styleType == "code" {
-					builder.Code(contents)
-				} else if styleType == "spoiler" {
-					builder.Spoiler(contents)
-				} else if styleType == "underline" {
-					builder.Underline(contents)
-				}
-			} else {
-				builder.Text(string(c))
+func (p *markdownParser) openQuote(collapsed bool, size int) error {
+	if len(p.stack) > 1 {
+		return fmt.Errorf("block quotation at byte offset %d can't start inside a %s entity",
+			p.i, markdownKindNames[p.top().kind])
+	}
+	p.push(&markdownFrame{kind: markdownQuote, offset: p.i, collapsed: collapsed})
+	p.i += size
+	return nil
+}
+
+func (p *markdownParser) closeQuote() error {
+	if p.top().kind != markdownQuote {
+		return p.unclosed(p.top())
+	}
+	p.pop(entity.Blockquote(p.top().collapsed))
+	return nil
+}
+
+// delimiter handles a reserved character, which has to close or open an entity
+func (p *markdownParser) delimiter() error {
+	c, next := p.src[p.i], p.at(p.i+1)
+	top := p.top()
+
+	// The expandability mark ends the line of an expandable block quotation
+	if q := p.quote(); q != nil && q.collapsed && c == '|' && next == '|' && top.kind != markdownSpoiler {
+		if end := p.at(p.i + 2); end == 0 || end == '\n' {
+			if err := p.closeQuote(); err != nil {
+				return err
 			}
-		case '!':
-			if nextByte, err := reader.PeekByte(); err == nil {
-				if nextByte == '[' {
-					// Potential image/emoji link
-					reader.ReadByte()
-					contents, err := reader.ReadUntil("]")
-					if err != nil {
-						break // TODO: do this correctly
-					}
-					_, err = reader.Skip(1)
-					if err != nil {
-						break // TODO: do this correctly
-					}
-					if strings.HasPrefix(contents, "tg://emoji?id=") {
-						// Parse out the emoji ID
-						idStr := strings.TrimPrefix(contents, "tg://emoji?id=")
-						if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
-							builder.CustomEmoji(contents, id)
-							// Skip any following newline
-							if nextByte, err := reader.PeekByte(); err == nil && nextByte == '\n' {
-								reader.ReadByte()
-							}
-							continue
-						}
-					}
-				}
+			p.i += 2
+			return nil
+		}
+	}
+
+	var closes bool
+	switch top.kind {
+	case markdownBold:
+		closes = c == '*'
+	case markdownItalic:
+		closes = c == '_' && next != '_'
+	case markdownUnderline:
+		closes = c == '_' && next == '_'
+	case markdownStrike:
+		closes = c == '~'
+	case markdownSpoiler:
+		closes = c == '|' && next == '|'
+	case markdownCode:
+		closes = c == '`'
+	case markdownPre:
+		closes = strings.HasPrefix(p.src[p.i:], "```")
+	case markdownLink, markdownEmoji:
+		closes = c == ']'
+	}
+	if closes {
+		return p.close()
+	}
+	return p.open()
+}
+
+// open opens the entity started by the delimiter at the current position
+func (p *markdownParser) open() error {
+	c, next := p.src[p.i], p.at(p.i+1)
+	f := &markdownFrame{offset: p.i}
+	if p.inCode() {
+		return p.unescaped(c)
+	}
+
+	switch {
+	case c == '_' && next == '_':
+		f.kind = markdownUnderline
+		p.i += 2
+	case c == '_':
+		f.kind = markdownItalic
+		p.i++
+	case c == '*':
+		f.kind = markdownBold
+		p.i++
+	case c == '~':
+		f.kind = markdownStrike
+		p.i++
+	case c == '|' && next == '|':
+		f.kind = markdownSpoiler
+		p.i += 2
+	case c == '[':
+		f.kind = markdownLink
+		p.i++
+	case c == '!' && next == '[':
+		f.kind = markdownEmoji
+		p.i += 2
+	case strings.HasPrefix(p.src[p.i:], "```"):
+		f.kind = markdownPre
+		p.i += 3
+
+		// A language is only given when something other than the closing delimiter follows it
+		end := p.i
+		for end < len(p.src) && !isSpace(p.src[end]) && p.src[end] != '`' {
+			end++
+		}
+		if end != p.i && end < len(p.src) && p.src[end] != '`' {
+			f.language = p.src[p.i:end]
+			p.i = end
+		}
+
+		// A single line break after the opening delimiter isn't part of the block
+		switch {
+		case strings.HasPrefix(p.src[p.i:], "\r\n"):
+			p.i += 2
+		case p.at(p.i) == '\n' || p.at(p.i) == '\r':
+			p.i++
+		}
+	case c == '`':
+		f.kind = markdownCode
+		p.i++
+	default:
+		return p.unescaped(c)
+	}
+
+	p.push(f)
+	return nil
+}
+
+// close closes the innermost entity at its closing delimiter
+func (p *markdownParser) close() error {
+	f := p.top()
+	switch f.kind {
+	case markdownBold, markdownItalic, markdownStrike, markdownCode:
+		p.i++
+		p.pop(closedFormat(f.kind))
+	case markdownUnderline, markdownSpoiler:
+		p.i += 2
+		p.pop(closedFormat(f.kind))
+	case markdownPre:
+		p.i += 3
+		// Like the line break after the opening delimiter, the one before the closing
+		// delimiter isn't part of the block
+		text := p.buf.String()
+		text = strings.TrimSuffix(text, "\n")
+		text = strings.TrimSuffix(text, "\r")
+		p.buf.Reset()
+		p.buf.WriteString(text)
+		p.pop(entity.Pre(f.language))
+	case markdownLink:
+		p.i++
+		p.flush()
+		link := f.node.plain()
+		if p.at(p.i) == '(' {
+			var err error
+			if link, err = p.url(); err != nil {
+				return err
 			}
 		}
+		p.pop(linkFormat(link))
+	case markdownEmoji:
+		p.i++
+		if p.at(p.i) != '(' {
+			return fmt.Errorf("custom emoji at byte offset %d must be followed by a tg://emoji URL", f.offset)
+		}
+		link, err := p.url()
+		if err != nil {
+			return err
+		}
+		id, ok := linkID(link, "emoji")
+		if !ok {
+			return fmt.Errorf("custom emoji at byte offset %d has an invalid URL %q", f.offset, link)
+		}
+		p.pop(entity.CustomEmoji(id))
+	}
+	return nil
+}
+
+// url reads the URL part of a link, starting at its opening parenthesis
+func (p *markdownParser) url() (string, error) {
+	p.i++
+	start := p.i
+
+	var sb strings.Builder
+	for p.i < len(p.src) && p.src[p.i] != ')' {
+		if next := p.at(p.i + 1); p.src[p.i] == '\\' && next > 0 && next <= 126 {
+			sb.WriteByte(next)
+			p.i += 2
+			continue
+		}
+		sb.WriteByte(p.src[p.i])
+		p.i++
+	}
+	if p.i >= len(p.src) {
+		return "", fmt.Errorf("can't find end of the URL at byte offset %d", start)
+	}
+	p.i++
+	return sb.String(), nil
+}
+
+// push opens an entity, which nested text and entities are added to until it's closed
+func (p *markdownParser) push(f *markdownFrame) {
+	p.flush()
+	f.node = &styledNode{}
+	p.stack = append(p.stack, f)
+}
+
+// pop closes the innermost entity, adding it to the enclosing one
+func (p *markdownParser) pop(format entity.Formatter) {
+	p.flush()
+	f := p.top()
+	p.stack = p.stack[:len(p.stack)-1]
+	p.top().node.attach(f.node, format)
+}
+
+// flush adds the buffered plain text to the innermost entity
+func (p *markdownParser) flush() {
+	if p.buf.Len() == 0 {
+		return
 	}
+	p.top().node.add(&styledNode{text: p.buf.String()})
+	p.buf.Reset()
+}
+
+func (p *markdownParser) unclosed(f *markdownFrame) error {
+	return fmt.Errorf("can't find end of the %s entity at byte offset %d", markdownKindNames[f.kind], f.offset)
+}
+
+func (p *markdownParser) unescaped(c byte) error {
+	return fmt.Errorf("character '%c' at byte offset %d is reserved and must be escaped with a preceding '\\'", c, p.i)
+}
+
+// closedFormat returns the formatter of an entity without arguments
+func closedFormat(kind markdownKind) entity.Formatter {
+	switch kind {
+	case markdownBold:
+		return entity.Bold()
+	case markdownItalic:
+		return entity.Italic()
+	case markdownUnderline:
+		return entity.Underline()
+	case markdownStrike:
+		return entity.Strike()
+	case markdownSpoiler:
+		return entity.Spoiler()
+	case markdownCode:
+		return entity.Code()
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
 }
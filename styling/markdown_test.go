@@ -5,328 +5,424 @@
 
 package styling
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// renderMarkdownV2 parses the input and returns the text and entities it produces
+func renderMarkdownV2(t *testing.T, input string) (string, []tg.MessageEntityClass, error) {
+	t.Helper()
+	opts, err := ParseMarkdownV2(input)
+	if err != nil {
+		return "", nil, err
+	}
+	var b entity.Builder
+	if err := styling.Perform(&b, opts...); err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	text, entities := b.Raw()
+	return text, entities, nil
+}
 
 func TestParseMarkdownV2(t *testing.T) {
+	// Entities are listed in the order they're closed
 	tests := []struct {
 		name     string
 		input    string
-		expected []Style
+		text     string
+		entities []tg.MessageEntityClass
 	}{
 		{
-			name:  "escaped characters in styled text",
-			input: "*bold \\*text*",
-			expected: []Style{
-				{Type: "bold", Text: "bold *text"},
-			},
+			name:     "escaped characters in styled text",
+			input:    "*bold \\*text*",
+			text:     "bold *text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 10}},
 		},
 		{
-			name:  "escaped characters in italic",
-			input: "_italic \\*text_",
-			expected: []Style{
-				{Type: "italic", Text: "italic *text"},
-			},
+			name:     "escaped characters in italic",
+			input:    "_italic \\*text_",
+			text:     "italic *text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityItalic{Offset: 0, Length: 12}},
 		},
 		{
-			name:  "underline",
-			input: "__underline__",
-			expected: []Style{
-				{Type: "underline", Text: "underline"},
-			},
+			name:     "underline",
+			input:    "__underline__",
+			text:     "underline",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityUnderline{Offset: 0, Length: 9}},
 		},
 		{
-			name:  "double pipe spoiler",
-			input: "||spoiler||",
-			expected: []Style{
-				{Type: "spoiler", Text: "spoiler"},
-			},
+			name:     "strike",
+			input:    "~strike~",
+			text:     "strike",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityStrike{Offset: 0, Length: 6}},
+		},
+		{
+			name:     "spoiler",
+			input:    "||spoiler||",
+			text:     "spoiler",
+			entities: []tg.MessageEntityClass{&tg.MessageEntitySpoiler{Offset: 0, Length: 7}},
 		},
 		{
 			name:  "complex nested styles",
 			input: "*bold _italic bold ~italic bold strikethrough ||italic bold strikethrough spoiler||~ __underline italic bold___ bold*",
-			expected: []Style{
-				{Type: "bold", Text: "bold italic bold italic bold strikethrough italic bold strikethrough spoiler underline italic bold bold"},
+			text:  "bold italic bold italic bold strikethrough italic bold strikethrough spoiler underline italic bold bold",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntitySpoiler{Offset: 43, Length: 33},
+				&tg.MessageEntityStrike{Offset: 17, Length: 59},
+				&tg.MessageEntityUnderline{Offset: 77, Length: 21},
+				&tg.MessageEntityItalic{Offset: 5, Length: 93},
+				&tg.MessageEntityBold{Offset: 0, Length: 103},
 			},
 		},
 		{
-			name:  "user mention link",
-			input: "[inline mention of a user](tg://user?id=123456789)",
-			expected: []Style{
-				{Type: "text_url", Text: "inline mention of a user", URL: "tg://user?id=123456789"},
+			name:  "nested styles",
+			input: "*bold _italic_*",
+			text:  "bold italic",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 5, Length: 6},
+				&tg.MessageEntityBold{Offset: 0, Length: 11},
 			},
 		},
 		{
-			name:  "emoji link",
-			input: "![👍](tg://emoji?id=5368324170671202286)",
-			expected: []Style{
-				{Type: "text_url", Text: "👍", URL: "tg://emoji?id=5368324170671202286"},
+			name:  "italic and underline separated by a carriage return",
+			input: "___italic underline_\r__",
+			text:  "italic underline\r",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 0, Length: 16},
+				&tg.MessageEntityUnderline{Offset: 0, Length: 17},
 			},
 		},
 		{
-			name:  "code block with language",
-			input: "```python\npre-formatted fixed-width code block written in the Python programming language\n```",
-			expected: []Style{
-				{Type: "pre", Text: "pre-formatted fixed-width code block written in the Python programming language"},
-			},
+			name:     "link",
+			input:    "[text](https://example.com)",
+			text:     "text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com"}},
 		},
 		{
-			name:  "expandable block quote",
-			input: "**>The expandable block quotation started\n>Hidden by default part\n>The last line||",
-			expected: []Style{
-				{Type: "blockquote", Text: "The expandable block quotation started\nHidden by default part\nThe last line", Collapsed: true},
-			},
+			name:     "escaped parenthesis in URL",
+			input:    "[text](https://example.com/a\\)b)",
+			text:     "text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com/a)b"}},
 		},
 		{
-			name:  "plain text",
-			input: "Hello world",
-			expected: []Style{
-				{Type: "plain", Text: "Hello world"},
-			},
+			name:     "link without URL",
+			input:    "[https://example\\.com]",
+			text:     "https://example.com",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 19, URL: "https://example.com"}},
 		},
 		{
-			name:  "bold text",
-			input: "*bold*",
-			expected: []Style{
-				{Type: "bold", Text: "bold"},
-			},
+			name:  "invalid URL",
+			input: "[text](%zz)",
+			text:  "text",
 		},
 		{
-			name:  "italic text",
-			input: "_italic_",
-			expected: []Style{
-				{Type: "italic", Text: "italic"},
-			},
+			name:  "user mention link",
+			input: "[inline mention of a user](tg://user?id=123456789)",
+			text:  "inline mention of a user",
+			entities: []tg.MessageEntityClass{&tg.InputMessageEntityMentionName{
+				Offset: 0,
+				Length: 24,
+				UserID: &tg.InputUser{UserID: 123456789},
+			}},
 		},
 		{
-			name:  "strike text",
-			input: "~strike~",
-			expected: []Style{
-				{Type: "strike", Text: "strike"},
-			},
+			name:     "custom emoji",
+			input:    "![👍](tg://emoji?id=5368324170671202286)",
+			text:     "👍",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCustomEmoji{Offset: 0, Length: 2, DocumentID: 5368324170671202286}},
 		},
 		{
-			name:  "code text",
-			input: "`code`",
-			expected: []Style{
-				{Type: "code", Text: "code"},
-			},
+			name:     "code",
+			input:    "`code with *reserved* characters\\.`",
+			text:     "code with *reserved* characters.",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCode{Offset: 0, Length: 32}},
 		},
 		{
-			name:  "link",
-			input: "[text](https://example.com)",
-			expected: []Style{
-				{Type: "text_url", Text: "text", URL: "https://example.com"},
-			},
+			name:     "escaped backtick in code",
+			input:    "`a \\` b`",
+			text:     "a ` b",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCode{Offset: 0, Length: 5}},
 		},
 		{
-			name:  "blockquote",
-			input: ">quoted text\nother text",
-			expected: []Style{
-				{Type: "blockquote", Text: "quoted text", Collapsed: false},
-				{Type: "plain", Text: "other text"},
-			},
+			name:  "code block with language",
+			input: "```python\npre-formatted fixed-width code block written in the Python programming language\n```",
+			text:  "pre-formatted fixed-width code block written in the Python programming language",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{
+				Offset:   0,
+				Length:   79,
+				Language: "python",
+			}},
+		},
+		{
+			name:     "inline code block",
+			input:    "```code```",
+			text:     "code",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 4}},
+		},
+		{
+			name:     "blockquote",
+			input:    ">quoted text\nother text",
+			text:     "quoted text\nother text",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{Offset: 0, Length: 11}},
+		},
+		{
+			name:  "expandable block quote",
+			input: "**>The expandable block quotation started\n>Hidden by default part\n>The last line||",
+			text:  "The expandable block quotation started\nHidden by default part\nThe last line",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBlockquote{
+				Offset:    0,
+				Length:    75,
+				Collapsed: true,
+			}},
 		},
 		{
-			name:  "spoiler",
-			input: "||spoiler||",
-			expected: []Style{
-				{Type: "spoiler", Text: "spoiler"},
+			name:  "styles in block quote",
+			input: ">*bold*\n>||spoiler||",
+			text:  "bold\nspoiler",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 4},
+				&tg.MessageEntitySpoiler{Offset: 5, Length: 7},
+				&tg.MessageEntityBlockquote{Offset: 0, Length: 12},
 			},
 		},
+		{
+			name:  "plain text",
+			input: "Hello world",
+			text:  "Hello world",
+		},
 		{
 			name:  "escaped characters",
 			input: "\\*not bold\\* \\[not link\\]",
-			expected: []Style{
-				{Type: "plain", Text: "*not bold* [not link]"},
-			},
+			text:  "*not bold* [not link]",
+		},
+		{
+			name:  "empty entities are dropped",
+			input: "a**b",
+			text:  "ab",
 		},
 		{
 			name:  "mixed styling",
 			input: "Hello *bold* and _italic_ and `code`",
-			expected: []Style{
-				{Type: "plain", Text: "Hello "},
-				{Type: "bold", Text: "bold"},
-				{Type: "plain", Text: " and "},
-				{Type: "italic", Text: "italic"},
-				{Type: "plain", Text: " and "},
-				{Type: "code", Text: "code"},
+			text:  "Hello bold and italic and code",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 6, Length: 4},
+				&tg.MessageEntityItalic{Offset: 15, Length: 6},
+				&tg.MessageEntityCode{Offset: 26, Length: 4},
 			},
 		},
+		{
+			name:  "offsets count UTF-16 code units",
+			input: "😀 *bold*",
+			text:  "😀 bold",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 3, Length: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := renderMarkdownV2(t, tt.input)
+			if err != nil {
+				t.Fatalf("ParseMarkdownV2() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("ParseMarkdownV2() text = %q, want %q", text, tt.text)
+			}
+			if (len(entities) > 0 || len(tt.entities) > 0) && !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("ParseMarkdownV2() entities = %+v, want %+v", entities, tt.entities)
+			}
+		})
+	}
+}
+
+func TestParseMarkdownV2_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
 		{
 			name:  "unclosed styles",
 			input: "*bold text without end",
-			expected: []Style{
-				{Type: "plain", Text: "*bold text without end"},
-			},
+			err:   "can't find end of the bold entity at byte offset 0",
 		},
 		{
-			name:  "nested styles are treated as plain text",
-			input: "*bold _italic_*",
-			expected: []Style{
-				{Type: "bold", Text: "bold _italic_"},
-			},
+			name:  "unclosed code",
+			input: "text `code",
+			err:   "can't find end of the code entity at byte offset 5",
 		},
 		{
 			name:  "invalid link format",
 			input: "[text](invalid url",
-			expected: []Style{
-				{Type: "plain", Text: "[text](invalid url"},
-			},
+			err:   "can't find end of the URL at byte offset 7",
+		},
+		{
+			name:  "unescaped reserved character",
+			input: "Hello world.",
+			err:   "character '.' at byte offset 11 is reserved",
+		},
+		{
+			name:  "quote character inside a line",
+			input: "a > b",
+			err:   "character '>' at byte offset 2 is reserved",
+		},
+		{
+			name:  "ambiguous italic and underline",
+			input: "___italic underline___",
+			err:   "can't find end of the italic entity",
+		},
+		{
+			name:  "custom emoji without URL",
+			input: "![👍]",
+			err:   "must be followed by a tg://emoji URL",
+		},
+		{
+			name:  "custom emoji with another URL",
+			input: "![👍](https://example.com)",
+			err:   "has an invalid URL",
+		},
+		{
+			name:  "block quote inside another entity",
+			input: "*bold\n>quote*",
+			err:   "block quotation at byte offset 6 can't start inside a bold entity",
+		},
+		{
+			name:  "entity crossing the end of a block quote",
+			input: ">*quote\nnot bold*",
+			err:   "can't find end of the bold entity at byte offset 1",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			builder := NewBuilder()
-			for _, style := range tt.expected {
-				builder.Append(style)
+			_, _, err := renderMarkdownV2(t, tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseMarkdownV2() error = %v, want %q", err, tt.err)
 			}
-			got := ParseMarkdownV2(tt.input)
-			gotStyles := builder.styles
+		})
+	}
+}
 
-			if len(got) != len(tt.expected) {
-				t.Errorf("ParseMarkdownV2() returned %d styles, want %d", len(got), len(tt.expected))
-				return
-			}
+func TestParseMarkdownV2_RealWorldExamples(t *testing.T) {
+	input := "*bold \\*text*\n" +
+		"_italic \\*text_\n" +
+		"__underline__\n" +
+		"~strikethrough~\n" +
+		"||spoiler||\n" +
+		"*bold _italic bold ~italic bold strikethrough ||italic bold strikethrough spoiler||~ __underline italic bold___ bold*\n" +
+		"[inline URL](http://www.example.com/)\n" +
+		"[inline mention of a user](tg://user?id=123456789)\n" +
+		"![👍](tg://emoji?id=5368324170671202286)\n" +
+		"`inline fixed-width code`\n" +
+		"```\npre-formatted fixed-width code block\n```\n" +
+		"```python\npre-formatted fixed-width code block written in the Python programming language\n```\n" +
+		">Block quotation started\n" +
+		">Block quotation continued\n" +
+		">Block quotation continued\n" +
+		">Block quotation continued\n" +
+		">The last line of the block quotation\n" +
+		"**>The expandable block quotation started right after the previous block quotation\n" +
+		">It is separated from the previous block quotation by an empty bold entity\n" +
+		">Expandable block quotation continued\n" +
+		">Hidden by default part of the expandable block quotation started\n" +
+		">Expandable block quotation continued\n" +
+		">The last line of the expandable block quotation with the expandability mark||"
+
+	// Each line of the input becomes a line of text, in which the styled part is checked
+	expected := []struct {
+		text   string
+		entity tg.MessageEntityClass
+	}{
+		{"bold *text", &tg.MessageEntityBold{}},
+		{"italic *text", &tg.MessageEntityItalic{}},
+		{"underline", &tg.MessageEntityUnderline{}},
+		{"strikethrough", &tg.MessageEntityStrike{}},
+		{"spoiler", &tg.MessageEntitySpoiler{}},
+		{"bold italic bold italic bold strikethrough italic bold strikethrough spoiler underline italic bold bold", &tg.MessageEntityBold{}},
+		{"inline URL", &tg.MessageEntityTextURL{URL: "http://www.example.com/"}},
+		{"inline mention of a user", &tg.InputMessageEntityMentionName{UserID: &tg.InputUser{UserID: 123456789}}},
+		{"👍", &tg.MessageEntityCustomEmoji{DocumentID: 5368324170671202286}},
+		{"inline fixed-width code", &tg.MessageEntityCode{}},
+		{"pre-formatted fixed-width code block", &tg.MessageEntityPre{}},
+		{"pre-formatted fixed-width code block written in the Python programming language", &tg.MessageEntityPre{Language: "python"}},
+		{"Block quotation started\nBlock quotation continued\nBlock quotation continued\nBlock quotation continued\nThe last line of the block quotation", &tg.MessageEntityBlockquote{}},
+		{"The expandable block quotation started right after the previous block quotation\nIt is separated from the previous block quotation by an empty bold entity\nExpandable block quotation continued\nHidden by default part of the expandable block quotation started\nExpandable block quotation continued\nThe last line of the expandable block quotation with the expandability mark", &tg.MessageEntityBlockquote{Collapsed: true}},
+	}
+
+	text, entities, err := renderMarkdownV2(t, input)
+	if err != nil {
+		t.Fatalf("ParseMarkdownV2() error = %v", err)
+	}
 
-			for i, style := range tt.expected {
-				if i >= len(gotStyles) {
-					t.Errorf("Missing expected style at index %d: %+v", i, style)
-					continue
-				}
-				if gotStyles[i].Type != style.Type {
-					t.Errorf("Style[%d].Type = %q, want %q", i, gotStyles[i].Type, style.Type)
-				}
-				if gotStyles[i].Text != style.Text {
-					t.Errorf("Style[%d].Text = %q, want %q", i, gotStyles[i].Text, style.Text)
-				}
-				if gotStyles[i].URL != style.URL {
-					t.Errorf("Style[%d].URL = %q, want %q", i, gotStyles[i].URL, style.URL)
-				}
-				if gotStyles[i].Collapsed != style.Collapsed {
-					t.Errorf("Style[%d].Collapsed = %v, want %v", i, gotStyles[i].Collapsed, style.Collapsed)
-				}
+	var lines []string
+	for _, e := range expected {
+		lines = append(lines, e.text)
+	}
+	if want := strings.Join(lines, "\n"); text != want {
+		t.Fatalf("ParseMarkdownV2() text = %q, want %q", text, want)
+	}
+
+	// The outermost entity of each line is the one closed last
+	offset := 0
+	for _, e := range expected {
+		length := entity.ComputeLength(e.text)
+		var found tg.MessageEntityClass
+		for _, got := range entities {
+			if got.GetOffset() == offset && got.GetLength() == length {
+				found = got
 			}
-		})
+		}
+		if found == nil {
+			t.Errorf("no entity covers %q", e.text)
+		} else if reflect.TypeOf(found) != reflect.TypeOf(e.entity) {
+			t.Errorf("entity of %q is %T, want %T", e.text, found, e.entity)
+		} else {
+			// Compare everything but the position, which was checked already
+			reflect.ValueOf(e.entity).Elem().FieldByName("Offset").SetInt(int64(offset))
+			reflect.ValueOf(e.entity).Elem().FieldByName("Length").SetInt(int64(length))
+			if !reflect.DeepEqual(found, e.entity) {
+				t.Errorf("entity of %q = %+v, want %+v", e.text, found, e.entity)
+			}
+		}
+		offset += length + 1
 	}
 }
 
-func TestParseMarkdownV2_RealWorldExamples(t *testing.T) {
+func TestEscapeMarkdownV2(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    string
-		expected []Style
+		name string
+		text string
 	}{
-		{
-			name: "full markdown example",
-			input: "*bold \\*text*\n" +
-				"_italic \\*text_\n" +
-				"__underline__\n" +
-				"~~strikethrough~~\n" +
-				"||spoiler||\n" +
-				"*bold _italic bold ~italic bold strikethrough ||italic bold strikethrough spoiler||~ __underline italic bold___ bold*\n" +
-				"[inline URL](http://www.example.com/)\n" +
-				"[inline mention of a user](tg://user?id=123456789)\n" +
-				"![👍](tg://emoji?id=5368324170671202286)\n" +
-				"`inline fixed-width code`\n" +
-				"```\npre-formatted fixed-width code block\n```\n" +
-				"```python\npre-formatted fixed-width code block written in the Python programming language\n```\n" +
-				">Block quotation started\n" +
-				">Block quotation continued\n" +
-				">Block quotation continued\n" +
-				">Block quotation continued\n" +
-				">The last line of the block quotation\n" +
-				"**>The expandable block quotation started right after the previous block quotation\n" +
-				">It is separated from the previous block quotation by an empty bold entity\n" +
-				">Expandable block quotation continued\n" +
-				">Hidden by default part of the expandable block quotation started\n" +
-				">Expandable block quotation continued\n" +
-				">The last line of the expandable block quotation with the expandability mark||",
-			expected: []Style{
-				{Type: "bold", Text: "bold *text"},
-				{Type: "plain", Text: "\n"},
-				{Type: "italic", Text: "italic *text"},
-				{Type: "plain", Text: "\n"},
-				{Type: "underline", Text: "underline"},
-				{Type: "plain", Text: "\n"},
-				{Type: "strike", Text: "strikethrough"},
-				{Type: "plain", Text: "\n"},
-				{Type: "spoiler", Text: "spoiler"},
-				{Type: "plain", Text: "\n"},
-				{Type: "bold", Text: "bold italic bold italic bold strikethrough italic bold strikethrough spoiler underline italic bold bold"},
-				{Type: "plain", Text: "\n"},
-				{Type: "text_url", Text: "inline URL", URL: "http://www.example.com/"},
-				{Type: "plain", Text: "\n"},
-				{Type: "text_url", Text: "inline mention of a user", URL: "tg://user?id=123456789"},
-				{Type: "plain", Text: "\n"},
-				{Type: "text_url", Text: "👍", URL: "tg://emoji?id=5368324170671202286"},
-				{Type: "plain", Text: "\n"},
-				{Type: "code", Text: "inline fixed-width code"},
-				{Type: "plain", Text: "\n"},
-				{Type: "pre", Text: "pre-formatted fixed-width code block"},
-				{Type: "plain", Text: "\n"},
-				{Type: "pre", Text: "pre-formatted fixed-width code block written in the Python programming language"},
-				{Type: "plain", Text: "\n"},
-				{Type: "blockquote", Text: "Block quotation started\nBlock quotation continued\nBlock quotation continued\nBlock quotation continued\nThe last line of the block quotation", Collapsed: false},
-				{Type: "plain", Text: "\n"},
-				{Type: "blockquote", Text: "The expandable block quotation started right after the previous block quotation\nIt is separated from the previous block quotation by an empty bold entity\nExpandable block quotation continued\nHidden by default part of the expandable block quotation started\nExpandable block quotation continued\nThe last line of the expandable block quotation with the expandability mark", Collapsed: true},
-			},
-		},
-		// {
-		// 	name: "complex message",
-		// 	input: "Hello! Here's a *bold announcement*:\n" +
-		// 		">Important message for everyone\n" +
-		// 		"Check out our website [here](https://example.com)\n" +
-		// 		"Use code `SAVE20` for a _20%_ discount!\n" +
-		// 		"||Secret: launch date is tomorrow!||",
-		// 	expected: []Style{
-		// 		{Type: "plain", Text: "Hello! Here's a "},
-		// 		{Type: "bold", Text: "bold announcement"},
-		// 		{Type: "plain", Text: ":\n"},
-		// 		{Type: "blockquote", Text: "Important message for everyone", Collapsed: false},
-		// 		{Type: "plain", Text: "Check out our website "},
-		// 		{Type: "text_url", Text: "here", URL: "https://example.com"},
-		// 		{Type: "plain", Text: "\nUse code "},
-		// 		{Type: "code", Text: "SAVE20"},
-		// 		{Type: "plain", Text: " for a "},
-		// 		{Type: "italic", Text: "20%"},
-		// 		{Type: "plain", Text: " discount!\n"},
-		// 		{Type: "spoiler", Text: "Secret: launch date is tomorrow!"},
-		// 	},
-		// },
+		{"plain text", "hello world"},
+		{"reserved characters", `_*[]()~` + "`" + `>#+-=|{}.!`},
+		{"backslashes", `C:\path\`},
+		{"text around markup", "*not bold* 1.5 > 1"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			builder := NewBuilder()
-			for _, style := range tt.expected {
-				builder.Append(style)
+			text, entities, err := renderMarkdownV2(t, EscapeMarkdownV2(tt.text))
+			if err != nil {
+				t.Fatalf("ParseMarkdownV2() error = %v", err)
 			}
-			got := ParseMarkdownV2(tt.input)
-			gotStyles := builder.styles
-
-			if len(got) != len(tt.expected) {
-				t.Errorf("ParseMarkdownV2() returned %d styles, want %d", len(got), len(tt.expected))
-				return
+			if text != tt.text || len(entities) != 0 {
+				t.Errorf("ParseMarkdownV2() = %q, %+v, want %q without entities", text, entities, tt.text)
 			}
 
-			for i, style := range tt.expected {
-				if i >= len(gotStyles) {
-					t.Errorf("Missing expected style at index %d: %+v", i, style)
-					continue
-				}
-				if gotStyles[i].Type != style.Type {
-					t.Errorf("Style[%d].Type = %q, want %q", i, gotStyles[i].Type, style.Type)
-				}
-				if gotStyles[i].Text != style.Text {
-					t.Errorf("Style[%d].Text = %q, want %q", i, gotStyles[i].Text, style.Text)
-				}
-				if gotStyles[i].URL != style.URL {
-					t.Errorf("Style[%d].URL = %q, want %q", i, gotStyles[i].URL, style.URL)
-				}
-				if gotStyles[i].Collapsed != style.Collapsed {
-					t.Errorf("Style[%d].Collapsed = %v, want %v", i, gotStyles[i].Collapsed, style.Collapsed)
-				}
+			code := "`" + EscapeMarkdownV2Code(tt.text) + "`"
+			text, entities, err = renderMarkdownV2(t, code)
+			if err != nil {
+				t.Fatalf("ParseMarkdownV2() of code error = %v", err)
+			}
+			if text != tt.text || len(entities) != 1 {
+				t.Errorf("ParseMarkdownV2() of code = %q, %+v, want %q in a code entity", text, entities, tt.text)
 			}
 		})
 	}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// styledNode is a run of plain text, or an entity wrapping other nodes. The parsers build a
+// tree of them, so nested entities keep their own offsets.
+type styledNode struct {
+	format   entity.Formatter // nil for plain text
+	text     string
+	children []*styledNode
+}
+
+// add appends a child node, merging runs of plain text
+func (n *styledNode) add(c *styledNode) {
+	if k := len(n.children); k > 0 && c.format == nil && n.children[k-1].format == nil {
+		n.children[k-1] = &styledNode{text: n.children[k-1].text + c.text}
+		return
+	}
+	n.children = append(n.children, c)
+}
+
+// attach adds a finished entity with the given format. Empty entities are dropped, and a nil
+// format adds the nested text and entities unformatted.
+func (n *styledNode) attach(c *styledNode, format entity.Formatter) {
+	switch {
+	case c.plain() == "":
+	case format == nil:
+		for _, child := range c.children {
+			n.add(child)
+		}
+	default:
+		c.format = format
+		n.children = append(n.children, c)
+	}
+}
+
+// plain returns the text of the node without any formatting
+func (n *styledNode) plain() string {
+	if n.format == nil && n.children == nil {
+		return n.text
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(c.plain())
+	}
+	return sb.String()
+}
+
+// render writes the node to the builder, applying its entity after the nested ones
+func (n *styledNode) render(eb *entity.Builder) {
+	if n.format == nil {
+		eb.Plain(n.text)
+		return
+	}
+	tok := eb.Token()
+	for _, c := range n.children {
+		c.render(eb)
+	}
+	tok.Apply(eb, n.format)
+}
+
+// options converts the children of a root node to styled text options, one per top-level
+// run of text or entity
+func (n *styledNode) options() []styling.StyledTextOption {
+	opts := make([]styling.StyledTextOption, 0, len(n.children))
+	for _, c := range n.children {
+		if c.format == nil {
+			opts = append(opts, styling.Plain(c.text))
+			continue
+		}
+		opts = append(opts, styling.Custom(func(eb *entity.Builder) error {
+			c.render(eb)
+			return nil
+		}))
+	}
+	return opts
+}
+
+// linkFormat returns the formatter of a link, which mentions a user for tg://user links. Links
+// that aren't valid URLs aren't formatted.
+func linkFormat(link string) entity.Formatter {
+	if id, ok := linkID(link, "user"); ok {
+		return entity.MentionName(&tg.InputUser{UserID: id})
+	}
+	if _, err := url.Parse(link); err != nil || link == "" {
+		return nil
+	}
+	return entity.TextURL(link)
+}
+
+// linkID returns the ID of a tg:// link with the given host, such as tg://user?id=123
+func linkID(link, host string) (int64, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "tg" || u.Host != host {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(u.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,488 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// Format is a markup language formatted text can be rendered to
+type Format string
+
+const (
+	FormatMarkdownV2 Format = "markdownv2" // Telegram's MarkdownV2, as read by ParseMarkdownV2
+	FormatHTML       Format = "html"       // Telegram's HTML subset, as read by ParseHTML
+	FormatMarkdown   Format = "markdown"   // Common Markdown, for use outside of Telegram
+)
+
+// Formats lists the formats text can be rendered to
+var Formats = []string{string(FormatMarkdownV2), string(FormatHTML), string(FormatMarkdown)}
+
+// Render converts the text and entities of a message to markup in the given format, so that
+// parsing it again gives the same message. Entity offsets and lengths are counted in UTF-16
+// code units, like Telegram does. Entities that overlap without nesting are split, and
+// entities the format has no markup for are left out. Unknown formats give the text as is.
+func Render(text string, entities []tg.MessageEntityClass, format Format) string {
+	var w markupWriter
+	switch format {
+	case FormatMarkdownV2:
+		w = &markdownV2Writer{}
+	case FormatHTML:
+		w = &htmlWriter{}
+	case FormatMarkdown:
+		w = &markdownWriter{}
+	default:
+		return text
+	}
+
+	index := utf16Index(text)
+	writeMarkup(w, text, index, entities, 0, len(index)-1)
+	return w.String()
+}
+
+// writeMarkup writes the text between two UTF-16 offsets to the writer, along with the parts of
+// the entities that fall inside it. The index maps UTF-16 offsets to byte offsets.
+func writeMarkup(w markupWriter, text string, index []int, entities []tg.MessageEntityClass, from, to int) {
+	// Outer entities are opened first
+	type span struct {
+		entity     tg.MessageEntityClass
+		start, end int
+	}
+	var spans []span
+	bounds := []int{from, to}
+	for _, e := range entities {
+		start := clamp(e.GetOffset(), from, to)
+		end := clamp(e.GetOffset()+e.GetLength(), start, to)
+		if start == end || !w.supports(e) {
+			continue
+		}
+		spans = append(spans, span{e, start, end})
+		bounds = append(bounds, start, end)
+	}
+	sort.SliceStable(spans, func(a, b int) bool {
+		if spans[a].start != spans[b].start {
+			return spans[a].start < spans[b].start
+		}
+		return spans[a].end > spans[b].end
+	})
+	sort.Ints(bounds)
+	bounds = slices.Compact(bounds)
+
+	var stack []span
+	next := 0
+	for i, pos := range bounds {
+		// Entities ending here are closed, along with the ones nested in them that go on, which
+		// are opened again afterwards
+		var reopen []span
+		for {
+			ending := false
+			for _, s := range stack {
+				ending = ending || s.end == pos
+			}
+			if !ending {
+				break
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			w.close(top.entity)
+			if top.end != pos {
+				reopen = append(reopen, top)
+			}
+		}
+		for j := len(reopen) - 1; j >= 0; j-- {
+			w.open(reopen[j].entity)
+			stack = append(stack, reopen[j])
+		}
+
+		for ; next < len(spans) && spans[next].start == pos; next++ {
+			w.open(spans[next].entity)
+			stack = append(stack, spans[next])
+		}
+
+		if i+1 < len(bounds) {
+			w.text(text[index[pos]:index[bounds[i+1]]])
+		}
+	}
+}
+
+// EntityText returns the part of the text an entity covers
+func EntityText(text string, e tg.MessageEntityClass) string {
+	index := utf16Index(text)
+	size := len(index) - 1
+	start := clamp(e.GetOffset(), 0, size)
+	end := clamp(e.GetOffset()+e.GetLength(), start, size)
+	return text[index[start]:index[end]]
+}
+
+// utf16Index maps the UTF-16 offsets of a text to byte offsets. Offsets inside a surrogate
+// pair map to the start of its character.
+func utf16Index(text string) []int {
+	index := make([]int, 0, len(text)+1)
+	for i, r := range text {
+		index = append(index, i)
+		if utf16.RuneLen(r) == 2 {
+			index = append(index, i)
+		}
+	}
+	return append(index, len(text))
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+// markupWriter writes text and the markup of entities in one format
+type markupWriter interface {
+	supports(e tg.MessageEntityClass) bool
+	open(e tg.MessageEntityClass)
+	close(e tg.MessageEntityClass)
+	text(s string)
+	String() string
+}
+
+// markupStack keeps track of the entities a writer has open
+type markupStack []tg.MessageEntityClass
+
+func (s *markupStack) push(e tg.MessageEntityClass) {
+	*s = append(*s, e)
+}
+
+func (s *markupStack) pop() {
+	*s = (*s)[:len(*s)-1]
+}
+
+// inCode reports whether the innermost entity is code or pre, where text isn't escaped
+func (s markupStack) inCode() bool {
+	if len(s) == 0 {
+		return false
+	}
+	switch s[len(s)-1].(type) {
+	case *tg.MessageEntityCode, *tg.MessageEntityPre:
+		return true
+	}
+	return false
+}
+
+// quote returns the innermost block quotation, or nil
+func (s markupStack) quote() *tg.MessageEntityBlockquote {
+	for i := len(s) - 1; i >= 0; i-- {
+		if q, ok := s[i].(*tg.MessageEntityBlockquote); ok {
+			return q
+		}
+	}
+	return nil
+}
+
+// mentionedUser returns the ID of the user a mention entity links to
+func mentionedUser(e tg.MessageEntityClass) (int64, bool) {
+	switch e := e.(type) {
+	case *tg.MessageEntityMentionName:
+		return e.UserID, true
+	case *tg.InputMessageEntityMentionName:
+		if user, ok := e.UserID.(*tg.InputUser); ok {
+			return user.UserID, true
+		}
+	}
+	return 0, false
+}
+
+func isBold(e tg.MessageEntityClass) bool {
+	_, ok := e.(*tg.MessageEntityBold)
+	return ok
+}
+
+func userLink(id int64) string {
+	return "tg://user?id=" + strconv.FormatInt(id, 10)
+}
+
+type markdownV2Writer struct {
+	strings.Builder
+	stack markupStack
+
+	// underscore is whether the last delimiter written ends with '_', which would run together
+	// with the next one if it starts with '_'
+	underscore bool
+}
+
+func (w *markdownV2Writer) supports(e tg.MessageEntityClass) bool {
+	return telegramMarkup(e)
+}
+
+// telegramMarkup reports whether Telegram's markup formats can express an entity. The others
+// are detected by Telegram from the text itself.
+func telegramMarkup(e tg.MessageEntityClass) bool {
+	switch e.(type) {
+	case *tg.MessageEntityBold, *tg.MessageEntityItalic, *tg.MessageEntityUnderline,
+		*tg.MessageEntityStrike, *tg.MessageEntitySpoiler, *tg.MessageEntityCode,
+		*tg.MessageEntityPre, *tg.MessageEntityTextURL, *tg.MessageEntityCustomEmoji,
+		*tg.MessageEntityBlockquote:
+		return true
+	}
+	_, ok := mentionedUser(e)
+	return ok
+}
+
+func (w *markdownV2Writer) open(e tg.MessageEntityClass) {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.delimiter("*")
+	case *tg.MessageEntityItalic:
+		w.delimiter("_")
+	case *tg.MessageEntityUnderline:
+		w.delimiter("__")
+	case *tg.MessageEntityStrike:
+		w.delimiter("~")
+	case *tg.MessageEntitySpoiler:
+		w.delimiter("||")
+	case *tg.MessageEntityCode:
+		w.delimiter("`")
+	case *tg.MessageEntityPre:
+		w.delimiter("```" + e.Language + "\n")
+	case *tg.MessageEntityCustomEmoji:
+		w.delimiter("![")
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			w.delimiter("**>")
+		} else {
+			w.delimiter(">")
+		}
+	default:
+		w.delimiter("[")
+	}
+	w.stack.push(e)
+}
+
+func (w *markdownV2Writer) close(e tg.MessageEntityClass) {
+	w.stack.pop()
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.delimiter("*")
+	case *tg.MessageEntityItalic:
+		w.delimiter("_")
+	case *tg.MessageEntityUnderline:
+		w.delimiter("__")
+	case *tg.MessageEntityStrike:
+		w.delimiter("~")
+	case *tg.MessageEntitySpoiler:
+		w.delimiter("||")
+	case *tg.MessageEntityCode:
+		w.delimiter("`")
+	case *tg.MessageEntityPre:
+		w.delimiter("\n```")
+	case *tg.MessageEntityTextURL:
+		w.delimiter("](" + escapeURL(e.URL) + ")")
+	case *tg.MessageEntityCustomEmoji:
+		w.delimiter("](tg://emoji?id=" + strconv.FormatInt(e.DocumentID, 10) + ")")
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			w.delimiter("||")
+		}
+	default:
+		id, _ := mentionedUser(e)
+		w.delimiter("](" + userLink(id) + ")")
+	}
+}
+
+// delimiter writes the markup of an entity. An empty bold or strikethrough entity separates
+// underscores that would otherwise be read as a single delimiter.
+func (w *markdownV2Writer) delimiter(s string) {
+	if s == "" {
+		return
+	}
+	if w.underscore && s[0] == '_' {
+		if len(w.stack) > 0 && isBold(w.stack[len(w.stack)-1]) {
+			w.WriteString("~~")
+		} else {
+			w.WriteString("**")
+		}
+	}
+	w.WriteString(s)
+	w.underscore = s[len(s)-1] == '_'
+}
+
+func (w *markdownV2Writer) text(s string) {
+	if s == "" {
+		return
+	}
+	w.underscore = false
+
+	code, quoted := w.stack.inCode(), w.stack.quote() != nil
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n' && quoted && !code:
+			w.WriteString("\n>")
+			continue
+		case code && (c == '`' || c == '\\'):
+			w.WriteByte('\\')
+		case !code && (c == '\\' || strings.IndexByte(reservedMarkdownV2, c) >= 0):
+			w.WriteByte('\\')
+		}
+		w.WriteByte(c)
+	}
+}
+
+// escapeURL escapes the URL part of a MarkdownV2 link
+func escapeURL(url string) string {
+	return strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(url)
+}
+
+type htmlWriter struct {
+	strings.Builder
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func (w *htmlWriter) supports(e tg.MessageEntityClass) bool {
+	return telegramMarkup(e)
+}
+
+func (w *htmlWriter) open(e tg.MessageEntityClass) {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.WriteString("<b>")
+	case *tg.MessageEntityItalic:
+		w.WriteString("<i>")
+	case *tg.MessageEntityUnderline:
+		w.WriteString("<u>")
+	case *tg.MessageEntityStrike:
+		w.WriteString("<s>")
+	case *tg.MessageEntitySpoiler:
+		w.WriteString("<tg-spoiler>")
+	case *tg.MessageEntityCode:
+		w.WriteString("<code>")
+	case *tg.MessageEntityPre:
+		if e.Language != "" {
+			w.WriteString(`<pre><code class="language-` + htmlEscaper.Replace(e.Language) + `">`)
+		} else {
+			w.WriteString("<pre>")
+		}
+	case *tg.MessageEntityTextURL:
+		w.WriteString(`<a href="` + htmlEscaper.Replace(e.URL) + `">`)
+	case *tg.MessageEntityCustomEmoji:
+		w.WriteString(`<tg-emoji emoji-id="` + strconv.FormatInt(e.DocumentID, 10) + `">`)
+	case *tg.MessageEntityBlockquote:
+		if e.Collapsed {
+			w.WriteString("<blockquote expandable>")
+		} else {
+			w.WriteString("<blockquote>")
+		}
+	default:
+		id, _ := mentionedUser(e)
+		w.WriteString(`<a href="` + userLink(id) + `">`)
+	}
+}
+
+func (w *htmlWriter) close(e tg.MessageEntityClass) {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.WriteString("</b>")
+	case *tg.MessageEntityItalic:
+		w.WriteString("</i>")
+	case *tg.MessageEntityUnderline:
+		w.WriteString("</u>")
+	case *tg.MessageEntityStrike:
+		w.WriteString("</s>")
+	case *tg.MessageEntitySpoiler:
+		w.WriteString("</tg-spoiler>")
+	case *tg.MessageEntityCode:
+		w.WriteString("</code>")
+	case *tg.MessageEntityPre:
+		if e.Language != "" {
+			w.WriteString("</code></pre>")
+		} else {
+			w.WriteString("</pre>")
+		}
+	case *tg.MessageEntityCustomEmoji:
+		w.WriteString("</tg-emoji>")
+	case *tg.MessageEntityBlockquote:
+		w.WriteString("</blockquote>")
+	default:
+		w.WriteString("</a>")
+	}
+}
+
+func (w *htmlWriter) text(s string) {
+	w.WriteString(htmlEscaper.Replace(s))
+}
+
+type markdownWriter struct {
+	strings.Builder
+	stack markupStack
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "~", `\~`, ">", `\>`, "#", `\#`,
+)
+
+func (w *markdownWriter) supports(e tg.MessageEntityClass) bool {
+	switch e.(type) {
+	case *tg.MessageEntityBold, *tg.MessageEntityItalic, *tg.MessageEntityStrike,
+		*tg.MessageEntityCode, *tg.MessageEntityPre, *tg.MessageEntityTextURL,
+		*tg.MessageEntityBlockquote:
+		return true
+	}
+	_, ok := mentionedUser(e)
+	return ok
+}
+
+func (w *markdownWriter) open(e tg.MessageEntityClass) {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.WriteString("**")
+	case *tg.MessageEntityItalic:
+		w.WriteString("_")
+	case *tg.MessageEntityStrike:
+		w.WriteString("~~")
+	case *tg.MessageEntityCode:
+		w.WriteString("`")
+	case *tg.MessageEntityPre:
+		w.WriteString("```" + e.Language + "\n")
+	case *tg.MessageEntityBlockquote:
+		w.WriteString("> ")
+	default:
+		w.WriteString("[")
+	}
+	w.stack.push(e)
+}
+
+func (w *markdownWriter) close(e tg.MessageEntityClass) {
+	w.stack.pop()
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		w.WriteString("**")
+	case *tg.MessageEntityItalic:
+		w.WriteString("_")
+	case *tg.MessageEntityStrike:
+		w.WriteString("~~")
+	case *tg.MessageEntityCode:
+		w.WriteString("`")
+	case *tg.MessageEntityPre:
+		w.WriteString("\n```")
+	case *tg.MessageEntityTextURL:
+		w.WriteString("](" + e.URL + ")")
+	case *tg.MessageEntityBlockquote:
+	default:
+		id, _ := mentionedUser(e)
+		w.WriteString("](" + userLink(id) + ")")
+	}
+}
+
+func (w *markdownWriter) text(s string) {
+	if !w.stack.inCode() {
+		s = markdownEscaper.Replace(s)
+	}
+	if w.stack.quote() != nil {
+		s = strings.ReplaceAll(s, "\n", "\n> ")
+	}
+	w.WriteString(s)
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+// sortedEntities orders entities by position and type, so lists can be compared regardless of
+// the order the entities were closed in
+func sortedEntities(entities []tg.MessageEntityClass) []tg.MessageEntityClass {
+	sorted := append([]tg.MessageEntityClass(nil), entities...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		ea, eb := sorted[a], sorted[b]
+		if ea.GetOffset() != eb.GetOffset() {
+			return ea.GetOffset() < eb.GetOffset()
+		}
+		if ea.GetLength() != eb.GetLength() {
+			return ea.GetLength() > eb.GetLength()
+		}
+		return fmt.Sprintf("%T", ea) < fmt.Sprintf("%T", eb)
+	})
+	return sorted
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tg.MessageEntityClass
+		markdown string
+		html     string
+	}{
+		{
+			name:     "plain text is escaped",
+			text:     "1 < 2. *not bold*",
+			markdown: "1 < 2. \\*not bold\\*",
+			html:     "1 &lt; 2. *not bold*",
+		},
+		{
+			name: "nested entities",
+			text: "bold italic",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 11},
+				&tg.MessageEntityItalic{Offset: 5, Length: 6},
+			},
+			markdown: "**bold _italic_**",
+			html:     "<b>bold <i>italic</i></b>",
+		},
+		{
+			name: "overlapping entities are split",
+			text: "abc",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 2},
+				&tg.MessageEntityItalic{Offset: 1, Length: 2},
+			},
+			markdown: "**a_b_**_c_",
+			html:     "<b>a<i>b</i></b><i>c</i>",
+		},
+		{
+			name: "offsets count UTF-16 code units",
+			text: "😀 bold 😀 done",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 3, Length: 7},
+			},
+			markdown: "😀 **bold 😀** done",
+			html:     "😀 <b>bold 😀</b> done",
+		},
+		{
+			name: "links and mentions",
+			text: "site user",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com/?a=1&b=2"},
+				&tg.MessageEntityMentionName{Offset: 5, Length: 4, UserID: 42},
+			},
+			markdown: "[site](https://example.com/?a=1&b=2) [user](tg://user?id=42)",
+			html:     `<a href="https://example.com/?a=1&amp;b=2">site</a> <a href="tg://user?id=42">user</a>`,
+		},
+		{
+			name: "code blocks",
+			text: "x := `a`\nnext",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityPre{Offset: 0, Length: 8, Language: "go"},
+			},
+			markdown: "```go\nx := `a`\n```\nnext",
+			html:     `<pre><code class="language-go">x := ` + "`a`" + `</code></pre>` + "\nnext",
+		},
+		{
+			name: "entities detected from the text are left out",
+			text: "@user",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityMention{Offset: 0, Length: 5},
+			},
+			markdown: "@user",
+			html:     "@user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.text, tt.entities, FormatMarkdown); got != tt.markdown {
+				t.Errorf("Render(markdown) = %q, want %q", got, tt.markdown)
+			}
+			if got := Render(tt.text, tt.entities, FormatHTML); got != tt.html {
+				t.Errorf("Render(html) = %q, want %q", got, tt.html)
+			}
+		})
+	}
+}
+
+// TestRender_RoundTrip checks that rendered markup parses back to the same message
+func TestRender_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			name: "reserved characters",
+			text: "Hello world. 1 + 1 = 2 (really) [x] #tag a_b \\ `tick` >quote",
+		},
+		{
+			name: "every style",
+			text: "bold italic underline strike spoiler code link user 👍",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 4},
+				&tg.MessageEntityItalic{Offset: 5, Length: 6},
+				&tg.MessageEntityUnderline{Offset: 12, Length: 9},
+				&tg.MessageEntityStrike{Offset: 22, Length: 6},
+				&tg.MessageEntitySpoiler{Offset: 29, Length: 7},
+				&tg.MessageEntityCode{Offset: 37, Length: 4},
+				&tg.MessageEntityTextURL{Offset: 42, Length: 4, URL: "https://example.com/a)b"},
+				&tg.MessageEntityMentionName{Offset: 47, Length: 4, UserID: 123},
+				&tg.MessageEntityCustomEmoji{Offset: 52, Length: 2, DocumentID: 5368324170671202286},
+			},
+		},
+		{
+			name: "italic and underline next to each other",
+			text: "iu",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityUnderline{Offset: 0, Length: 2},
+				&tg.MessageEntityItalic{Offset: 0, Length: 1},
+			},
+		},
+		{
+			name: "underline ending inside italic",
+			text: "abc",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 0, Length: 3},
+				&tg.MessageEntityUnderline{Offset: 0, Length: 2},
+				&tg.MessageEntityBold{Offset: 2, Length: 1},
+			},
+		},
+		{
+			name: "pre with language",
+			text: "before\nfunc main() {\n\tprintln(`hi`)\n}\nafter",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityPre{Offset: 7, Length: 31, Language: "go"},
+			},
+		},
+		{
+			name: "block quotes",
+			text: "first line\nsecond *line*\nbetween\nhidden\nlines",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBlockquote{Offset: 0, Length: 24},
+				&tg.MessageEntityBold{Offset: 18, Length: 6},
+				&tg.MessageEntityBlockquote{Offset: 33, Length: 12, Collapsed: true},
+			},
+		},
+	}
+
+	parsers := map[Format]func(string) (string, []tg.MessageEntityClass, error){
+		FormatMarkdownV2: func(s string) (string, []tg.MessageEntityClass, error) { return renderMarkdownV2(t, s) },
+		FormatHTML:       func(s string) (string, []tg.MessageEntityClass, error) { return renderHTML(t, s) },
+	}
+
+	for _, tt := range tests {
+		for format, parse := range parsers {
+			t.Run(fmt.Sprintf("%s/%s", tt.name, format), func(t *testing.T) {
+				markup := Render(tt.text, tt.entities, format)
+				text, entities, err := parse(markup)
+				if err != nil {
+					t.Fatalf("parsing %q: %v", markup, err)
+				}
+				if text != tt.text {
+					t.Errorf("text of %q = %q, want %q", markup, text, tt.text)
+				}
+				if len(entities) > 0 || len(tt.entities) > 0 {
+					got, want := sortedEntities(entities), sortedEntities(tt.entities)
+					for i, e := range want {
+						// Mentions are parsed to their input counterpart
+						if m, ok := e.(*tg.MessageEntityMentionName); ok {
+							want[i] = &tg.InputMessageEntityMentionName{
+								Offset: m.Offset,
+								Length: m.Length,
+								UserID: &tg.InputUser{UserID: m.UserID},
+							}
+						}
+					}
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("entities of %q = %+v, want %+v", markup, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEntityText(t *testing.T) {
+	text := "😀 héllo 😀 wörld"
+	e := &tg.MessageEntityCode{Offset: 3, Length: 8}
+	if got := EntityText(text, e); got != "héllo 😀" {
+		t.Errorf("EntityText() = %q, want %q", got, "héllo 😀")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"strings"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// MaxMessageLength is the most UTF-16 code units Telegram allows in the text of a message
+const MaxMessageLength = 4096
+
+// ellipsis is appended to truncated text
+const ellipsis = "…"
+
+// Split splits styled text into messages of at most limit UTF-16 code units each. Messages are
+// split at the last paragraph, line or word break that leaves them at least half full, and
+// between characters when there is none, dropping the break itself. Entities crossing a split
+// are closed at the end of one message and opened again at the start of the next, so a code
+// block stays a code block with the same language in every message. Text that fits in a single
+// message is returned as is.
+func Split(limit int, text ...styling.StyledTextOption) ([][]styling.StyledTextOption, error) {
+	plain, entities, err := build(text)
+	if err != nil {
+		return nil, err
+	}
+	index := utf16Index(plain)
+	size := len(index) - 1
+	if size <= limit {
+		return [][]styling.StyledTextOption{text}, nil
+	}
+
+	var parts [][]styling.StyledTextOption
+	for start := 0; start < size; {
+		end, next := splitPoint(plain, index, start, limit)
+		parts = append(parts, section(plain, index, entities, start, end))
+		start = next
+	}
+	return parts, nil
+}
+
+// Truncate shortens styled text to at most limit UTF-16 code units, cutting it at the same
+// places Split would and appending an ellipsis. Text that fits is returned as is.
+func Truncate(limit int, text ...styling.StyledTextOption) ([]styling.StyledTextOption, error) {
+	plain, entities, err := build(text)
+	if err != nil {
+		return nil, err
+	}
+	index := utf16Index(plain)
+	if len(index)-1 <= limit {
+		return text, nil
+	}
+
+	end, _ := splitPoint(plain, index, 0, limit-entity.ComputeLength(ellipsis))
+	return append(section(plain, index, entities, 0, end), styling.Plain(ellipsis)), nil
+}
+
+// build performs styled text options, returning the text and entities they produce
+func build(text []styling.StyledTextOption) (string, []tg.MessageEntityClass, error) {
+	var b entity.Builder
+	if err := styling.Perform(&b, text...); err != nil {
+		return "", nil, err
+	}
+	plain, entities := b.Raw()
+	return plain, entities, nil
+}
+
+// splitPoint returns the UTF-16 offset a message starting at from ends at, and the one the next
+// message starts at, which differ by the length of the break between them
+func splitPoint(text string, index []int, from, limit int) (end, next int) {
+	size := len(index) - 1
+	if size-from <= limit {
+		return size, size
+	}
+	limit = max(limit, 1)
+
+	// A break right after the limit still ends the message in time
+	window := text[index[from]:index[from+limit+1]]
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(window, sep); i > 0 && i >= len(window)/2 {
+			end = from + entity.ComputeLength(window[:i])
+			return end, end + len(sep)
+		}
+	}
+	window = text[index[from]:index[from+limit]]
+
+	// Characters are never cut in half, but at least one is taken so splitting makes progress
+	end = from + max(entity.ComputeLength(window), 1)
+	if index[end] == index[from] {
+		end++
+	}
+	return end, end
+}
+
+// section returns the text between two UTF-16 offsets as styled text options, along with the
+// parts of the entities inside it
+func section(text string, index []int, entities []tg.MessageEntityClass, from, to int) []styling.StyledTextOption {
+	w := &nodeWriter{stack: []*styledNode{{}}}
+	writeMarkup(w, text, index, entities, from, to)
+	return w.stack[0].options()
+}
+
+// nodeWriter turns text and entities back into a tree of styled nodes
+type nodeWriter struct {
+	stack []*styledNode
+}
+
+func (w *nodeWriter) supports(e tg.MessageEntityClass) bool {
+	return entityFormat(e) != nil
+}
+
+func (w *nodeWriter) open(tg.MessageEntityClass) {
+	w.stack = append(w.stack, &styledNode{})
+}
+
+func (w *nodeWriter) close(e tg.MessageEntityClass) {
+	c := w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	w.stack[len(w.stack)-1].attach(c, entityFormat(e))
+}
+
+func (w *nodeWriter) text(s string) {
+	if s != "" {
+		w.stack[len(w.stack)-1].add(&styledNode{text: s})
+	}
+}
+
+func (w *nodeWriter) String() string {
+	return w.stack[0].plain()
+}
+
+// entityFormat returns the formatter that recreates an entity elsewhere in a message, or nil
+// for unknown entities
+func entityFormat(e tg.MessageEntityClass) entity.Formatter {
+	switch e := e.(type) {
+	case *tg.MessageEntityBold:
+		return entity.Bold()
+	case *tg.MessageEntityItalic:
+		return entity.Italic()
+	case *tg.MessageEntityUnderline:
+		return entity.Underline()
+	case *tg.MessageEntityStrike:
+		return entity.Strike()
+	case *tg.MessageEntitySpoiler:
+		return entity.Spoiler()
+	case *tg.MessageEntityCode:
+		return entity.Code()
+	case *tg.MessageEntityPre:
+		return entity.Pre(e.Language)
+	case *tg.MessageEntityTextURL:
+		return entity.TextURL(e.URL)
+	case *tg.MessageEntityMentionName:
+		return entity.MentionName(&tg.InputUser{UserID: e.UserID})
+	case *tg.InputMessageEntityMentionName:
+		return entity.MentionName(e.UserID)
+	case *tg.MessageEntityCustomEmoji:
+		return entity.CustomEmoji(e.DocumentID)
+	case *tg.MessageEntityBlockquote:
+		return entity.Blockquote(e.Collapsed)
+	case *tg.MessageEntityMention:
+		return entity.Mention()
+	case *tg.MessageEntityHashtag:
+		return entity.Hashtag()
+	case *tg.MessageEntityCashtag:
+		return entity.Cashtag()
+	case *tg.MessageEntityBotCommand:
+		return entity.BotCommand()
+	case *tg.MessageEntityURL:
+		return entity.URL()
+	case *tg.MessageEntityEmail:
+		return entity.Email()
+	case *tg.MessageEntityPhone:
+		return entity.Phone()
+	case *tg.MessageEntityBankCard:
+		return entity.BankCard()
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Chris Watson
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package styling
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// splitMessage is the text and entities of one part of a split message
+type splitMessage struct {
+	text     string
+	entities []tg.MessageEntityClass
+}
+
+func performSplit(t *testing.T, limit int, text ...styling.StyledTextOption) []splitMessage {
+	t.Helper()
+	parts, err := Split(limit, text...)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	var messages []splitMessage
+	for _, part := range parts {
+		plain, entities, err := build(part)
+		if err != nil {
+			t.Fatalf("Perform() error = %v", err)
+		}
+		if n := entity.ComputeLength(plain); n > limit {
+			t.Errorf("part %q is %d long, want at most %d", plain, n, limit)
+		}
+		messages = append(messages, splitMessage{plain, sortedEntities(entities)})
+	}
+	return messages
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		text  []styling.StyledTextOption
+		want  []splitMessage
+	}{
+		{
+			name:  "short text is kept whole",
+			limit: 10,
+			text:  []styling.StyledTextOption{styling.Bold("short")},
+			want:  []splitMessage{{"short", []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 5}}}},
+		},
+		{
+			name:  "paragraphs come before lines",
+			limit: 20,
+			text:  []styling.StyledTextOption{styling.Plain("first part\n\nsecond\nthird")},
+			want:  []splitMessage{{"first part", nil}, {"second\nthird", nil}},
+		},
+		{
+			name:  "lines come before words",
+			limit: 15,
+			text:  []styling.StyledTextOption{styling.Plain("one two six\nthree four five")},
+			want:  []splitMessage{{"one two six", nil}, {"three four five", nil}},
+		},
+		{
+			name:  "words",
+			limit: 10,
+			text:  []styling.StyledTextOption{styling.Plain("alpha beta gamma")},
+			want:  []splitMessage{{"alpha beta", nil}, {"gamma", nil}},
+		},
+		{
+			name:  "breaks too early are skipped",
+			limit: 10,
+			text:  []styling.StyledTextOption{styling.Plain("a bcdefghijklmn")},
+			want:  []splitMessage{{"a bcdefghi", nil}, {"jklmn", nil}},
+		},
+		{
+			name:  "characters aren't cut in half",
+			limit: 5,
+			text:  []styling.StyledTextOption{styling.Plain("ab😀😀😀")},
+			want:  []splitMessage{{"ab😀", nil}, {"😀😀", nil}},
+		},
+		{
+			name:  "code blocks are closed and reopened",
+			limit: 12,
+			text: []styling.StyledTextOption{
+				styling.Plain("out:\n"),
+				styling.Pre("line one\nline two", "go"),
+			},
+			want: []splitMessage{
+				{"out:\nline", []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 5, Length: 4, Language: "go"}}},
+				{"one\nline two", []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 12, Language: "go"}}},
+			},
+		},
+		{
+			name:  "nested entities",
+			limit: 11,
+			text: []styling.StyledTextOption{
+				styling.Custom(func(eb *entity.Builder) error {
+					tok := eb.Token()
+					eb.Plain("bold ")
+					eb.Format("italic text", entity.Italic())
+					tok.Apply(eb, entity.Bold())
+					return nil
+				}),
+			},
+			want: []splitMessage{
+				{"bold italic", []tg.MessageEntityClass{
+					&tg.MessageEntityBold{Offset: 0, Length: 11},
+					&tg.MessageEntityItalic{Offset: 5, Length: 6},
+				}},
+				{"text", []tg.MessageEntityClass{
+					&tg.MessageEntityBold{Offset: 0, Length: 4},
+					&tg.MessageEntityItalic{Offset: 0, Length: 4},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := performSplit(t, tt.limit, tt.text...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Split() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].text != tt.want[i].text {
+					t.Errorf("part %d text = %q, want %q", i, got[i].text, tt.want[i].text)
+				}
+				if (len(got[i].entities) > 0 || len(tt.want[i].entities) > 0) && !reflect.DeepEqual(got[i].entities, tt.want[i].entities) {
+					t.Errorf("part %d entities = %+v, want %+v", i, got[i].entities, tt.want[i].entities)
+				}
+			}
+		})
+	}
+}
+
+func TestSplit_MaxMessageLength(t *testing.T) {
+	line := strings.Repeat("é", 99) + "\n"
+	text := strings.Repeat(line, 100)
+	parts := performSplit(t, MaxMessageLength, styling.Pre(text, ""))
+	if len(parts) != 3 {
+		t.Fatalf("Split() gave %d parts, want 3", len(parts))
+	}
+
+	var joined []string
+	for _, part := range parts {
+		if len(part.entities) != 1 {
+			t.Fatalf("part has entities %+v, want a single pre", part.entities)
+		}
+		joined = append(joined, part.text)
+	}
+	if strings.Join(joined, "\n") != text {
+		t.Errorf("joined parts differ from the text")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	opts, err := Truncate(12, styling.Bold("hello big world"))
+	if err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	text, entities, err := build(opts)
+	if err != nil {
+		t.Fatalf("Perform() error = %v", err)
+	}
+	if text != "hello big…" {
+		t.Errorf("Truncate() text = %q, want %q", text, "hello big…")
+	}
+	want := []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 9}}
+	if !reflect.DeepEqual(entities, want) {
+		t.Errorf("Truncate() entities = %+v, want %+v", entities, want)
+	}
+
+	opts, err = Truncate(30, styling.Plain("short"))
+	if err != nil || len(opts) != 1 {
+		t.Errorf("Truncate() of short text = %v, %v, want it unchanged", opts, err)
+	}
+}
@@ -0,0 +1,44 @@
+package utilities
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+// FloodWait returns a client middleware that retries requests rejected with FLOOD_WAIT_X once
+// the requested wait has passed. Requests are retried up to maxRetries times, and waits longer
+// than maxWait are returned to the caller as-is instead of blocking the update loop.
+func FloodWait(lg *zap.Logger, maxRetries int, maxWait time.Duration) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			for attempt := 1; ; attempt++ {
+				err := next.Invoke(ctx, input, output)
+				wait, ok := tgerr.AsFloodWait(err)
+				if !ok || attempt > maxRetries || wait > maxWait {
+					return err
+				}
+
+				// Back off a little longer on every attempt in case the wait was rounded down
+				wait += time.Duration(attempt) * time.Second
+				lg.Warn("Flood wait, retrying request",
+					zap.Duration("wait", wait),
+					zap.Int("attempt", attempt),
+				)
+
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				}
+			}
+		}
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/celestix/gotgproto/types"
 	"github.com/fogleman/gg"
 	"github.com/gotd/td/tg"
+	"github.com/watzon/macron/styling"
 )
 
 // MessageStyle contains styling information for a message
@@ -129,38 +130,9 @@ func GetUserAvatar(ctx *ext.Context, user *types.User) (image.Image, error) {
 	return img, nil
 }
 
-// ProcessMessageEntities applies formatting to message text based on entities
+// ProcessMessageEntities applies formatting to message text based on entities, as Markdown
 func ProcessMessageEntities(text string, entities []tg.MessageEntityClass) string {
-	if len(entities) == 0 {
-		return text
-	}
-
-	// Sort entities by offset to process them in order
-	// TODO: Implement sorting if needed
-
-	var result string
-	lastPos := 0
-
-	for _, entity := range entities {
-		switch e := entity.(type) {
-		case *tg.MessageEntityBold:
-			result += text[lastPos:e.Offset]
-			result += fmt.Sprintf("**%s**", text[e.Offset:e.Offset+e.Length])
-			lastPos = e.Offset + e.Length
-		case *tg.MessageEntityItalic:
-			result += text[lastPos:e.Offset]
-			result += fmt.Sprintf("_%s_", text[e.Offset:e.Offset+e.Length])
-			lastPos = e.Offset + e.Length
-		case *tg.MessageEntityCode:
-			result += text[lastPos:e.Offset]
-			result += fmt.Sprintf("`%s`", text[e.Offset:e.Offset+e.Length])
-			lastPos = e.Offset + e.Length
-			// Add more entity types as needed
-		}
-	}
-
-	result += text[lastPos:]
-	return result
+	return styling.Render(text, entities, styling.FormatMarkdown)
 }
 
 // GenerateMessageScreenshot creates an image containing the messages
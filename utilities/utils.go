@@ -227,7 +227,7 @@ func GetUserFromArgs(ctx *ext.Context, args *command.Arguments) (*types.User, er
 		// Try to resolve user from the provided argument
 		basicUser, err = ResolveUser(ctx, args.GetPositionalEntity(0))
 		if err != nil {
-			return nil, fmt.Errorf("failed to resolve user: %w", err)
+			return nil, command.UserErrorf("failed to resolve user: %v", err)
 		}
 	} else if args.Reply != nil {
 		// Try to get the user from the replied message
@@ -237,10 +237,10 @@ func GetUserFromArgs(ctx *ext.Context, args *command.Arguments) (*types.User, er
 				return nil, fmt.Errorf("failed to get user from replied message: %w", err)
 			}
 		} else {
-			return nil, fmt.Errorf("could not get replied message")
+			return nil, command.UserErrorf("could not get replied message")
 		}
 	} else {
-		return nil, fmt.Errorf("please provide a username/ID or reply to a message")
+		return nil, command.UserErrorf("please provide a username/ID or reply to a message")
 	}
 
 	return basicUser, nil